@@ -361,11 +361,14 @@ func TestTreasury(t *testing.T) {
 	tspendYesHash := tspendYes.TxHash()
 	tspendNoHash := tspendNo.TxHash()
 	tspendLargeHash := tspendLarge.TxHash()
-	vw.VoteForTSpends([]*stake.TreasuryVoteTuple{
+	err = vw.VoteForTSpends([]*stake.TreasuryVoteTuple{
 		{Hash: tspendYesHash, Vote: stake.TreasuryVoteYes},
 		{Hash: tspendNoHash, Vote: stake.TreasuryVoteNo},
 		{Hash: tspendLargeHash, Vote: stake.TreasuryVoteYes},
 	})
+	if err != nil {
+		t.Fatalf("unable to vote for tspends: %v", err)
+	}
 
 	// Publish the tspends so the node will include them once they're
 	// approved.
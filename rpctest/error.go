@@ -0,0 +1,85 @@
+// Copyright (c) 2022 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpctest
+
+// WalletErrorKind identifies a kind of error encountered by the voting wallet.
+// It has full support for errors.Is, so the caller can directly check against
+// an error kind when determining the reason for an error.
+type WalletErrorKind string
+
+// These constants are used to identify a specific WalletError.
+const (
+	// ErrFundingFailed indicates the wallet was unable to fund its stake
+	// transactions, either because the harness wallet failed to send funds
+	// or because the wallet does not have enough available outputs.
+	ErrFundingFailed = WalletErrorKind("ErrFundingFailed")
+
+	// ErrQueryFailed indicates a query to the node backing the wallet
+	// failed.
+	ErrQueryFailed = WalletErrorKind("ErrQueryFailed")
+
+	// ErrTxCreationFailed indicates the wallet was unable to create a
+	// stake transaction or created one that is not valid.
+	ErrTxCreationFailed = WalletErrorKind("ErrTxCreationFailed")
+
+	// ErrSignFailed indicates the wallet was unable to sign a stake
+	// transaction.
+	ErrSignFailed = WalletErrorKind("ErrSignFailed")
+
+	// ErrBroadcastFailed indicates a stake transaction of the wallet was
+	// rejected by the network.
+	ErrBroadcastFailed = WalletErrorKind("ErrBroadcastFailed")
+
+	// ErrTicketPriceRejected indicates a ticket of the wallet was rejected
+	// by the network because its price is below the stake difficulty. This
+	// may happen when tickets are purchased at exactly the stake difficulty
+	// and a block that increases it is connected before they are sent, so
+	// it never stops a wallet that fails fast.
+	ErrTicketPriceRejected = WalletErrorKind("ErrTicketPriceRejected")
+
+	// ErrNotificationDecodeFailed indicates the wallet was unable to decode
+	// the block or transactions of a notification from the node.
+	ErrNotificationDecodeFailed = WalletErrorKind("ErrNotificationDecodeFailed")
+
+	// ErrNotificationBacklog indicates the notifications from the node
+	// queued for the wallet reached the high-water mark set by
+	// SetNotificationBacklogWarning.
+	ErrNotificationBacklog = WalletErrorKind("ErrNotificationBacklog")
+)
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e WalletErrorKind) Error() string {
+	return string(e)
+}
+
+// WalletError identifies an error encountered by the voting wallet while
+// handling notifications from the node. It has full support for errors.Is and
+// errors.As, so the caller can check against both the kind of the error and the
+// underlying error.
+type WalletError struct {
+	Kind WalletErrorKind
+	Err  error
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e WalletError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying wrapped error.
+func (e WalletError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns whether the target is the kind of the error.
+func (e WalletError) Is(target error) bool {
+	kind, ok := target.(WalletErrorKind)
+	return ok && kind == e.Kind
+}
+
+// walletError creates a WalletError of the given kind wrapping the given error.
+func walletError(kind WalletErrorKind, err error) WalletError {
+	return WalletError{Kind: kind, Err: err}
+}
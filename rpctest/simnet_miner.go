@@ -110,7 +110,43 @@ func waitPredicate(pred func() bool, timeout time.Duration) error {
 // This is only applicable for tests that run on simnet or other networks that
 // have a target block per count of 1 second.
 func AdjustedSimnetMiner(ctx context.Context, client *rpcclient.Client, nb uint32) ([]*chainhash.Hash, error) {
+	// For block heights other then the premine, register header as one
+	// second after the previous block to ensure difficulty does not
+	// increase.
+	adjustTimestamp := func(header *wire.BlockHeader) error {
+		if header.Height > 1 {
+			prevBlock, err := client.GetBlock(ctx, &header.PrevBlock)
+			if err != nil {
+				return err
+			}
+
+			header.Timestamp = prevBlock.Header.Timestamp.Add(time.Second)
+		}
+		return nil
+	}
+	return mineSimnetBlocks(ctx, client, nb, adjustTimestamp)
+}
+
+// TimestampedSimnetMiner is an alternative miner function that works in the
+// same manner as AdjustedSimnetMiner, except that the timestamp of each block
+// is the one returned by blockTime for the height of the block.
+//
+// The caller is responsible for providing timestamps that are valid for the
+// chain, that is, after the median time of the previous blocks and not too far
+// in the future. Note that timestamps further apart than one second trigger
+// difficulty changes on simnet.
+func TimestampedSimnetMiner(ctx context.Context, client *rpcclient.Client, nb uint32, blockTime func(height uint32) time.Time) ([]*chainhash.Hash, error) {
+	setTimestamp := func(header *wire.BlockHeader) error {
+		header.Timestamp = blockTime(header.Height)
+		return nil
+	}
+	return mineSimnetBlocks(ctx, client, nb, setTimestamp)
+}
 
+// mineSimnetBlocks mines nb blocks from the work provided by the node, with the
+// timestamp of each block header set by adjustTimestamp, and publishes them to
+// the node.
+func mineSimnetBlocks(ctx context.Context, client *rpcclient.Client, nb uint32, adjustTimestamp func(header *wire.BlockHeader) error) ([]*chainhash.Hash, error) {
 	hashes := make([]*chainhash.Hash, nb)
 
 	prevWork, err := client.GetWork(ctx)
@@ -152,16 +188,8 @@ func AdjustedSimnetMiner(ctx context.Context, client *rpcclient.Client, nb uint3
 			return nil, err
 		}
 
-		// For block heights other then the premine, register header as
-		// one second after the previous block to ensure difficulty
-		// does not increase.
-		if header.Height > 1 {
-			prevBlock, err := client.GetBlock(ctx, &header.PrevBlock)
-			if err != nil {
-				return nil, err
-			}
-
-			header.Timestamp = prevBlock.Header.Timestamp.Add(time.Second)
+		if err := adjustTimestamp(&header); err != nil {
+			return nil, err
 		}
 		solved := solveBlock(&header)
 		if !solved {
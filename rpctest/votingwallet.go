@@ -5,10 +5,19 @@
 package rpctest
 
 import (
+	"bytes"
 	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"math"
+	"math/rand"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/decred/dcrd/blockchain/stake/v5"
@@ -17,20 +26,46 @@ import (
 	"github.com/decred/dcrd/chaincfg/v3"
 	"github.com/decred/dcrd/dcrec"
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrjson/v4"
 	"github.com/decred/dcrd/dcrutil/v4"
 	dcrdtypes "github.com/decred/dcrd/rpc/jsonrpc/types/v4"
 	"github.com/decred/dcrd/rpcclient/v8"
 	"github.com/decred/dcrd/txscript/v4"
 	"github.com/decred/dcrd/txscript/v4/sign"
 	"github.com/decred/dcrd/txscript/v4/stdaddr"
+	"github.com/decred/dcrd/txscript/v4/stdscript"
 	"github.com/decred/dcrd/wire"
+	"github.com/decred/slog"
+	"github.com/jrick/bitset"
 )
 
 var (
-	// feeRate used when sending voting wallet transactions.
+	// ErrInsufficientLiveTickets is returned by GenerateBlocks when the live
+	// ticket pool does not hold enough tickets to provide the votes required
+	// to generate the next block.
+	ErrInsufficientLiveTickets = errors.New("insufficient live tickets")
+
+	// ErrStartedLate is returned by WaitForSync when the wallet was started
+	// after the height at which it needs to start purchasing tickets to
+	// keep the chain going past SVH.
+	ErrStartedLate = errors.New("wallet started after the ticket purchase " +
+		"start height")
+
+	// feeRate is the default fee rate (in atoms/kB) used when sending voting
+	// wallet transactions.
 	feeRate = dcrutil.Amount(1e4)
 
-	// hardcodedPrivateKey used for all signing operations.
+	// transientRejectReasons are the fragments of the reasons given by the
+	// network nodes for rejecting transactions that spend outputs not yet
+	// known to their mempool, such as the outputs of a transaction that was
+	// sent concurrently and has not been accepted yet.
+	transientRejectReasons = []string{
+		"orphan transaction",
+		"either does not exist or has already been spent",
+	}
+
+	// hardcodedPrivateKey is the private key used for all signing operations
+	// of the wallets created by NewVotingWallet.
 	hardcodedPrivateKey = []byte{
 		0x79, 0xa6, 0x1a, 0xdb, 0xc6, 0xe5, 0xa2, 0xe1,
 		0x39, 0xd2, 0x71, 0x3a, 0x54, 0x6e, 0xc7, 0xc8,
@@ -38,9 +73,9 @@ var (
 		0xa6, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 	}
 
-	// nullPay2SSTXChange is the pkscript used on sstxchange outputs of the
-	// tickets purchased by the voting wallet. This sends all change into a
-	// null address, effectively discarding it.
+	// nullPay2SSTXChange is the default pkscript used on sstxchange outputs
+	// of the tickets purchased by the voting wallet. This sends all change
+	// into a null address, effectively discarding it.
 	nullPay2SSTXChange = []byte{
 		0xbd, 0xa9, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00,
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
@@ -51,19 +86,101 @@ var (
 	// inputs of vote transactions.
 	stakebaseOutPoint = wire.OutPoint{Index: math.MaxUint32}
 
-	// commitAmountMultiplier is a multiplier for the minimum stake difficulty,
-	// used to fund inputs used in purchasing tickets. This needs to be high
-	// enough that (minimumStakeDifficulty*commitAmountMultiplier) -
+	// commitAmountMultiplier is the default multiplier for the minimum stake
+	// difficulty, used to fund inputs used in purchasing tickets. This needs
+	// to be high enough that (minimumStakeDifficulty*commitAmountMultiplier) -
 	// minimumStakeDifficulty is greater than the dust limit and will allow the
 	// ticket to be relayed on the network.
 	commitAmountMultiplier = int64(4)
 )
 
+const (
+	// p2pkhSigScriptSize is the largest number of bytes of a sigScript which
+	// spends a p2pkh output: OP_DATA_73 <sig> OP_DATA_33 <pubkey>.
+	p2pkhSigScriptSize = 1 + 73 + 1 + 33
+
+	// tspendSigScriptSize is the size of the signature script of a treasury
+	// spend: OP_DATA_64 <schnorr signature> OP_DATA_33 <pi pubkey>
+	// OP_TSPEND.
+	tspendSigScriptSize = 1 + 64 + 1 + 33 + 1
+
+	// defaultVoteFeeLimit and defaultRevokeFeeLimit are the fee limits
+	// encoded in the commitment outputs of tickets purchased by the voting
+	// wallet.
+	defaultVoteFeeLimit   = 0
+	defaultRevokeFeeLimit = 16777216
+
+	// voteBitsBlockValid is the vote bit that approves the previous block.
+	voteBitsBlockValid = 0x0001
+
+	// p2pkhDustLimit is the largest amount of a p2pkh output considered dust
+	// by the network nodes with the default minimum relay fee.
+	p2pkhDustLimit = 6030
+
+	// stopTimeout is the maximum amount of time to wait for the connection to
+	// the node to shut down when stopping the wallet.
+	stopTimeout = time.Second * 10
+
+	// defaultBlockGenTimeout is the default maximum amount of time to wait
+	// for the votes and tickets of the wallet to reach the mempool after
+	// generating each block.
+	defaultBlockGenTimeout = time.Second * 5
+
+	// defaultBlockGenPollInterval is the default interval at which the
+	// mempool is checked for the votes and tickets of the wallet after
+	// generating each block.
+	defaultBlockGenPollInterval = time.Millisecond * 2
+
+	// sendRetryAttempts is the maximum number of times a transaction is
+	// sent to the network while sending it fails with a transient error.
+	sendRetryAttempts = 5
+
+	// sendRetryDelay is the delay before resending a transaction after the
+	// first transient failure, which doubles after every further failure.
+	sendRetryDelay = time.Millisecond * 50
+
+	// queryRetryAttempts is the maximum number of times a query required
+	// to generate blocks is attempted while it fails.
+	queryRetryAttempts = 5
+
+	// queryRetryDelay is the delay before retrying a failed query, which
+	// increases linearly with the number of failures in the same manner as
+	// the connection attempts of NewVotingWallet.
+	queryRetryDelay = time.Millisecond * 50
+
+	// missedVoteSeed is the seed of the source of randomness used to select
+	// the votes skipped when simulating missed votes, such that tests are
+	// reproducible.
+	missedVoteSeed = 0x6d6973736564
+
+	// voteSelectionSeed is the seed of the source of randomness used to
+	// select the winning tickets that vote with VoteSelectionRandom, such
+	// that tests are reproducible.
+	voteSelectionSeed = 0x73656c656374
+
+	// eventsBufferLen is the number of events buffered by the event stream
+	// of the wallet before further events are dropped.
+	eventsBufferLen = 256
+
+	// defaultWinHistorySize is the default number of winning tickets
+	// notifications relevant to the wallet recorded for RecentWins.
+	defaultWinHistorySize = 256
+
+	// defaultNotificationBufferLen is the default length of the buffers of
+	// the notification channels. As long as we don't get notifications
+	// faster than this, we should be fine.
+	defaultNotificationBufferLen = 20
+)
+
 type blockConnectedNtfn struct {
 	blockHeader  []byte
 	transactions [][]byte
 }
 
+type blockDisconnectedNtfn struct {
+	blockHeader []byte
+}
+
 type winningTicketsNtfn struct {
 	blockHash      *chainhash.Hash
 	blockHeight    int64
@@ -72,11 +189,323 @@ type winningTicketsNtfn struct {
 
 type ticketInfo struct {
 	ticketPrice int64
+
+	// utxo is the output that funded the ticket, which is returned to the
+	// wallet when the ticket is dropped from the network due to a reorg.
+	utxo utxoInfo
+
+	// minedHeight is the height of the block that includes the ticket and
+	// expiryHeight is the height at which the ticket expires if it has not
+	// voted. Both are zero until the ticket is mined.
+	minedHeight  int64
+	expiryHeight int64
+
+	// revoked indicates the ticket was revoked or a revocation for it was
+	// published.
+	revoked bool
+}
+
+// voteRecord records the vote cast by a ticket of the wallet.
+type voteRecord struct {
+	blockHash   chainhash.Hash
+	blockHeight int64
+	voteHash    chainhash.Hash
+}
+
+// blockParticipation records the number of votes of the wallet included in a
+// block along with the vote limit of the wallet when the block was connected.
+type blockParticipation struct {
+	votes int
+	limit int
+}
+
+// castVoteCount records the number of votes cast by the wallet on a block.
+type castVoteCount struct {
+	blockHeight int64
+	nbVotes     int
+}
+
+// submittedTxs records the votes and tickets the wallet submitted in response
+// to a block, which are pending inclusion in the next block.
+type submittedTxs struct {
+	blockHeight int64
+	votes       []chainhash.Hash
+	tickets     []chainhash.Hash
+
+	// votesDone and ticketsDone indicate the wallet finished submitting
+	// the votes and tickets for the block.
+	votesDone   bool
+	ticketsDone bool
 }
 
 type utxoInfo struct {
 	outpoint wire.OutPoint
 	amount   int64
+
+	// revocation indicates the output was created by a revocation instead
+	// of a vote. It is only meaningful for outputs in the stake tree.
+	revocation bool
+
+	// taddChange indicates the output is the change of a treasury add
+	// instead of a vote. It is only meaningful for outputs in the stake
+	// tree.
+	taddChange bool
+
+	// ticketChange indicates the output is the change of a ticket of the
+	// wallet that recycles its change. It is only meaningful for outputs in
+	// the stake tree.
+	ticketChange bool
+}
+
+// TxFeeInfo describes the fee paid by a stake transaction published by the
+// voting wallet.
+type TxFeeInfo struct {
+	Hash     chainhash.Hash
+	TxType   stake.TxType
+	Size     int
+	Fee      dcrutil.Amount
+	FeePerKB dcrutil.Amount
+}
+
+// newTxFeeInfo returns the fee information for the given transaction, which
+// spends inputs that sum to the given amount.
+func newTxFeeInfo(hash *chainhash.Hash, tx *wire.MsgTx, txType stake.TxType, inputAmount int64) *TxFeeInfo {
+	fee := inputAmount
+	for _, txOut := range tx.TxOut {
+		fee -= txOut.Value
+	}
+	size := tx.SerializeSize()
+	return &TxFeeInfo{
+		Hash:     *hash,
+		TxType:   txType,
+		Size:     size,
+		Fee:      dcrutil.Amount(fee),
+		FeePerKB: dcrutil.Amount(fee * 1000 / int64(size)),
+	}
+}
+
+// VoteSummary describes how the voting wallet handled its winning tickets for a
+// block, which explains why the wallet cast fewer votes than expected.
+type VoteSummary struct {
+	// BlockHash and BlockHeight identify the block being voted on.
+	BlockHash   chainhash.Hash
+	BlockHeight int64
+
+	// Winners is the number of winning tickets owned by the wallet.
+	Winners int
+
+	// Cast is the number of votes the wallet successfully published.
+	Cast int
+
+	// Limited is the number of winning tickets that did not vote due to
+	// the limit on the number of votes cast per block.
+	Limited int
+
+	// Missed is the number of winning tickets that did not vote due to the
+	// missed vote rate.
+	Missed int
+
+	// Duplicates is the number of winning tickets that did not vote again
+	// because they already voted on the same block.
+	Duplicates int
+}
+
+// WinRecord describes the tickets of the voting wallet selected to vote on a
+// block.
+type WinRecord struct {
+	// BlockHash and BlockHeight identify the block the tickets were
+	// selected to vote on.
+	BlockHash   chainhash.Hash
+	BlockHeight int64
+
+	// Tickets are the hashes of the winning tickets owned by the wallet.
+	Tickets []chainhash.Hash
+}
+
+// WalletState is a checkpoint of the funds and tickets tracked by a voting
+// wallet, created by Snapshot and applied by Restore. It is a deep copy, so it
+// is unaffected by the wallet after it is created and may be restored any
+// number of times.
+type WalletState struct {
+	utxos         []utxoInfo
+	tickets       map[chainhash.Hash]ticketInfo
+	maturingVotes map[int64][]utxoInfo
+}
+
+// copy returns a deep copy of the state.
+func (s *WalletState) copy() WalletState {
+	c := WalletState{
+		utxos:         append([]utxoInfo(nil), s.utxos...),
+		tickets:       make(map[chainhash.Hash]ticketInfo, len(s.tickets)),
+		maturingVotes: make(map[int64][]utxoInfo, len(s.maturingVotes)),
+	}
+	for hash, ticket := range s.tickets {
+		c.tickets[hash] = ticket
+	}
+	for height, utxos := range s.maturingVotes {
+		c.maturingVotes[height] = append([]utxoInfo(nil), utxos...)
+	}
+	return c
+}
+
+// winHistory is a bounded ring buffer of the most recent win records of the
+// wallet.
+type winHistory struct {
+	records []WinRecord
+	next    int
+	count   int
+}
+
+// add records the given win record, replacing the oldest record when the
+// history is full.
+func (h *winHistory) add(record WinRecord) {
+	if len(h.records) == 0 {
+		return
+	}
+	h.records[h.next] = record
+	h.next = (h.next + 1) % len(h.records)
+	if h.count < len(h.records) {
+		h.count++
+	}
+}
+
+// recent returns up to the given number of the most recent win records,
+// ordered from oldest to newest. A non-positive number returns all of them.
+func (h *winHistory) recent(n int) []WinRecord {
+	if n <= 0 || n > h.count {
+		n = h.count
+	}
+	records := make([]WinRecord, n)
+	start := h.next - n + len(h.records)
+	for i := range records {
+		record := h.records[(start+i)%len(h.records)]
+		record.Tickets = append([]chainhash.Hash(nil), record.Tickets...)
+		records[i] = record
+	}
+	return records
+}
+
+// resize changes the number of win records kept in the history to the given
+// size, keeping the most recent ones.
+func (h *winHistory) resize(size int) {
+	kept := h.recent(size)
+	h.records = make([]WinRecord, size)
+	h.next, h.count = 0, 0
+	for _, record := range kept {
+		h.add(record)
+	}
+}
+
+// StakeActivity describes the network-wide stake activity observed by a voting
+// wallet since it was started.
+type StakeActivity struct {
+	// Height is the height of the most recently connected block.
+	Height int64
+
+	// VotesSeen, TicketsSeen and RevocationsSeen are the total number of
+	// votes, ticket purchases and revocations included in the blocks
+	// connected since the wallet was started.
+	VotesSeen       int64
+	TicketsSeen     int64
+	RevocationsSeen int64
+
+	// LiveTicketPoolSize is the size of the live ticket pool as reported by
+	// the most recently connected block.
+	LiveTicketPoolSize uint32
+}
+
+// BlockStats describes a block generated by a voting wallet along with the
+// stake transactions of the wallet included in it.
+type BlockStats struct {
+	// Hash and Height identify the generated block.
+	Hash   *chainhash.Hash
+	Height int64
+
+	// Header is the decoded header of the block.
+	Header *wire.BlockHeader
+
+	// Votes and Tickets are the hashes of the votes and tickets of the
+	// wallet included in the block.
+	Votes   []*chainhash.Hash
+	Tickets []*chainhash.Hash
+
+	// StakeDifficulty is the stake difficulty of the block as reported by
+	// its header.
+	StakeDifficulty dcrutil.Amount
+}
+
+// BlockResult describes a single block generated by a voting wallet with
+// GenerateBlock. It is the same as the stats of each block generated by
+// GenerateBlocksWithStats.
+type BlockResult = BlockStats
+
+// TSpendPayout describes a payout of a treasury spend created by CreateTSpend.
+type TSpendPayout struct {
+	Address stdaddr.StakeAddress
+	Amount  dcrutil.Amount
+}
+
+// GenerateStats describes the blocks generated by a voting wallet, in the order
+// they were generated.
+type GenerateStats struct {
+	Blocks []BlockStats
+}
+
+// WalletEventType identifies the kind of activity described by a WalletEvent.
+type WalletEventType int
+
+const (
+	// WalletEventTicketPurchased indicates the wallet published a ticket.
+	WalletEventTicketPurchased WalletEventType = iota
+
+	// WalletEventVoteCast indicates the wallet published a vote.
+	WalletEventVoteCast
+
+	// WalletEventRevocation indicates a revocation of a ticket of the
+	// wallet was mined.
+	WalletEventRevocation
+
+	// WalletEventError indicates the wallet encountered an error.
+	WalletEventError
+)
+
+// String returns the human-readable name of the event type.
+func (t WalletEventType) String() string {
+	switch t {
+	case WalletEventTicketPurchased:
+		return "ticket purchased"
+	case WalletEventVoteCast:
+		return "vote cast"
+	case WalletEventRevocation:
+		return "revocation"
+	case WalletEventError:
+		return "error"
+	}
+	return fmt.Sprintf("unknown event type %d", int(t))
+}
+
+// WalletEvent describes an activity of a voting wallet. The fields that are
+// set depend on the type of the event.
+type WalletEvent struct {
+	Type WalletEventType
+
+	// Hash is the hash of the ticket, vote or revocation. It is nil for
+	// errors.
+	Hash *chainhash.Hash
+
+	// TicketHash is the hash of the ticket redeemed by a vote or revocation.
+	TicketHash *chainhash.Hash
+
+	// Height is the height of the block that triggered the purchase of a
+	// ticket, the height of the block a vote votes on or the height of the
+	// block that includes a revocation.
+	Height int64
+
+	// Price is the price of a purchased ticket.
+	Price int64
+
+	// Err is the error encountered by the wallet.
+	Err error
 }
 
 // VotingWallet stores the state for a simulated voting wallet. Once it is
@@ -84,123 +513,513 @@ type utxoInfo struct {
 // tickets and vote on blocks as necessary to keep the chain going.
 //
 // This currently only implements the bare minimum requirements for maintaining
-// a functioning voting wallet and does not handle reorgs, multiple voting and
-// ticket buying wallets, setting vote bits, expired/missed votes, etc.
+// a functioning voting wallet and only handles reorgs of the blocks it has
+// observed being connected.
+//
+// Multiple wallets, usually created with distinct keys by
+// NewVotingWalletWithKey, may be run against the same harness. Each wallet only
+// votes with the tickets it purchased, so the wallets must be configured with
+// SetSharedTicketPool to purchase tickets with whatever funds they hold when
+// they do not own the entire live ticket pool.
 //
-// All operations (after initial funding) are done solely via stake
-// transactions, so no additional regular transactions are published. This is
-// ideal for use in test suites that require a large (greater than SVH) number
-// of blocks.
+// Ticket purchases, votes and revocations are done solely via stake
+// transactions, so after the initial funding the wallet only publishes regular
+// transactions when it is topped up with new outputs from the harness, which
+// requires enabling SetAutoRefund, and when paying other addresses with
+// SendToAddress. This is ideal for use in test suites that require a large
+// (greater than SVH) number of blocks.
 type VotingWallet struct {
+	// droppedEvents is the number of events dropped because the event
+	// stream was full. It is accessed atomically, so it must stay 64-bit
+	// aligned.
+	droppedEvents uint64
+
+	// The counters of the activity of the wallet reported by Metrics. They
+	// are accessed atomically, so they must stay 64-bit aligned.
+	// subsidyEarned is the cumulative stakebase subsidy, in atoms, of the
+	// votes published by the wallet.
+	ticketsPurchased     uint64
+	votesCast            uint64
+	revocationsPublished uint64
+	broadcastErrors      uint64
+	subsidyEarned        int64
+
 	hn         *Harness
 	privateKey []byte
-	address    stdaddr.Address
+	address    stdaddr.StakeAddress
 	c          *rpcclient.Client
 
-	blockConnectedNtfnChan chan blockConnectedNtfn
-	winningTicketsNtfnChan chan winningTicketsNtfn
+	// signer signs the inputs of the transactions created by the wallet
+	// with signatures of type sigType. customSigner indicates the signer
+	// was set with WithTxSigner.
+	signer       TxSigner
+	sigType      dcrec.SignatureType
+	customSigner bool
+
+	// txFilterAddr is the address of the transaction filter loaded in the
+	// node.
+	txFilterAddr stdaddr.Address
+
+	// notificationBufferLen is the length of the buffers of the
+	// notification channels.
+	notificationBufferLen     int
+	blockConnectedNtfnChan    chan blockConnectedNtfn
+	blockDisconnectedNtfnChan chan blockDisconnectedNtfn
+	winningTicketsNtfnChan    chan winningTicketsNtfn
+
+	// backlogHighWater is the number of queued notifications of a kind at
+	// which the wallet reports a backlog, or zero when backlogs are not
+	// reported. It is accessed atomically, so that the notifications are
+	// never delayed by the wallet mutex. blockConnectedBacklogged and
+	// winningTicketsBacklogged indicate the backlog of the respective
+	// notifications was reported and has not receded below the high-water
+	// mark since. The flags are only accessed from the goroutine of the rpc
+	// client, which queues the reports in backlogReports for the
+	// notification handler to report, such that the error reporter is never
+	// called from the goroutine of the rpc client.
+	backlogHighWater         int64
+	blockConnectedBacklogged bool
+	winningTicketsBacklogged bool
+	backlogReports           chan error
+
+	// quit is closed when the wallet is stopped, cancel cancels the context
+	// of the notification handler and wg tracks its goroutine. cancel is
+	// nil until the wallet is started and is protected by cancelMtx, since
+	// a wallet in fail-fast mode may be stopped from the goroutine of the
+	// rpc client while the wallet mutex is held.
+	quit      chan struct{}
+	cancelMtx sync.Mutex
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	stopOnce  sync.Once
+	stopErr   error
 
 	p2sstxVer        uint16
 	p2sstx           []byte
-	commitScriptVer  uint16
-	commitScript     []byte
+	changeScript     []byte
 	p2pkh            []byte
 	p2pkhVer         uint16
-	voteScriptVer    uint16
-	voteScript       []byte
 	voteRetScriptVer uint16
 	voteRetScript    []byte
+	revokeRetScript  []byte
+	stakeChangeVer   uint16
+	stakeChange      []byte
 
-	errorReporter func(error)
+	// commitAmountMultiplier is the multiplier for the minimum stake
+	// difficulty used to fund the inputs of tickets.
+	commitAmountMultiplier int64
+
+	// initialFundingOutputs is the number of outputs funded by Start, or
+	// zero to fund the default number of outputs.
+	initialFundingOutputs int
+
+	// startHeight is the height of the best block when the wallet was
+	// started.
+	startHeight int64
+
+	// log is the logger of the actions of the wallet, which discards all
+	// messages unless set by SetLogger.
+	log slog.Logger
+
+	// failed is closed once the first error encountered while handling
+	// notifications stops a wallet in fail-fast mode and failErr is the
+	// error that stopped the wallet.
+	failOnce sync.Once
+	failed   chan struct{}
+	failErr  error
+
+	// reachedSteadyState indicates the wallet cast a full block worth of
+	// votes. It is only accessed from the notification handler goroutine.
+	reachedSteadyState bool
+
+	// ready is closed once, when the first block at or after SVH that
+	// includes votes of the wallet is connected.
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	// events is the event stream of the wallet activity returned by Events.
+	events chan WalletEvent
 
 	// miner is a function responsible for generating new blocks. If
 	// specified, then this function is used instead of directly calling
 	// the underlying harness' Generate().
 	miner func(context.Context, uint32) ([]*chainhash.Hash, error)
 
+	// batchSize is the maximum number of blocks mined by each call to the
+	// miner when greater than one. See SetBatchMiner.
+	batchSize uint32
+
+	// timestampBase and timestampInterval define the deterministic
+	// timestamps of the generated blocks when the interval is positive.
+	timestampBase     time.Time
+	timestampInterval time.Duration
+
+	// blockGenTimeout and blockGenPollInterval control how GenerateBlocks
+	// waits for the votes and tickets of the wallet after each block.
+	blockGenTimeout      time.Duration
+	blockGenPollInterval time.Duration
+
 	subsidyCache *standalone.SubsidyCache
 
+	// observer indicates the wallet only tracks stake activity and never
+	// purchases tickets or votes.
+	observer bool
+
+	// dryRun indicates the tickets and votes created by the wallet are
+	// passed to dryRunSink instead of being published.
+	dryRun     bool
+	dryRunSink func([]*wire.MsgTx)
+
+	// activeAgendas caches the vote IDs of the agendas that are active,
+	// which is permanent once it happens.
+	activeAgendas map[string]struct{}
+
+	// voteScriptCache caches the vote scripts built for the vote bits and
+	// vote version selected by the vote choices selector. It is only
+	// accessed from the notification handler goroutine.
+	voteScriptCache map[voteBitsVersion][]byte
+
+	// unconfirmedWinners tracks the height of the block on which tickets of
+	// the wallet were selected to vote, until their votes are confirmed.
+	unconfirmedWinners map[chainhash.Hash]int64
+
+	// mtx protects the fields below.
+	mtx sync.Mutex
+
+	// started indicates whether Start or StartFromScan was called.
+	started bool
+
+	// feeRate is the fee rate of the transactions of the wallet that pay
+	// fees. voteFeeLimit and revokeFeeLimit are the fee limits encoded in
+	// the commitments of the tickets of the wallet.
+	feeRate        dcrutil.Amount
+	voteFeeLimit   int64
+	revokeFeeLimit int64
+
+	// errorReporter is called with every error encountered by the wallet.
+	// failFast indicates the first error encountered while handling
+	// notifications stops the wallet.
+	errorReporter func(error)
+	failFast      bool
+
+	// feeReporter is called with the fee information of every stake
+	// transaction successfully published by the wallet.
+	feeReporter func(*TxFeeInfo)
+
+	// onSteadyState is called once, when the wallet first publishes a full
+	// block worth of votes.
+	onSteadyState func(height int64)
+
+	// ticketPurchased is called with every ticket the wallet successfully
+	// publishes as blocks are connected.
+	ticketPurchased func(hash *chainhash.Hash, price int64, height int64)
+
+	// voteCast is called with every vote the wallet successfully
+	// publishes.
+	voteCast func(voteHash *chainhash.Hash, ticketHash *chainhash.Hash, blockHeight int64)
+
+	// voteSummary is called with the summary of every winning tickets
+	// notification handled by the wallet.
+	voteSummary func(summary *VoteSummary)
+
+	// blockVerifier, when set, is called with the number of votes and
+	// tickets of every block generated by the wallet.
+	blockVerifier func(height int64, votesInBlock, ticketsInBlock int) error
+
+	// voteScript is the script with the vote bits cast by the wallet's
+	// votes, which are voteBits under vote version voteVersion.
+	// disapproveBlock indicates the block validity bit of voteBits is
+	// cleared in the script, such that the votes disapprove the regular
+	// transaction tree of the block being voted on.
+	voteScriptVer   uint16
+	voteScript      []byte
+	voteBits        uint16
+	voteVersion     uint32
+	disapproveBlock bool
+
+	// tspends to vote for when generating votes.
+	tspendVotes []*stake.TreasuryVoteTuple
+
+	// missedGracePeriod is the number of blocks past its voting opportunity
+	// that a winning ticket may go without a confirmed vote before it is
+	// considered missed.
+	missedGracePeriod int64
+
+	// earlyMaturityBlocks is the number of blocks before their actual
+	// maturity that the outputs of votes are considered spendable.
+	earlyMaturityBlocks int64
+
+	// voteChoicesSelector, when set, selects the agenda choices cast by
+	// the vote of each individual ticket.
+	voteChoicesSelector VoteChoicesSelector
+
+	// blockRefScriptFunc, when set, builds the block reference script of
+	// the votes instead of txscript.GenerateSSGenBlockRef.
+	blockRefScriptFunc BlockRefScriptFunc
+
+	// stakeBaseSigScript, when set, is the signature script of the
+	// stakebase input of the votes instead of the one of the network.
+	stakeBaseSigScript []byte
+
+	// extraVoteOutput, when set, is the script of an additional null data
+	// output appended to the votes after the standard outputs.
+	extraVoteOutput []byte
+
+	// missedVoteRate is the fraction of the winning tickets of the wallet
+	// that deliberately do not vote, selected by missedVoteRand.
+	// castVotes tracks the number of votes cast on each block while
+	// missed votes are simulated.
+	missedVoteRate float64
+	missedVoteRand *rand.Rand
+	castVotes      map[chainhash.Hash]castVoteCount
+
+	// voteSelection determines which winning tickets of the wallet vote
+	// when the number of votes is limited, with voteSelectionRand as the
+	// source of randomness of VoteSelectionRandom.
+	voteSelection     VoteSelection
+	voteSelectionRand *rand.Rand
+
+	// sharedTicketPool indicates other wallets purchase tickets on the same
+	// network, so the wallet purchases fewer than TicketsPerBlock tickets
+	// when it does not hold enough funds.
+	sharedTicketPool bool
+
+	// limitNbVotes is the maximum number of votes cast by the wallet on
+	// each block.
+	limitNbVotes int
+
+	// ticketsPerBlock is the number of tickets purchased by the wallet on
+	// each block.
+	ticketsPerBlock int
+
+	// ticketBuyingPaused indicates the wallet temporarily purchases no
+	// tickets while still voting.
+	ticketBuyingPaused bool
+
+	// recycleChange indicates the tickets of the wallet pay their change to
+	// the stake change script of the wallet instead of changeScript, such
+	// that it is spendable.
+	recycleChange bool
+
+	// ticketPriceStrategy determines the price of the tickets purchased
+	// by the wallet.
+	ticketPriceStrategy TicketPriceStrategy
+
+	// revocationPolicy determines when the wallet revokes its missed and
+	// expired tickets.
+	revocationPolicy RevocationPolicy
+
+	// sortUtxos indicates the available utxos are sorted by outpoint before
+	// selecting the ones that fund new tickets.
+	sortUtxos bool
+
+	// autoRefund indicates the wallet funds itself with new outputs when
+	// it runs low on outputs to purchase tickets. pendingRefunds tracks the
+	// outputs of the refunds that are not yet mined, since tickets cannot
+	// spend regular tree outputs created in the same block.
+	autoRefund     bool
+	pendingRefunds map[chainhash.Hash][]utxoInfo
+
+	// pendingFunding is the number of outputs the wallet funds itself with
+	// on the next block regardless of auto refunding, to cover the outputs
+	// consumed while the number of votes was limited when the limit is
+	// increased again.
+	pendingFunding int
+
+	// submitted tracks the votes and tickets submitted by the wallet in
+	// response to each recent block, which GenerateBlocks waits for.
+	// lastSubmitted is the most recently created record.
+	submitted     map[chainhash.Hash]*submittedTxs
+	lastSubmitted *submittedTxs
+
 	// utxos are the unspent outpoints not yet locked into a ticket.
 	utxos []utxoInfo
 
-	// tickets map the outstanding unspent tickets
-	tickets map[chainhash.Hash]ticketInfo
-
 	// maturingVotes tracks the votes maturing at each (future) block height,
 	// which will be available for purchasing new tickets.
 	maturingVotes map[int64][]utxoInfo
 
-	// tspends to vote for when generating votes.
-	tspendVotes []*stake.TreasuryVoteTuple
+	// pendingTAdds tracks the change of the treasury adds published by the
+	// wallet until they are mined, keyed by treasury add hash.
+	pendingTAdds map[chainhash.Hash]utxoInfo
 
-	// Limit the total number of votes to that.
-	limitNbVotes int
+	// tickets map the outstanding unspent tickets
+	tickets map[chainhash.Hash]ticketInfo
+
+	// stakeActivity tracks the stake activity observed in the network.
+	stakeActivity StakeActivity
+
+	// connectedHeight is the height of the tip of the chain according to
+	// the most recent block connected or disconnected notification, which
+	// is only known once hasConnected is set.
+	connectedHeight int64
+	hasConnected    bool
+
+	// connectedHeader is the header of the block of the most recent block
+	// connected notification, which is only valid while hasConnectedHeader
+	// is set, since disconnected notifications do not carry the header of
+	// the new tip.
+	connectedHeader    wire.BlockHeader
+	hasConnectedHeader bool
+
+	// burnedChange is the cumulative change of the published tickets of
+	// the wallet that was sent to the null change script.
+	burnedChange dcrutil.Amount
+
+	// votedTickets tracks the votes cast by the wallet's tickets, keyed by
+	// ticket hash. Records are removed when the block that was voted on is
+	// disconnected, such that the ticket may vote again on the new branch.
+	votedTickets map[chainhash.Hash]voteRecord
+
+	// missedTickets tracks the tickets of the wallet that are considered
+	// missed, keyed by ticket hash, along with the height of the block on
+	// which they were selected to vote.
+	missedTickets map[chainhash.Hash]int64
+
+	// participation tracks the votes of the wallet included in each block
+	// connected at or after SVH, keyed by block height.
+	participation map[int64]blockParticipation
+
+	// wins records the most recent winning tickets notifications in which
+	// tickets of the wallet were selected.
+	wins winHistory
 }
 
-// NewVotingWallet creates a new minimal voting wallet for the given harness.
-// This wallet should be able to maintain the chain generated by the miner node
-// of the harness working after it has passed SVH (Stake Validation Height) by
-// continuously buying tickets and voting on them.
-func NewVotingWallet(ctx context.Context, hn *Harness) (*VotingWallet, error) {
-	privKey := secp256k1.PrivKeyFromBytes(hardcodedPrivateKey)
-	serPub := privKey.PubKey().SerializeCompressed()
-	h160 := stdaddr.Hash160(serPub)
-	addr, err := stdaddr.NewAddressPubKeyHashEcdsaSecp256k1V0(h160, hn.ActiveNet)
-	if err != nil {
-		return nil, fmt.Errorf("unable to generate address for pubkey: %v", err)
+// VotingWalletOption is a functional option that customizes a voting wallet
+// when it is created.
+type VotingWalletOption func(w *VotingWallet) error
+
+// WithDefaultChangeScript returns an option that sets the pkscript used on the
+// sstxchange outputs of the tickets purchased by the wallet, instead of the
+// default null address. The script must be a version 0 stake change script.
+func WithDefaultChangeScript(script []byte) VotingWalletOption {
+	return func(w *VotingWallet) error {
+		if !stdscript.IsStakeChangePubKeyHashScriptV0(script) &&
+			!stdscript.IsStakeChangeScriptHashScriptV0(script) {
+			return fmt.Errorf("change script %x is not a stake change "+
+				"script", script)
+		}
+		w.changeScript = script
+		return nil
+	}
+}
+
+// WithNotificationBufferLen returns an option that sets the length of the
+// buffers of the channels that queue the notifications from the node until the
+// wallet handles them, which defaults to 20 notifications of each kind.
+//
+// The notifications are received on the goroutine of the rpc client, which
+// blocks once a buffer is full until the wallet handles a queued notification,
+// stalling the delivery of all notifications and responses from the node.
+// Every block generates a block connected and a winning tickets notification
+// once tickets are live, and the wallet handles them at the rate it can create
+// and publish its votes and tickets, so custom miners that generate blocks
+// faster than that rate without waiting for the wallet need buffers that hold
+// every notification of a burst of blocks.
+//
+// Notifications are never dropped when a buffer is full, since the wallet
+// relies on handling every notification to track its funds and tickets.
+func WithNotificationBufferLen(n int) VotingWalletOption {
+	return func(w *VotingWallet) error {
+		if n <= 0 {
+			return fmt.Errorf("notification buffer length %d is not "+
+				"positive", n)
+		}
+		w.notificationBufferLen = n
+		return nil
 	}
+}
 
-	p2sstxVer, p2sstx := addr.VotingRightsScript()
-	p2pkhVer, p2pkh := addr.PaymentScript()
+// TxSigner signs the inputs of the transactions created by a voting wallet.
+type TxSigner interface {
+	// Sign returns the signature script of the input at the given index of
+	// the given transaction, which spends an output with the given version
+	// 0 public key script, signed with the SigHashAll hash type.
+	//
+	// It may be called concurrently for different transactions.
+	Sign(tx *wire.MsgTx, idx int, pkScript []byte) ([]byte, error)
+}
 
-	commitAmount := hn.ActiveNet.MinimumStakeDiff * commitAmountMultiplier
-	const voteFeeLimit = 0
-	const revokeFeeLimit = 16777216
-	commitScriptVer, commitScript := addr.RewardCommitmentScript(commitAmount,
-		voteFeeLimit, revokeFeeLimit)
+// privateKeySigner is a TxSigner that signs inputs with a secp256k1 private
+// key. ECDSA signatures spend pubkey hash outputs, while other signature types
+// spend script hash outputs of the given pay-to-pubkey redeem script.
+type privateKeySigner struct {
+	key          []byte
+	sigType      dcrec.SignatureType
+	redeemScript []byte
+}
 
-	voteScriptVer := uint16(0)
-	voteScript, err := txscript.GenerateSSGenVotes(0x0001)
+// Sign returns the signature script of the given input signed with the private
+// key of the signer.
+//
+// This is part of the TxSigner interface.
+func (s privateKeySigner) Sign(tx *wire.MsgTx, idx int, pkScript []byte) ([]byte, error) {
+	if s.sigType == dcrec.STEcdsaSecp256k1 {
+		return sign.SignatureScript(tx, idx, pkScript, txscript.SigHashAll,
+			s.key, dcrec.STEcdsaSecp256k1, true)
+	}
+	sig, err := sign.RawTxInSignature(tx, idx, s.redeemScript,
+		txscript.SigHashAll, s.key, s.sigType)
 	if err != nil {
-		return nil, fmt.Errorf("unable to prepare vote script: %v", err)
+		return nil, err
 	}
-	voteReturnScriptVer, voteReturnScript := addr.PayVoteCommitmentScript()
+	return txscript.NewScriptBuilder().AddData(sig).AddData(s.redeemScript).
+		Script()
+}
 
-	// Hints for the initial sizing of the tickets and maturing votes maps.
-	// Given we have a deterministic purchase process, this should allow us to
-	// size these maps only once at setup time.
-	hintTicketsCap := requiredTicketCount(hn.ActiveNet)
-	hintMaturingVotesCap := int(hn.ActiveNet.CoinbaseMaturity)
+// NewPrivateKeySigner returns a TxSigner that signs inputs with the given
+// 32-byte secp256k1 private key using ECDSA, which is how voting wallets sign
+// their transactions by default.
+func NewPrivateKeySigner(key []byte) TxSigner {
+	return privateKeySigner{key: key, sigType: dcrec.STEcdsaSecp256k1}
+}
 
-	// Buffer length for notification channels. As long as we don't get
-	// notifications faster than this, we should be fine.
-	bufferLen := 20
+// WithTxSigner returns an option that sets the signer of the inputs of the
+// transactions created by the wallet, instead of signing them with the private
+// key of the wallet. This allows exercising alternate signing code paths, such
+// as mocks of hardware signers.
+//
+// The address, scripts and ticket commitments of the wallet are still derived
+// from its private key, so the signatures must be valid for the public key of
+// that private key for the transactions to be accepted by the network.
+func WithTxSigner(signer TxSigner) VotingWalletOption {
+	return func(w *VotingWallet) error {
+		if signer == nil {
+			return fmt.Errorf("signer must not be nil")
+		}
+		w.signer = signer
+		w.customSigner = true
+		return nil
+	}
+}
 
-	w := &VotingWallet{
-		hn:                     hn,
-		privateKey:             hardcodedPrivateKey,
-		address:                addr,
-		p2sstxVer:              p2sstxVer,
-		p2sstx:                 p2sstx,
-		p2pkhVer:               p2pkhVer,
-		p2pkh:                  p2pkh,
-		commitScriptVer:        commitScriptVer,
-		commitScript:           commitScript,
-		voteScriptVer:          voteScriptVer,
-		voteScript:             voteScript,
-		voteRetScriptVer:       voteReturnScriptVer,
-		voteRetScript:          voteReturnScript,
-		subsidyCache:           standalone.NewSubsidyCache(hn.ActiveNet),
-		limitNbVotes:           int(hn.ActiveNet.TicketsPerBlock),
-		tickets:                make(map[chainhash.Hash]ticketInfo, hintTicketsCap),
-		maturingVotes:          make(map[int64][]utxoInfo, hintMaturingVotesCap),
-		blockConnectedNtfnChan: make(chan blockConnectedNtfn, bufferLen),
-		winningTicketsNtfnChan: make(chan winningTicketsNtfn, bufferLen),
+// NewVotingWallet creates a new minimal voting wallet for the given harness.
+// This wallet should be able to maintain the chain generated by the miner node
+// of the harness working after it has passed SVH (Stake Validation Height) by
+// continuously buying tickets and voting on them.
+//
+// The wallet may be customized by the provided options.
+func NewVotingWallet(ctx context.Context, hn *Harness, opts ...VotingWalletOption) (*VotingWallet, error) {
+	return NewVotingWalletWithKey(ctx, hn, hardcodedPrivateKey, opts...)
+}
+
+// NewVotingWalletWithKey creates a new minimal voting wallet for the given
+// harness in the same manner as NewVotingWallet, except that the address,
+// scripts and ticket commitments of the wallet are derived from the provided
+// private key instead of a hardcoded one. This allows running multiple wallets
+// that own distinct funds and tickets against the same harness.
+//
+// The private key must be a 32-byte secp256k1 private key.
+func NewVotingWalletWithKey(ctx context.Context, hn *Harness, key []byte, opts ...VotingWalletOption) (*VotingWallet, error) {
+	w, err := newVotingWallet(hn, key, opts...)
+	if err != nil {
+		return nil, err
 	}
 
 	handlers := &rpcclient.NotificationHandlers{
-		OnBlockConnected: w.onBlockConnected,
-		OnWinningTickets: w.onWinningTickets,
+		OnBlockConnected:    w.onBlockConnected,
+		OnBlockDisconnected: w.onBlockDisconnected,
+		OnWinningTickets:    w.onWinningTickets,
 	}
 
 	rpcConf := hn.RPCConfig()
@@ -222,62 +1041,1230 @@ func NewVotingWallet(ctx context.Context, hn *Harness) (*VotingWallet, error) {
 		return nil, fmt.Errorf("unable to subscribe to winning tickets notification: %v", err)
 	}
 
+	if err := w.loadTxFilter(ctx); err != nil {
+		return nil, err
+	}
+
 	return w, nil
 }
 
-// Start stars the goroutines necessary for this voting wallet to function.
-func (w *VotingWallet) Start(ctx context.Context) error {
-	value := w.hn.ActiveNet.MinimumStakeDiff * commitAmountMultiplier
-
-	// Create enough outputs to perform the voting, each with twice the amount
-	// of the minimum ticket price.
-	//
-	// The number of required outputs is twice the coinbase maturity, since
-	// we buy TicketsPerBlock tickets per block, starting at SVH-TM. At SVH,
-	// TicketsPerBlock tickets will mature and be selected to vote (given they
-	// are the only ones in the live ticket pool).
-	//
-	// Every following block we purchase the same amount of tickets, such that
-	// TicketsPerBlock are maturing.
-	nbOutputs := requiredTicketCount(w.hn.ActiveNet)
-	outputs := make([]*wire.TxOut, nbOutputs)
-
-	for i := 0; i < nbOutputs; i++ {
-		outputs[i] = wire.NewTxOut(value, w.p2pkh)
+// newVotingWallet creates a new voting wallet for the given harness with the
+// given private key and options, which is not yet connected to the node of the
+// harness.
+func newVotingWallet(hn *Harness, key []byte, opts ...VotingWalletOption) (*VotingWallet, error) {
+	if len(key) != secp256k1.PrivKeyBytesLen {
+		return nil, fmt.Errorf("private key must be %d bytes, got %d",
+			secp256k1.PrivKeyBytesLen, len(key))
 	}
+	var keyScalar secp256k1.ModNScalar
+	if overflow := keyScalar.SetByteSlice(key); overflow || keyScalar.IsZero() {
+		return nil, fmt.Errorf("private key is not a valid secp256k1 " +
+			"private key")
+	}
+	privateKey := make([]byte, len(key))
+	copy(privateKey, key)
 
-	txid, err := w.hn.SendOutputs(outputs, feeRate)
+	voteScriptVer := uint16(0)
+	voteScript, err := txscript.GenerateSSGenVotes(voteBitsBlockValid)
 	if err != nil {
-		return fmt.Errorf("unable to fund voting wallet: %v", err)
+		return nil, fmt.Errorf("unable to prepare vote script: %v", err)
 	}
 
-	// Build the outstanding utxos for ticket buying. These will be the first
-	// nbOutputs outputs from txid (assuming the SendOutputs() from above always
-	// sends the change last).
-	utxos := make([]utxoInfo, nbOutputs)
-	for i := 0; i < nbOutputs; i++ {
-		utxos[i] = utxoInfo{
-			outpoint: wire.OutPoint{Hash: *txid, Index: uint32(i), Tree: wire.TxTreeRegular},
-			amount:   value,
+	// Hints for the initial sizing of the tickets and maturing votes maps.
+	// Given we have a deterministic purchase process, this should allow us to
+	// size these maps only once at setup time.
+	hintTicketsCap := requiredTicketCount(hn.ActiveNet)
+	hintMaturingVotesCap := int(hn.ActiveNet.CoinbaseMaturity)
+
+	w := &VotingWallet{
+		hn:                     hn,
+		privateKey:             privateKey,
+		voteFeeLimit:           defaultVoteFeeLimit,
+		revokeFeeLimit:         defaultRevokeFeeLimit,
+		changeScript:           nullPay2SSTXChange,
+		feeRate:                feeRate,
+		commitAmountMultiplier: commitAmountMultiplier,
+		voteScriptVer:          voteScriptVer,
+		voteScript:             voteScript,
+		voteBits:               voteBitsBlockValid,
+		subsidyCache:           standalone.NewSubsidyCache(hn.ActiveNet),
+		activeAgendas:          make(map[string]struct{}),
+		limitNbVotes:           int(hn.ActiveNet.TicketsPerBlock),
+		ticketsPerBlock:        int(hn.ActiveNet.TicketsPerBlock),
+		blockGenTimeout:        defaultBlockGenTimeout,
+		blockGenPollInterval:   defaultBlockGenPollInterval,
+		events:                 make(chan WalletEvent, eventsBufferLen),
+		tickets:                make(map[chainhash.Hash]ticketInfo, hintTicketsCap),
+		maturingVotes:          make(map[int64][]utxoInfo, hintMaturingVotesCap),
+		votedTickets:           make(map[chainhash.Hash]voteRecord, hintTicketsCap),
+		voteScriptCache:        make(map[voteBitsVersion][]byte),
+		unconfirmedWinners:     make(map[chainhash.Hash]int64),
+		missedTickets:          make(map[chainhash.Hash]int64),
+		castVotes:              make(map[chainhash.Hash]castVoteCount),
+		submitted:              make(map[chainhash.Hash]*submittedTxs),
+		pendingTAdds:           make(map[chainhash.Hash]utxoInfo),
+		pendingRefunds:         make(map[chainhash.Hash][]utxoInfo),
+		participation:          make(map[int64]blockParticipation),
+		wins:                   winHistory{records: make([]WinRecord, defaultWinHistorySize)},
+		notificationBufferLen:  defaultNotificationBufferLen,
+		log:                    slog.Disabled,
+		quit:                   make(chan struct{}),
+		failed:                 make(chan struct{}),
+		ready:                  make(chan struct{}),
+	}
+
+	if err := w.deriveScripts(dcrec.STEcdsaSecp256k1); err != nil {
+		return nil, err
+	}
+
+	for _, opt := range opts {
+		if err := opt(w); err != nil {
+			return nil, err
+		}
+	}
+
+	bufferLen := w.notificationBufferLen
+	w.blockConnectedNtfnChan = make(chan blockConnectedNtfn, bufferLen)
+	w.blockDisconnectedNtfnChan = make(chan blockDisconnectedNtfn, bufferLen)
+	w.winningTicketsNtfnChan = make(chan winningTicketsNtfn, bufferLen)
+
+	// Each kind of notification has at most one outstanding backlog report.
+	w.backlogReports = make(chan error, 2)
+
+	return w, nil
+}
+
+// loadTxFilter loads the transaction filter of the wallet address in the node,
+// replacing any previously loaded filter.
+func (w *VotingWallet) loadTxFilter(ctx context.Context) error {
+	// Block connected notifications include the stake transactions of the
+	// wallet, which are used to confirm its votes.
+	err := w.c.LoadTxFilter(ctx, true, []stdaddr.Address{w.address}, nil)
+	if err != nil {
+		return fmt.Errorf("unable to load tx filter: %v", err)
+	}
+	w.txFilterAddr = w.address
+	return nil
+}
+
+// deriveScripts derives the address of the wallet from its private key for the
+// given signature type, along with the scripts paid to by its transactions,
+// and sets the default signer accordingly unless a custom signer is set.
+//
+// Stake outputs may only pay to ECDSA pubkey hash or script hash addresses, so
+// the address of the wallet is a script hash address of a pay-to-pubkey script
+// for signature types other than ECDSA.
+func (w *VotingWallet) deriveScripts(sigType dcrec.SignatureType) error {
+	params := w.hn.ActiveNet
+	pubKey := secp256k1.PrivKeyFromBytes(w.privateKey).PubKey()
+	var addr stdaddr.StakeAddress
+	var redeemScript []byte
+	switch sigType {
+	case dcrec.STEcdsaSecp256k1:
+		h160 := stdaddr.Hash160(pubKey.SerializeCompressed())
+		var err error
+		addr, err = stdaddr.NewAddressPubKeyHashEcdsaSecp256k1V0(h160, params)
+		if err != nil {
+			return fmt.Errorf("unable to generate address for pubkey: %v",
+				err)
+		}
+
+	case dcrec.STSchnorrSecp256k1:
+		pkAddr, err := stdaddr.NewAddressPubKeySchnorrSecp256k1V0(pubKey,
+			params)
+		if err != nil {
+			return fmt.Errorf("unable to generate address for pubkey: %v",
+				err)
+		}
+		_, redeemScript = pkAddr.PaymentScript()
+		addr, err = stdaddr.NewAddressScriptHashV0(redeemScript, params)
+		if err != nil {
+			return fmt.Errorf("unable to generate address for redeem "+
+				"script: %v", err)
+		}
+
+	default:
+		return fmt.Errorf("unsupported signature type %v", sigType)
+	}
+
+	w.sigType = sigType
+	w.address = addr
+	w.p2sstxVer, w.p2sstx = addr.VotingRightsScript()
+	w.p2pkhVer, w.p2pkh = addr.PaymentScript()
+	w.voteRetScriptVer, w.voteRetScript = addr.PayVoteCommitmentScript()
+	_, w.revokeRetScript = addr.PayRevokeCommitmentScript()
+	w.stakeChangeVer, w.stakeChange = addr.StakeChangeScript()
+	if !w.customSigner {
+		w.signer = privateKeySigner{
+			key:          w.privateKey,
+			sigType:      sigType,
+			redeemScript: redeemScript,
+		}
+	}
+	return nil
+}
+
+// SetSignatureType sets the type of the signatures of the transactions of the
+// wallet, which determines its address. The supported signature types are
+// ECDSA, the default, and Schnorr over secp256k1.
+//
+// Since stake outputs may only pay to ECDSA pubkey hash or script hash
+// addresses, the address of a wallet that signs with Schnorr signatures is the
+// script hash address of a pay-to-pubkey-schnorr-secp256k1 script. All the
+// outputs of the wallet, including the voting rights and commitments of its
+// tickets, pay to that address.
+//
+// This MUST be called before Start.
+func (w *VotingWallet) SetSignatureType(sigType dcrec.SignatureType) error {
+	w.mtx.Lock()
+	started := w.started
+	w.mtx.Unlock()
+	if started {
+		return fmt.Errorf("signature type cannot be set after the " +
+			"wallet is started")
+	}
+	return w.deriveScripts(sigType)
+}
+
+// Address returns the address of the wallet, which funds its tickets and
+// receives the rewards of its votes and revocations.
+func (w *VotingWallet) Address() stdaddr.Address {
+	return w.address
+}
+
+// P2PKHScript returns the version and pay-to-pubkey-hash script of the address
+// of the wallet, which is the script of the outputs used to fund it. It is a
+// pay-to-script-hash script when the wallet signs with a signature type other
+// than ECDSA.
+func (w *VotingWallet) P2PKHScript() (uint16, []byte) {
+	script := make([]byte, len(w.p2pkh))
+	copy(script, w.p2pkh)
+	return w.p2pkhVer, script
+}
+
+// PrivateKey returns the private key the wallet signs all of its transactions
+// with, which allows tests to construct transactions that pay to the wallet or
+// spend its outputs without duplicating the key. The returned key is a copy, so
+// modifying it does not affect the wallet.
+//
+// This is only intended for tests. The key of the wallets created by
+// NewVotingWallet is hardcoded and thus provides no security.
+func (w *VotingWallet) PrivateKey() *secp256k1.PrivateKey {
+	return secp256k1.PrivKeyFromBytes(w.privateKey)
+}
+
+// Start stars the goroutines necessary for this voting wallet to function.
+//
+// The goroutines run until either the passed context is cancelled or Stop is
+// called.
+func (w *VotingWallet) Start(ctx context.Context) error {
+	w.mtx.Lock()
+	w.started = true
+	w.mtx.Unlock()
+
+	// The address of the wallet changes with its signature type.
+	if w.txFilterAddr != w.address {
+		if err := w.loadTxFilter(ctx); err != nil {
+			return err
 		}
 	}
+
+	// Observers do not need any funds.
+	if w.observer {
+		w.startNotificationHandler(ctx)
+		return nil
+	}
+
+	_, startHeight, err := w.c.GetBestBlock(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get best block: %v", err)
+	}
+	w.startHeight = startHeight
+
+	// Create enough outputs to perform the voting, each with twice the amount
+	// of the minimum ticket price.
+	//
+	// The number of required outputs is twice the coinbase maturity, since
+	// we buy TicketsPerBlock tickets per block, starting at SVH-TM. At SVH,
+	// TicketsPerBlock tickets will mature and be selected to vote (given they
+	// are the only ones in the live ticket pool).
+	//
+	// Every following block we purchase the same amount of tickets, such that
+	// TicketsPerBlock are maturing. The outputs are scaled accordingly when
+	// the wallet purchases a different number of tickets per block.
+	w.mtx.Lock()
+	ticketsPerBlock := w.ticketsPerBlock
+	w.mtx.Unlock()
+	if ticketsPerBlock < int(w.hn.ActiveNet.TicketsPerBlock) {
+		ticketsPerBlock = int(w.hn.ActiveNet.TicketsPerBlock)
+	}
+	nbOutputs := requiredTicketCount(w.hn.ActiveNet) /
+		int(w.hn.ActiveNet.TicketsPerBlock) * ticketsPerBlock
+	if w.initialFundingOutputs > 0 {
+		nbOutputs = w.initialFundingOutputs
+		value := w.hn.ActiveNet.MinimumStakeDiff * w.commitAmountMultiplier
+		required := dcrutil.Amount(int64(nbOutputs) * value)
+		if balance := w.hn.ConfirmedBalance(); balance < required {
+			return fmt.Errorf("harness balance %v is insufficient to "+
+				"fund %d outputs totaling %v", balance, nbOutputs,
+				required)
+		}
+	}
+	_, utxos, err := w.fund(ctx, nbOutputs)
+	if err != nil {
+		return err
+	}
+	w.mtx.Lock()
 	w.utxos = utxos
+	w.mtx.Unlock()
+
+	w.startNotificationHandler(ctx)
+
+	return nil
+}
+
+// StartFromScan starts the wallet like Start, except that instead of being
+// funded by the harness, the wallet discovers the outputs and live tickets it
+// owns by scanning the blocks of the main chain and the mempool of the node.
+//
+// This allows a wallet created with the private key of a wallet that was
+// stopped, for example across a restart of the harness, to pick up mid-chain
+// where the previous one left off. No blocks may be connected between the
+// creation of the wallet and the end of the scan, since their notifications
+// would be handled on top of the scanned state.
+//
+// The goroutines run until either the passed context is cancelled or Stop is
+// called.
+func (w *VotingWallet) StartFromScan(ctx context.Context) error {
+	if w.observer {
+		return errors.New("observers do not own outputs to scan for")
+	}
+	w.mtx.Lock()
+	w.started = true
+	w.mtx.Unlock()
+
+	// The address of the wallet changes with its signature type.
+	if w.txFilterAddr != w.address {
+		if err := w.loadTxFilter(ctx); err != nil {
+			return err
+		}
+	}
+
+	_, tipHeight, err := w.c.GetBestBlock(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get best block: %v", err)
+	}
+	w.startHeight = tipHeight
+
+	state, votes, err := w.scan(ctx, tipHeight)
+	if err != nil {
+		return walletError(ErrQueryFailed, err)
+	}
+	var nbMaturing int
+	for _, utxos := range state.maturingVotes {
+		nbMaturing += len(utxos)
+	}
+	if len(state.utxos)+nbMaturing+len(state.tickets) == 0 {
+		return walletError(ErrFundingFailed, fmt.Errorf("no outputs or "+
+			"live tickets of address %s found as of block %d", w.address,
+			tipHeight))
+	}
+
+	w.mtx.Lock()
+	w.utxos = state.utxos
+	for height, utxos := range state.maturingVotes {
+		w.maturingVotes[height] = append(w.maturingVotes[height], utxos...)
+	}
+	for hash, ticket := range state.tickets {
+		w.tickets[hash] = ticket
+	}
+	for hash, vote := range votes {
+		w.votedTickets[hash] = vote
+	}
+	w.mtx.Unlock()
+	w.log.Infof("Scanned %d blocks: found %d outputs, %d maturing outputs "+
+		"and %d live tickets", tipHeight, len(state.utxos), nbMaturing,
+		len(state.tickets))
+
+	w.startNotificationHandler(ctx)
+
+	return nil
+}
+
+// scannedOutput is an output of the wallet found while scanning the chain,
+// along with the height of the block after which it may fund tickets.
+type scannedOutput struct {
+	utxo        utxoInfo
+	availHeight int64
+}
+
+// scan discovers the unspent outputs and live tickets of the wallet by scanning
+// the blocks of the main chain up to the given tip height, followed by the
+// mempool. The votes in the mempool cast by the live tickets are returned
+// along with the state, keyed by ticket hash.
+//
+// The transactions in the mempool are considered mined in the block after the
+// tip, except that the mined heights of tickets are only set once they are
+// actually mined.
+func (w *VotingWallet) scan(ctx context.Context, tipHeight int64) (*WalletState, map[chainhash.Hash]voteRecord, error) {
+	net := w.hn.ActiveNet
+	coinbaseMaturity := int64(net.CoinbaseMaturity)
+	owned := make(map[wire.OutPoint]scannedOutput)
+	var ownedOrder []wire.OutPoint
+	tickets := make(map[chainhash.Hash]ticketInfo)
+	votes := make(map[chainhash.Hash]voteRecord)
+	mempoolSpent := make(map[wire.OutPoint]struct{})
+
+	addOutput := func(tx *wire.MsgTx, txHash *chainhash.Hash, idx int,
+		tree int8, availHeight int64, utxo utxoInfo) {
+
+		utxo.outpoint = wire.OutPoint{Hash: *txHash, Index: uint32(idx), Tree: tree}
+		utxo.amount = tx.TxOut[idx].Value
+		owned[utxo.outpoint] = scannedOutput{utxo: utxo, availHeight: availHeight}
+		ownedOrder = append(ownedOrder, utxo.outpoint)
+	}
+	scanTx := func(tx *wire.MsgTx, height int64, inMempool bool) {
+		txHash := tx.TxHash()
+		switch stake.DetermineTxType(tx) {
+		case stake.TxTypeSStx:
+			if !bytes.Equal(tx.TxOut[0].PkScript, w.p2sstx) {
+				return
+			}
+			prevOut := tx.TxIn[0].PreviousOutPoint
+			funding, ok := owned[prevOut]
+			if !ok {
+				funding.utxo = utxoInfo{
+					outpoint: prevOut,
+					amount:   tx.TxIn[0].ValueIn,
+				}
+			}
+			ticket := ticketInfo{
+				ticketPrice: tx.TxOut[0].Value,
+				utxo:        funding.utxo,
+			}
+			if !inMempool {
+				ticket.minedHeight = height
+				ticket.expiryHeight = height + int64(net.TicketMaturity) +
+					int64(net.TicketExpiry)
+
+				// Recycled ticket change is only tracked once the
+				// ticket is mined.
+				change := tx.TxOut[2]
+				if change.Value > p2pkhDustLimit &&
+					change.Version == w.stakeChangeVer &&
+					bytes.Equal(change.PkScript, w.stakeChange) {
+					addOutput(tx, &txHash, 2, wire.TxTreeStake,
+						height+int64(net.SStxChangeMaturity)-1,
+						utxoInfo{ticketChange: true})
+				}
+			}
+			tickets[txHash] = ticket
+
+		case stake.TxTypeSSGen:
+			ticketHash := tx.TxIn[1].PreviousOutPoint.Hash
+			if _, ok := tickets[ticketHash]; !ok {
+				return
+			}
+			blockHash, blockHeight := stake.SSGenBlockVotedOn(tx)
+			if inMempool {
+				votes[ticketHash] = voteRecord{
+					blockHash:   blockHash,
+					blockHeight: int64(blockHeight),
+					voteHash:    txHash,
+				}
+			}
+			if bytes.Equal(tx.TxOut[2].PkScript, w.voteRetScript) {
+				addOutput(tx, &txHash, 2, wire.TxTreeStake,
+					int64(blockHeight)+coinbaseMaturity-
+						w.earlyMaturityBlocks, utxoInfo{})
+			}
+
+		case stake.TxTypeSSRtx:
+			ticketHash := tx.TxIn[0].PreviousOutPoint.Hash
+			if _, ok := tickets[ticketHash]; !ok {
+				return
+			}
+
+			// The ticket is no longer tracked once its revocation is
+			// seen, so that it is not confirmed twice when the
+			// revocation is in the mempool.
+			delete(tickets, ticketHash)
+			for i, txOut := range tx.TxOut {
+				if bytes.Equal(txOut.PkScript, w.revokeRetScript) {
+					addOutput(tx, &txHash, i, wire.TxTreeStake,
+						height+coinbaseMaturity-1,
+						utxoInfo{revocation: true})
+				}
+			}
+
+		case stake.TxTypeTAdd:
+			for i, txOut := range tx.TxOut {
+				if txOut.Version == w.stakeChangeVer &&
+					bytes.Equal(txOut.PkScript, w.stakeChange) {
+					addOutput(tx, &txHash, i, wire.TxTreeStake,
+						height+coinbaseMaturity-1,
+						utxoInfo{taddChange: true})
+				}
+			}
+
+		case stake.TxTypeRegular:
+			for i, txOut := range tx.TxOut {
+				if txOut.Version == w.p2pkhVer &&
+					bytes.Equal(txOut.PkScript, w.p2pkh) {
+					addOutput(tx, &txHash, i, wire.TxTreeRegular,
+						height, utxoInfo{})
+				}
+			}
+		}
+	}
+
+	for height := int64(1); height <= tipHeight; height++ {
+		blockHash, err := w.c.GetBlockHash(ctx, height)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to get block hash at "+
+				"height %d: %v", height, err)
+		}
+		block, err := w.c.GetBlock(ctx, blockHash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to get block %s: %v",
+				blockHash, err)
+		}
+		for _, tx := range block.Transactions {
+			scanTx(tx, height, false)
+		}
+		for _, tx := range block.STransactions {
+			scanTx(tx, height, false)
+		}
+	}
+
+	mempool, err := w.c.GetRawMempool(ctx, dcrdtypes.GRMAll)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get mempool: %v", err)
+	}
+	for _, txHash := range mempool {
+		tx, err := w.c.GetRawTransaction(ctx, txHash)
+		if err != nil {
+			// The transaction may have been removed from the
+			// mempool since it was listed.
+			continue
+		}
+		scanTx(tx.MsgTx(), tipHeight+1, true)
+		for _, txIn := range tx.MsgTx().TxIn {
+			mempoolSpent[txIn.PreviousOutPoint] = struct{}{}
+		}
+	}
+
+	// Keep the outputs and tickets that remain unspent, noting that the
+	// outputs spent by transactions in the mempool are still reported as
+	// unspent by the node.
+	state := &WalletState{
+		tickets:       make(map[chainhash.Hash]ticketInfo),
+		maturingVotes: make(map[int64][]utxoInfo),
+	}
+	for _, outpoint := range ownedOrder {
+		if _, ok := mempoolSpent[outpoint]; ok {
+			continue
+		}
+		txOut, err := w.c.GetTxOut(ctx, &outpoint.Hash, outpoint.Index,
+			outpoint.Tree, true)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to get output %v: %v",
+				outpoint, err)
+		}
+		if txOut == nil {
+			continue
+		}
+		output := owned[outpoint]
+		if output.availHeight <= tipHeight {
+			state.utxos = append(state.utxos, output.utxo)
+			continue
+		}
+		state.maturingVotes[output.availHeight] = append(
+			state.maturingVotes[output.availHeight], output.utxo)
+	}
+	for ticketHash, ticket := range tickets {
+		ticketHash := ticketHash
+		txOut, err := w.c.GetTxOut(ctx, &ticketHash, 0, wire.TxTreeStake,
+			true)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to get ticket %s: %v",
+				ticketHash, err)
+		}
+		if txOut == nil {
+			delete(votes, ticketHash)
+			continue
+		}
+		state.tickets[ticketHash] = ticket
+	}
+	return state, votes, nil
+}
+
+// WaitForSync waits until the wallet has handled the notifications of the
+// blocks connected up to the current best block of the network, or the context
+// is done, and then ensures the wallet was started early enough to keep the
+// chain going past SVH.
+//
+// A wallet purchases tickets starting from the first block connected after it
+// is started, so a wallet started after the ticket purchase start height,
+// TicketMaturity+2 blocks before SVH, does not own enough live tickets to vote
+// on the blocks before SVH. In that case, an error wrapping ErrStartedLate is
+// returned, unless the wallet shares the ticket pool with other wallets, or
+// the chain is already past SVH and the live ticket pool can sustain voting.
+// This allows detecting wallets created after significant mining before the
+// chain stalls in the middle of a test.
+func (w *VotingWallet) WaitForSync(ctx context.Context) error {
+	_, bestHeight, err := w.c.GetBestBlock(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get best block: %v", err)
+	}
 
-	go w.handleNotifications(ctx)
+	// The wallet only receives notifications for the blocks connected
+	// after it was created.
+	for {
+		height, ok := w.ConnectedHeight()
+		if !ok || height >= bestHeight {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wallet did not sync to height %d: %v",
+				bestHeight, ctx.Err())
+		case <-w.failed:
+			return w.failErr
+		case <-time.After(w.blockGenPollInterval):
+		}
+	}
 
+	w.mtx.Lock()
+	started, shared := w.started, w.sharedTicketPool
+	w.mtx.Unlock()
+	if !started || w.observer || shared {
+		return nil
+	}
+	net := w.hn.ActiveNet
+	purchaseStart := ticketPurchaseStartHeight(net)
+	if w.startHeight <= purchaseStart {
+		return nil
+	}
+	if bestHeight < net.StakeValidationHeight {
+		return fmt.Errorf("%w: started at height %d after height %d, so "+
+			"it cannot own enough live tickets to vote at SVH %d",
+			ErrStartedLate, w.startHeight, purchaseStart,
+			net.StakeValidationHeight)
+	}
+	if err := w.checkLiveTickets(ctx, bestHeight+1); err != nil {
+		return fmt.Errorf("%w: started at height %d after height %d: %v",
+			ErrStartedLate, w.startHeight, purchaseStart, err)
+	}
 	return nil
 }
 
+// fund sends the given number of outputs to the wallet from the harness, each
+// with the amount of the minimum ticket price times the commit amount
+// multiplier, and returns the hash of the funding transaction along with the
+// outputs.
+func (w *VotingWallet) fund(ctx context.Context, nbOutputs int) (*chainhash.Hash, []utxoInfo, error) {
+	value := w.hn.ActiveNet.MinimumStakeDiff * w.commitAmountMultiplier
+	outputs := make([]*wire.TxOut, nbOutputs)
+
+	for i := 0; i < nbOutputs; i++ {
+		outputs[i] = wire.NewTxOut(value, w.p2pkh)
+	}
+
+	tx, err := w.hn.CreateTransaction(outputs, w.txFeeRate())
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to fund voting wallet: %v", err)
+	}
+	txid, err := w.c.SendRawTransaction(ctx, tx, true)
+	if err != nil {
+		w.hn.UnlockOutputs(tx.TxIn)
+		return nil, nil, fmt.Errorf("unable to fund voting wallet: %v", err)
+	}
+
+	// Build the outstanding utxos for ticket buying from the outputs of the
+	// funding transaction that pay to the wallet, regardless of where the
+	// harness places its change.
+	utxos := w.fundingUtxos(tx)
+	if len(utxos) != nbOutputs {
+		return nil, nil, fmt.Errorf("funding transaction %s pays %d "+
+			"outputs to the wallet instead of %d", txid, len(utxos),
+			nbOutputs)
+	}
+	return txid, utxos, nil
+}
+
+// fundingUtxos returns the outputs of the given funding transaction that pay
+// to the wallet.
+func (w *VotingWallet) fundingUtxos(tx *wire.MsgTx) []utxoInfo {
+	txHash := tx.TxHash()
+	var utxos []utxoInfo
+	for i, txOut := range tx.TxOut {
+		if txOut.Version != w.p2pkhVer || !bytes.Equal(txOut.PkScript, w.p2pkh) {
+			continue
+		}
+		utxos = append(utxos, utxoInfo{
+			outpoint: wire.OutPoint{
+				Hash:  txHash,
+				Index: uint32(i),
+				Tree:  wire.TxTreeRegular,
+			},
+			amount: txOut.Value,
+		})
+	}
+	return utxos
+}
+
+// refund funds the wallet with new outputs when auto refunding is enabled and
+// the wallet holds fewer than twice the number of tickets it purchases per
+// block, including the outputs of the refunds that are not yet mined.
+func (w *VotingWallet) refund(ctx context.Context) {
+	w.mtx.Lock()
+	nbUtxos := len(w.utxos)
+	for _, utxos := range w.pendingRefunds {
+		nbUtxos += len(utxos)
+	}
+	var nbOutputs int
+	if w.autoRefund && nbUtxos < 2*w.ticketsPerBlock {
+		nbOutputs = int(w.hn.ActiveNet.CoinbaseMaturity) * w.ticketsPerBlock
+	}
+	pendingFunding := w.pendingFunding
+	nbOutputs += pendingFunding
+	w.pendingFunding = 0
+	w.mtx.Unlock()
+	if nbOutputs == 0 {
+		return
+	}
+
+	txid, utxos, err := w.fund(ctx, nbOutputs)
+	if err != nil {
+		// Retry funding the outputs required by an increased vote limit
+		// on the next block.
+		w.mtx.Lock()
+		w.pendingFunding += pendingFunding
+		w.mtx.Unlock()
+		w.logError(walletError(ErrFundingFailed, err))
+		return
+	}
+	w.mtx.Lock()
+	w.pendingRefunds[*txid] = utxos
+	w.mtx.Unlock()
+}
+
+// confirmRefund makes the outputs of the given transaction available for
+// purchasing tickets when it is a mined refund of the wallet.
+func (w *VotingWallet) confirmRefund(tx *wire.MsgTx) {
+	txHash := tx.TxHash()
+	w.mtx.Lock()
+	if utxos, ok := w.pendingRefunds[txHash]; ok {
+		w.utxos = append(w.utxos, utxos...)
+		delete(w.pendingRefunds, txHash)
+	}
+	w.mtx.Unlock()
+}
+
+// startNotificationHandler launches the goroutine that handles notifications
+// with a context that is cancelled when the wallet is stopped.
+func (w *VotingWallet) startNotificationHandler(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	w.cancelMtx.Lock()
+	w.cancel = cancel
+	select {
+	case <-w.failed:
+		// The wallet already failed in fail-fast mode.
+		cancel()
+	default:
+	}
+	w.cancelMtx.Unlock()
+	w.wg.Add(1)
+	go func() {
+		w.handleNotifications(ctx)
+		w.wg.Done()
+	}()
+}
+
+// Stop shuts down the wallet. It cancels any outstanding work, waits for the
+// notification handler to finish and closes the connection to the node.
+//
+// It is safe to call Stop multiple times. Every call returns the error (if
+// any) encountered while closing the connection to the node.
+func (w *VotingWallet) Stop() error {
+	w.stopOnce.Do(func() {
+		close(w.quit)
+		w.cancelHandler()
+		w.wg.Wait()
+
+		w.c.Shutdown()
+		done := make(chan struct{})
+		go func() {
+			w.c.WaitForShutdown()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(stopTimeout):
+			w.stopErr = fmt.Errorf("timeout waiting for rpc client " +
+				"shutdown")
+		}
+	})
+	return w.stopErr
+}
+
+// SetObserverMode sets whether the wallet operates as a pure observer. In
+// observer mode, the wallet is not funded and never purchases tickets or casts
+// votes, but still tracks the stake activity of the network, which may be
+// queried with StakeActivity.
+//
+// This MUST be called before Start.
+func (w *VotingWallet) SetObserverMode(enabled bool) {
+	w.observer = enabled
+}
+
+// SetDryRun sets whether the wallet operates in dry-run mode. In dry-run mode,
+// the wallet creates and signs the tickets and votes it would publish in
+// response to each notification as usual, but passes them to the provided sink
+// instead of publishing them, which allows testing their construction without
+// affecting the network. The sink may be nil to discard them.
+//
+// The tickets created in dry-run mode are not tracked and the utxos that fund
+// them remain available, while the tickets of the wallet selected to vote are
+// considered missed since their votes are never published. Since the wallet
+// does not publish any votes or tickets, blocks must not be generated with
+// GenerateBlocks while in dry-run mode after the stake validation height.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetDryRun(enabled bool, sink func([]*wire.MsgTx)) {
+	w.mtx.Lock()
+	w.dryRun = enabled
+	w.dryRunSink = sink
+	w.mtx.Unlock()
+}
+
+// dryRunTxs passes the given transactions to the dry-run sink and returns true
+// when the wallet is in dry-run mode, and otherwise returns false so the
+// transactions are published.
+func (w *VotingWallet) dryRunTxs(txs []*wire.MsgTx) bool {
+	w.mtx.Lock()
+	dryRun, sink := w.dryRun, w.dryRunSink
+	w.mtx.Unlock()
+	if !dryRun {
+		return false
+	}
+	if sink != nil {
+		sink(txs)
+	}
+	return true
+}
+
+// ConnectedHeight returns the height of the best block of the network according
+// to the block connected and disconnected notifications handled by the wallet,
+// along with whether any such notification was handled yet. This does not
+// require querying the node, but it may lag behind the best block of the node
+// while notifications are in flight.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) ConnectedHeight() (int64, bool) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.connectedHeight, w.hasConnected
+}
+
+// StakeActivity returns the network-wide stake activity observed by the wallet
+// since it was started.
+func (w *VotingWallet) StakeActivity() StakeActivity {
+	w.mtx.Lock()
+	activity := w.stakeActivity
+	w.mtx.Unlock()
+	return activity
+}
+
 // SetErrorReporting allows users of the voting wallet to specify a function
 // that will be called whenever an error happens while purchasing tickets or
-// generating votes.
+// generating votes. The reported errors are of type WalletError, so the kind
+// of the error may be checked with errors.Is.
+//
+// This function is safe for concurrent access.
 func (w *VotingWallet) SetErrorReporting(f func(err error)) {
+	w.mtx.Lock()
 	w.errorReporter = f
+	w.mtx.Unlock()
 }
 
-// SetMiner allows users of the voting wallet to specify a function that will
-// be used to mine new blocks instead of using the regular Generate function of
-// the configured rpcclient.
+// SetLogger sets the logger of the wallet, which logs the blocks the wallet
+// generates and handles at the debug level and the tickets, votes and
+// revocations it publishes at the info level, along with the errors it
+// encounters. This shows the exact sequence of the actions of the wallet
+// relative to the block heights, which helps diagnose flaky tests. A nil logger
+// discards all messages, which is the default.
+//
+// This MUST be called before Start.
+func (w *VotingWallet) SetLogger(logger slog.Logger) {
+	if logger == nil {
+		logger = slog.Disabled
+	}
+	w.log = logger
+}
+
+// SetInitialFundingOutputs sets the number of outputs the wallet is funded with
+// by Start, which must be at least the number of tickets required to reach SVH.
+// By default, the wallet is funded with the outputs required to reach SVH.
+//
+// Funding more outputs extends how long the wallet keeps purchasing tickets
+// when its votes do not return the outputs, such as when the number of votes
+// is limited, without enabling auto refunding. Start returns an error when the
+// harness does not hold enough funds.
+//
+// This MUST be called before Start.
+func (w *VotingWallet) SetInitialFundingOutputs(n int) error {
+	if required := requiredTicketCount(w.hn.ActiveNet); n < required {
+		return fmt.Errorf("number of initial funding outputs %d is less "+
+			"than the %d required to reach SVH", n, required)
+	}
+	w.initialFundingOutputs = n
+	return nil
+}
+
+// SetSharedTicketPool sets whether the wallet shares the live ticket pool with
+// other wallets that purchase tickets and vote on the same network, such as
+// other voting wallets running against the same harness.
+//
+// A wallet normally purchases TicketsPerBlock tickets on every block and
+// reports an error when it does not hold enough funds to do so, since it is
+// expected to receive the outputs of every vote. When the pool is shared, the
+// wallet only votes with the winning tickets it owns and thus receives a
+// fraction of the outputs of the votes, so it instead purchases as many
+// tickets as its funds allow, up to TicketsPerBlock. Once the initial funds of
+// the wallets are exhausted, their combined purchases match the number of
+// votes cast by them on every block.
+func (w *VotingWallet) SetSharedTicketPool(shared bool) {
+	w.mtx.Lock()
+	w.sharedTicketPool = shared
+	w.mtx.Unlock()
+}
+
+// SetTicketsPerBlock sets the number of tickets purchased by the wallet on each
+// block, which defaults to TicketsPerBlock. Purchasing more or fewer tickets
+// allows modeling an oversupply or undersupply of the live ticket pool.
+//
+// When called before Start, the wallet is funded with enough outputs for the
+// given number of tickets. Afterwards, an error is returned when the available
+// and maturing outputs of the wallet cannot fund the given number of tickets
+// for the next CoinbaseMaturity blocks, unless auto refunding is enabled.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetTicketsPerBlock(n int) error {
+	net := w.hn.ActiveNet
+	if n < 0 || n > int(net.MaxFreshStakePerBlock) {
+		return fmt.Errorf("number of tickets per block %d is not in the "+
+			"range [0, %d]", n, net.MaxFreshStakePerBlock)
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.started && !w.observer && !w.autoRefund {
+		tipHeight := w.stakeActivity.Height
+		maxHeight := w.sustainableHeight(tipHeight, n)
+		if depleted := tipHeight + int64(net.CoinbaseMaturity); maxHeight < depleted {
+			return fmt.Errorf("purchasing %d tickets per block depletes "+
+				"the wallet after block %d, within %d blocks", n,
+				maxHeight, net.CoinbaseMaturity)
+		}
+	}
+	w.ticketsPerBlock = n
+	return nil
+}
+
+// PauseTicketBuying makes the wallet stop purchasing tickets on new blocks,
+// while it keeps voting with the tickets it already owns, until
+// ResumeTicketBuying is called. This allows letting the live ticket pool shrink
+// to observe the response of the stake difficulty.
+//
+// The network does not extend the chain once the live ticket pool projected
+// after TicketMaturity blocks cannot sustain voting, so pausing requires the
+// pool to hold surplus tickets, such as ones purchased with SetTicketsPerBlock
+// set above TicketsPerBlock. Once the surplus is consumed, GenerateBlocks
+// returns an error wrapping ErrInsufficientLiveTickets, so buying must be
+// resumed before that point to keep the chain going.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) PauseTicketBuying() {
+	w.mtx.Lock()
+	w.ticketBuyingPaused = true
+	w.mtx.Unlock()
+}
+
+// ResumeTicketBuying makes the wallet resume purchasing tickets on new blocks
+// after PauseTicketBuying.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) ResumeTicketBuying() {
+	w.mtx.Lock()
+	w.ticketBuyingPaused = false
+	w.mtx.Unlock()
+}
+
+// IsTicketBuyingPaused returns whether purchasing tickets is paused by
+// PauseTicketBuying.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) IsTicketBuyingPaused() bool {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.ticketBuyingPaused
+}
+
+// purchasedTicketsPerBlock returns the number of tickets the wallet currently
+// purchases on each block, which is zero while ticket buying is paused.
+//
+// This must be called with the mtx held.
+func (w *VotingWallet) purchasedTicketsPerBlock() int {
+	if w.ticketBuyingPaused {
+		return 0
+	}
+	return w.ticketsPerBlock
+}
+
+// SetRecycleChange sets whether the tickets purchased by the wallet pay their
+// change to the stake change script of the wallet instead of the default
+// change script, such that the change becomes available for purchasing new
+// tickets once it matures. This reduces the funding required by very long test
+// runs, although the change is only used when it is enough to purchase a
+// ticket, such as when the commit amount multiplier is increased.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetRecycleChange(enabled bool) {
+	w.mtx.Lock()
+	w.recycleChange = enabled
+	w.mtx.Unlock()
+}
+
+// SetTicketPriceStrategy sets the strategy that determines the price of the
+// tickets purchased by the wallet. Passing nil restores the default of
+// TicketPricePadded. TicketPriceExact purchases tickets at exactly the stake
+// difficulty of the next block instead, which avoids overpaying for them.
+//
+// Tickets priced below the stake difficulty of the next block are rejected by
+// the network, which is reported as an error of kind ErrTicketPriceRejected
+// that does not stop the wallet, even when failing fast.
+//
+// It takes effect on the tickets purchased for subsequent blocks.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetTicketPriceStrategy(strategy TicketPriceStrategy) {
+	w.mtx.Lock()
+	w.ticketPriceStrategy = strategy
+	w.mtx.Unlock()
+}
+
+// SetRevocationPolicy sets the policy that determines when the wallet revokes
+// its missed and expired tickets. Passing nil restores the default of
+// RevokeImmediately.
+//
+// Delaying revocations allows testing the window between a ticket becoming
+// missed and being revoked. Note that once the automatic ticket revocations
+// agenda is active, the network revokes those tickets in the block where they
+// become so regardless of the policy.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetRevocationPolicy(policy RevocationPolicy) {
+	w.mtx.Lock()
+	w.revocationPolicy = policy
+	w.mtx.Unlock()
+}
+
+// SetDeterministicUtxoSelection sets whether the wallet sorts its available
+// utxos by outpoint before selecting the ones that fund the tickets it
+// purchases. The outputs of votes become available in the order the votes were
+// published, which depends on the order of the winning tickets, so sorting
+// makes the mapping of tickets to the utxos that fund them identical across
+// repeated test runs.
+//
+// Sorting costs O(n log n) in the number of available utxos on every block the
+// wallet purchases tickets, which is negligible for the default funding but
+// may become noticeable for wallets that accumulate many thousands of utxos.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetDeterministicUtxoSelection(enabled bool) {
+	w.mtx.Lock()
+	w.sortUtxos = enabled
+	w.mtx.Unlock()
+}
+
+// sortUtxosByOutpoint sorts the given utxos in ascending order of their
+// outpoints, comparing the transaction hash, then the output index and then
+// the tree.
+func sortUtxosByOutpoint(utxos []utxoInfo) {
+	sort.Slice(utxos, func(i, j int) bool {
+		a, b := &utxos[i].outpoint, &utxos[j].outpoint
+		if cmp := bytes.Compare(a.Hash[:], b.Hash[:]); cmp != 0 {
+			return cmp < 0
+		}
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+		return a.Tree < b.Tree
+	})
+}
+
+// SetAutoRefund sets whether the wallet automatically funds itself with new
+// outputs from the harness once it holds fewer than twice the number of tickets
+// it purchases per block, which allows indefinitely long test runs without the
+// wallet starving. The new outputs are created in the same manner as the ones
+// funded by Start and cover CoinbaseMaturity blocks worth of tickets. They are
+// used to purchase tickets once the transaction that funds them is mined.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetAutoRefund(enabled bool) {
+	w.mtx.Lock()
+	w.autoRefund = enabled
+	w.mtx.Unlock()
+}
+
+// SetFailFast sets whether the first error encountered while purchasing
+// tickets or generating votes stops the wallet. Once stopped, the wallet no
+// longer handles notifications and GenerateBlocks returns the error that
+// stopped it, instead of a later and less descriptive timeout.
+//
+// This MUST be called before Start.
+func (w *VotingWallet) SetFailFast(enabled bool) {
+	w.mtx.Lock()
+	w.failFast = enabled
+	w.mtx.Unlock()
+}
+
+// failure returns the error that stopped the wallet in fail-fast mode, if
+// any.
+func (w *VotingWallet) failure() error {
+	select {
+	case <-w.failed:
+		return w.failErr
+	default:
+		return nil
+	}
+}
+
+// SetFeeReporting allows users of the voting wallet to specify a function that
+// will be called with the fee information of every ticket and vote the wallet
+// successfully publishes.
+//
+// Tickets pay a fee according to the wallet's fee rate, while votes do not pay
+// any fee.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetFeeReporting(f func(info *TxFeeInfo)) {
+	w.mtx.Lock()
+	w.feeReporter = f
+	w.mtx.Unlock()
+}
+
+// SetOnSteadyState allows users of the voting wallet to specify a function that
+// will be called exactly once, the first time the wallet successfully publishes
+// TicketsPerBlock votes on a single block. This signals the chain has become
+// self-sustaining. Votes that fail to be sent or are not published in dry-run
+// mode do not count.
+//
+// The function is called with the height of the block being voted on and is
+// executed on the notification handling goroutine, therefore it must not block.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetOnSteadyState(f func(height int64)) {
+	w.mtx.Lock()
+	w.onSteadyState = f
+	w.mtx.Unlock()
+}
+
+// ReadyAtSVH returns a channel that is closed once the first block at or after
+// SVH that includes votes of the wallet is connected. This signals the votes of
+// the wallet are accepted by the network and the chain is able to advance past
+// SVH, without polling the best block.
+//
+// The channel is never closed for wallets in observer mode.
+func (w *VotingWallet) ReadyAtSVH() <-chan struct{} {
+	return w.ready
+}
+
+// SetTicketPurchasedCallback allows users of the voting wallet to specify a
+// function that will be called once for every ticket the wallet successfully
+// publishes as blocks are connected, with the hash of the ticket, its price and
+// the height of the block that triggered the purchase. Tickets purchased by
+// BuyTicketFromStakeOutput are not reported, since their hash is returned to
+// the caller.
+//
+// The function is executed on the notification handling goroutine without
+// holding any internal lock, so it may call back into the wallet, but it must
+// not block.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetTicketPurchasedCallback(f func(hash *chainhash.Hash, price int64, height int64)) {
+	w.mtx.Lock()
+	w.ticketPurchased = f
+	w.mtx.Unlock()
+}
+
+// SetVoteCastCallback allows users of the voting wallet to specify a function
+// that will be called once for every vote the wallet successfully publishes to
+// the mempool, with the hash of the vote, the hash of the ticket it redeems and
+// the height of the block being voted on.
+//
+// The function is executed on the notification handling goroutine without
+// holding any internal lock, so it may call back into the wallet, but it must
+// not block.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetVoteCastCallback(f func(voteHash *chainhash.Hash, ticketHash *chainhash.Hash, blockHeight int64)) {
+	w.mtx.Lock()
+	w.voteCast = f
+	w.mtx.Unlock()
+}
+
+// SetVoteSummaryCallback allows users of the voting wallet to specify a
+// function that will be called once for every winning tickets notification
+// handled by the wallet, with the number of winning tickets owned by the
+// wallet, the number of votes it cast and the number of winning tickets that
+// did not vote along with the reason.
+//
+// The function is executed on the notification handling goroutine without
+// holding any internal lock, so it may call back into the wallet, but it must
+// not block.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetVoteSummaryCallback(f func(summary *VoteSummary)) {
+	w.mtx.Lock()
+	w.voteSummary = f
+	w.mtx.Unlock()
+}
+
+// Events returns the stream of events describing the activity of the wallet:
+// its published tickets and votes, its mined revocations and the errors it
+// encounters.
+//
+// The stream is buffered and events are dropped, as reported by DroppedEvents,
+// instead of blocking the wallet when they are not consumed fast enough. The
+// channel is never closed.
+func (w *VotingWallet) Events() <-chan WalletEvent {
+	return w.events
+}
+
+// DroppedEvents returns the number of events dropped because the event stream
+// returned by Events was full.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&w.droppedEvents)
+}
+
+// emitEvent sends the given event to the event stream of the wallet, dropping
+// it when the stream is full.
+func (w *VotingWallet) emitEvent(event WalletEvent) {
+	select {
+	case w.events <- event:
+	default:
+		atomic.AddUint64(&w.droppedEvents, 1)
+	}
+}
+
+// SetMiner allows users of the voting wallet to specify a function that will
+// be used to mine new blocks instead of using the regular Generate function of
+// the configured rpcclient.
 //
 // This allows callers to use a custom function to generate blocks, such as one
 // that allows faster mining in simnet.
@@ -285,232 +2272,3496 @@ func (w *VotingWallet) SetMiner(f func(context.Context, uint32) ([]*chainhash.Ha
 	w.miner = f
 }
 
-// LimitNbVotes limits the number of votes issued by the voting wallet to the
-// given amount, which is useful for testing scenarios where less than the
-// total number of votes per block are cast in the network.
+// SetBlockVerifier sets a function that GenerateBlocks calls after generating
+// each block with its height and the number of votes and tickets it includes,
+// from any party, which allows tests to assert invariants block by block. When
+// the function returns an error, block generation stops with an error wrapping
+// it. Passing nil removes the verifier.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetBlockVerifier(f func(height int64, votesInBlock, ticketsInBlock int) error) {
+	w.mtx.Lock()
+	w.blockVerifier = f
+	w.mtx.Unlock()
+}
+
+// SetBatchMiner makes GenerateBlocks mine up to the given number of blocks with
+// each call to the given function instead of one block at a time. A nil
+// function keeps the current miner, which is useful to batch the blocks of a
+// miner set by SetMiner. A size of one or less restores mining one block at a
+// time.
+//
+// The miner is responsible for producing valid blocks, so it must rely on the
+// node to only provide work once the votes for the previous block are received,
+// such as AdjustedSimnetMiner does. Batch mining speeds up long simnet runs at
+// the cost of per-block control: the live ticket pool is only checked before
+// each batch and the tickets purchased in response to a block in the middle of
+// a batch may be included in a later block. GenerateBlocks only confirms the
+// wallet submitted its votes and tickets in response to those blocks, while the
+// ones for the final block of each batch are waited for in the mempool as
+// usual.
+func (w *VotingWallet) SetBatchMiner(size int, f func(context.Context, uint32) ([]*chainhash.Hash, error)) {
+	if f != nil {
+		w.miner = f
+	}
+	w.batchSize = 0
+	if size > 1 {
+		w.batchSize = uint32(size)
+	}
+}
+
+// SetDeterministicTimestamps makes the blocks generated by the wallet follow a
+// deterministic schedule, such that the timestamp of the block at each height
+// is the given base time plus the given interval for every height, truncated to
+// whole seconds. This allows reproducing tests that depend on the median time
+// of the blocks.
+//
+// Since the timestamps are set while mining, the blocks are mined by the wallet
+// with TimestampedSimnetMiner, instead of the function set by SetMiner. The
+// schedule must be valid for the chain, so the base time is usually derived
+// from the timestamp of the current best block, and an interval other than one
+// second triggers difficulty changes on simnet.
+//
+// A non-positive interval restores the regular block timestamps.
+func (w *VotingWallet) SetDeterministicTimestamps(base time.Time, interval time.Duration) {
+	w.timestampBase = base
+	w.timestampInterval = interval
+}
+
+// deterministicTimestamp returns the timestamp of the block at the given height
+// according to the schedule set by SetDeterministicTimestamps.
+func (w *VotingWallet) deterministicTimestamp(height uint32) time.Time {
+	t := w.timestampBase.Add(time.Duration(height) * w.timestampInterval)
+	return t.Truncate(time.Second)
+}
+
+// SetBlockGenTimeout sets the maximum amount of time GenerateBlocks waits for
+// the votes and tickets of the wallet to reach the mempool after generating
+// each block before failing. A duration that is not positive restores the
+// default of 5 seconds.
+//
+// This must be called before Start.
+func (w *VotingWallet) SetBlockGenTimeout(d time.Duration) {
+	if d <= 0 {
+		d = defaultBlockGenTimeout
+	}
+	w.blockGenTimeout = d
+}
+
+// SetBlockGenPollInterval sets the interval at which GenerateBlocks checks the
+// mempool for the votes and tickets of the wallet after generating each block.
+// A duration that is not positive restores the default of 2 milliseconds.
+//
+// This must be called before Start.
+func (w *VotingWallet) SetBlockGenPollInterval(d time.Duration) {
+	if d <= 0 {
+		d = defaultBlockGenPollInterval
+	}
+	w.blockGenPollInterval = d
+}
+
+// SetFeeRate sets the fee rate (in atoms/kB) used for the transactions of the
+// wallet that pay fees, which are its funding transaction and its tickets.
+// Votes do not pay fees. A rate that is not positive restores the default rate
+// of 1e4 atoms/kB.
+//
+// Note that the default minimum relay fee of the network nodes is 1e4
+// atoms/kB, so lower rates create transactions that are not relayed unless
+// the nodes are configured otherwise.
+//
+// This must be called before Start.
+func (w *VotingWallet) SetFeeRate(rate dcrutil.Amount) {
+	if rate <= 0 {
+		rate = feeRate
+	}
+	w.mtx.Lock()
+	w.feeRate = rate
+	w.mtx.Unlock()
+}
+
+// txFeeRate returns the fee rate of the transactions of the wallet that pay
+// fees.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) txFeeRate() dcrutil.Amount {
+	w.mtx.Lock()
+	rate := w.feeRate
+	w.mtx.Unlock()
+	return rate
+}
+
+// SetFeeLimits sets the limits, in atoms, on the fees of the votes and
+// revocations of the tickets purchased by the wallet, which are encoded in the
+// commitment outputs of the tickets. A limit of zero does not impose any limit
+// on the fee. The defaults are no limit on the fee of votes and a limit of
+// 2^24 atoms on the fee of revocations.
+//
+// The commitment encodes the limits as powers of two, so each non-zero limit
+// must be a power of two for it to be enforced exactly.
+//
+// This must be called before Start, since the tickets purchased by the wallet
+// already encode the previous limits.
+func (w *VotingWallet) SetFeeLimits(voteLimit, revokeLimit int64) error {
+	isPowerOfTwo := func(limit int64) bool {
+		return limit > 0 && limit&(limit-1) == 0
+	}
+	if voteLimit != 0 && !isPowerOfTwo(voteLimit) {
+		return fmt.Errorf("vote fee limit %d is not a power of two",
+			voteLimit)
+	}
+	if revokeLimit != 0 && !isPowerOfTwo(revokeLimit) {
+		return fmt.Errorf("revocation fee limit %d is not a power of two",
+			revokeLimit)
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.started {
+		return fmt.Errorf("cannot change fee limits after the wallet is " +
+			"started")
+	}
+	w.voteFeeLimit = voteLimit
+	w.revokeFeeLimit = revokeLimit
+	return nil
+}
+
+// SetCommitAmountMultiplier sets the multiplier for the minimum stake
+// difficulty used to fund the inputs of the tickets purchased by the wallet.
+// The amount left after paying for a ticket at the minimum stake difficulty
+// must be greater than the dust limit.
+//
+// This must be called before Start, since the wallet is funded when started.
+func (w *VotingWallet) SetCommitAmountMultiplier(mult int64) error {
+	w.mtx.Lock()
+	started := w.started
+	w.mtx.Unlock()
+	if started {
+		return fmt.Errorf("cannot change commit amount multiplier after " +
+			"the wallet is started")
+	}
+
+	minStakeDiff := w.hn.ActiveNet.MinimumStakeDiff
+	if mult < 1 || minStakeDiff*mult-minStakeDiff <= p2pkhDustLimit {
+		return fmt.Errorf("commit amount multiplier %d leaves an amount "+
+			"not greater than the dust limit of %d", mult, p2pkhDustLimit)
+	}
+
+	w.commitAmountMultiplier = mult
+	return nil
+}
+
+// SetVoteBits sets the vote bits cast by the votes of the wallet, which allows
+// tests to drive agenda voting. It takes effect on the votes cast for
+// subsequent winning tickets notifications.
+//
+// The block validity bit (bit 0) must be set. Use SetBlockValidity to
+// disapprove the previous block instead.
+func (w *VotingWallet) SetVoteBits(bits uint16) error {
+	if bits&voteBitsBlockValid == 0 {
+		return fmt.Errorf("vote bits %#04x do not approve the previous block",
+			bits)
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.setVoteScript(bits, w.voteVersion)
+}
+
+// SetVoteVersion sets the vote version cast by the votes of the wallet along
+// with its vote bits, which is the stake version reported by the votes. This
+// allows tests to drive stake version upgrades. The default vote version is
+// zero. It takes effect on the votes cast for subsequent winning tickets
+// notifications, so it may be changed between blocks.
+//
+// SetVoteBits keeps the vote version, while SetAgendaChoices replaces it with
+// the vote version of the selected agendas.
+//
+// Note that the vote version is independent of the transaction version of the
+// votes, which remains limited by consensus to the versions required by the
+// outputs of the votes.
+func (w *VotingWallet) SetVoteVersion(version uint32) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.setVoteScript(w.voteBits, version)
+}
+
+// SetBlockValidity sets whether the votes of the wallet approve the regular
+// transaction tree of the block being voted on, which they do by default. When
+// the majority of the votes included in a block disapprove its parent, the
+// regular transactions of the parent are disregarded and return to the mempool.
+// This applies on top of the vote bits set by SetVoteBits, SetAgendaChoices and
+// the vote choices selector.
+//
+// It takes effect on the votes cast for subsequent winning tickets
+// notifications.
+func (w *VotingWallet) SetBlockValidity(valid bool) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	disapproveBlock := w.disapproveBlock
+	w.disapproveBlock = !valid
+	if err := w.setVoteScript(w.voteBits, w.voteVersion); err != nil {
+		w.disapproveBlock = disapproveBlock
+		return err
+	}
+	return nil
+}
+
+// setVoteScript sets the script of the votes cast by the wallet to cast the
+// given vote bits under the given vote version, with the block validity bit
+// cleared when the wallet disapproves blocks. A vote version of zero uses the
+// short form of the script that does not encode the version.
+//
+// This function MUST be called with the wallet mutex held.
+func (w *VotingWallet) setVoteScript(bits uint16, version uint32) error {
+	scriptBits := bits
+	if w.disapproveBlock {
+		scriptBits &^= voteBitsBlockValid
+	}
+	var voteScript []byte
+	var err error
+	if version == 0 {
+		voteScript, err = txscript.GenerateSSGenVotes(scriptBits)
+	} else {
+		voteScript, err = extendedVoteBitsScript(scriptBits, version)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to prepare vote script: %v", err)
+	}
+
+	w.voteScriptVer = 0
+	w.voteScript = voteScript
+	w.voteBits = bits
+	w.voteVersion = version
+	return nil
+}
+
+// SetAgendaChoices sets the vote bits cast by the votes of the wallet from the
+// given agenda choices, keyed by agenda ID, such as {"treasury": "yes"}. The
+// vote bits always approve the previous block and agendas not referenced
+// abstain. An empty set of choices restores the default vote bits.
+//
+// All referenced agendas must be deployed under a single vote version of the
+// active network, which is the version cast by the votes. When an agenda is
+// deployed under multiple vote versions, the highest one is used. It returns an
+// error if an agenda or choice ID is unknown.
+//
+// It takes effect on the votes cast for subsequent winning tickets
+// notifications.
+func (w *VotingWallet) SetAgendaChoices(choices map[string]string) error {
+	if len(choices) == 0 {
+		return w.SetVoteBits(voteBitsBlockValid)
+	}
+
+	voteBits, voteVersion, err := w.agendaVoteBits(choices)
+	if err != nil {
+		return err
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.setVoteScript(voteBits, voteVersion)
+}
+
+// VoteChoicesSelector returns the agenda choices, keyed by agenda ID, cast by
+// the vote of the given ticket. The choices follow the same rules as the ones
+// passed to SetAgendaChoices. Returning no choices casts the vote bits set for
+// the wallet.
+type VoteChoicesSelector func(ticket *chainhash.Hash) map[string]string
+
+// BlockRefScriptFunc builds the block reference script of a vote for the block
+// with the given hash and height.
+type BlockRefScriptFunc func(hash chainhash.Hash, height uint32) ([]byte, error)
+
+// SetBlockRefScriptFunc sets a function that builds the block reference script
+// of the votes of the wallet, which is the first output of every vote. This
+// allows testing alternate or malformed block reference encodings. Passing nil
+// restores the default of txscript.GenerateSSGenBlockRef.
+//
+// Votes built with a custom function are not checked for validity by the
+// wallet before being published, such that malformed votes are rejected by
+// the network instead.
+//
+// It takes effect on the votes cast for subsequent winning tickets
+// notifications.
+func (w *VotingWallet) SetBlockRefScriptFunc(f BlockRefScriptFunc) {
+	w.mtx.Lock()
+	w.blockRefScriptFunc = f
+	w.mtx.Unlock()
+}
+
+// SetExtraVoteOutput sets the script of an additional output appended to the
+// votes of the wallet after the standard outputs, which allows exercising the
+// handling of votes that carry additional provably unspendable data. The script
+// must be a null data script. Passing nil removes the output.
+//
+// The votes are checked for validity once the output is appended and the ones
+// that are invalid are reported as an error of kind ErrTxCreationFailed instead
+// of being published. Note that the consensus rules only allow a single null
+// data output at the end of a vote, which must be a treasury vote, so the votes
+// are treasury votes when the output is set and they are only valid when the
+// script is a treasury vote and the wallet does not vote on any tspends.
+//
+// It takes effect on the votes cast for subsequent winning tickets
+// notifications.
+func (w *VotingWallet) SetExtraVoteOutput(script []byte) error {
+	if script != nil && !stake.IsNullDataScript(0, script) {
+		return fmt.Errorf("extra vote output script %x is not a null "+
+			"data script", script)
+	}
+	w.mtx.Lock()
+	if script == nil {
+		w.extraVoteOutput = nil
+	} else {
+		w.extraVoteOutput = append([]byte{}, script...)
+	}
+	w.mtx.Unlock()
+	return nil
+}
+
+// SetStakeBaseSigScript sets the signature script of the stakebase input of the
+// votes of the wallet. This allows testing that the network rejects votes with
+// an invalid stakebase. Passing nil restores the default of the
+// StakeBaseSigScript of the network, which produces valid votes.
+//
+// Votes with a custom stakebase script are not checked for validity by the
+// wallet before being published, such that invalid votes are rejected by the
+// network instead.
+//
+// It takes effect on the votes cast for subsequent winning tickets
+// notifications.
+func (w *VotingWallet) SetStakeBaseSigScript(script []byte) {
+	w.mtx.Lock()
+	if script == nil {
+		w.stakeBaseSigScript = nil
+	} else {
+		w.stakeBaseSigScript = append([]byte{}, script...)
+	}
+	w.mtx.Unlock()
+}
+
+// voteBitsVersion is the combination of vote bits and vote version cast by a
+// vote.
+type voteBitsVersion struct {
+	bits    uint16
+	version uint32
+}
+
+// SetVoteChoicesSelector sets a function that selects the agenda choices cast
+// by the vote of each individual ticket of the wallet, such that the tickets of
+// a single wallet may model a split electorate. Passing nil restores casting
+// the vote bits set for the wallet by all tickets.
+//
+// Invalid choices returned by the selector are reported to the error reporter
+// and the vote bits set for the wallet are cast instead.
+//
+// It takes effect on the votes cast for subsequent winning tickets
+// notifications.
+func (w *VotingWallet) SetVoteChoicesSelector(selector VoteChoicesSelector) {
+	w.mtx.Lock()
+	w.voteChoicesSelector = selector
+	w.mtx.Unlock()
+}
+
+// selectedVoteScript returns the vote script cast by the given ticket
+// according to the agenda choices returned by the vote choices selector, with
+// the block validity bit cleared when the block is disapproved. Scripts are
+// cached by the resulting vote bits and vote version. It returns false when no
+// choices are selected for the ticket.
+//
+// This must only be called from the notification handler goroutine.
+func (w *VotingWallet) selectedVoteScript(selector VoteChoicesSelector,
+	ticket *chainhash.Hash, disapproveBlock bool) ([]byte, bool, error) {
+
+	choices := selector(ticket)
+	if len(choices) == 0 {
+		return nil, false, nil
+	}
+	voteBits, voteVersion, err := w.agendaVoteBits(choices)
+	if err != nil {
+		return nil, false, err
+	}
+	if disapproveBlock {
+		voteBits &^= voteBitsBlockValid
+	}
+
+	key := voteBitsVersion{bits: voteBits, version: voteVersion}
+	if script, ok := w.voteScriptCache[key]; ok {
+		return script, true, nil
+	}
+	script, err := extendedVoteBitsScript(voteBits, voteVersion)
+	if err != nil {
+		return nil, false, err
+	}
+	w.voteScriptCache[key] = script
+	return script, true, nil
+}
+
+// agendaVoteBits returns the vote bits and vote version that cast the given
+// agenda choices, keyed by agenda ID, as described by SetAgendaChoices.
+func (w *VotingWallet) agendaVoteBits(choices map[string]string) (uint16, uint32, error) {
+	// Find the highest vote version that deploys all referenced agendas.
+	versions := make([]uint32, 0, len(w.hn.ActiveNet.Deployments))
+	for version := range w.hn.ActiveNet.Deployments {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+	var agendas map[string]*chaincfg.Vote
+	var voteVersion uint32
+	for _, version := range versions {
+		deployments := w.hn.ActiveNet.Deployments[version]
+		found := make(map[string]*chaincfg.Vote, len(choices))
+		for i := range deployments {
+			vote := &deployments[i].Vote
+			if _, ok := choices[vote.Id]; ok {
+				found[vote.Id] = vote
+			}
+		}
+		if len(found) == len(choices) {
+			agendas, voteVersion = found, version
+			break
+		}
+	}
+	if agendas == nil {
+		for agendaID := range choices {
+			if !w.agendaDeployed(agendaID) {
+				return 0, 0, fmt.Errorf("unknown agenda %q", agendaID)
+			}
+		}
+		return 0, 0, fmt.Errorf("agendas are not deployed under a single " +
+			"vote version")
+	}
+
+	voteBits := uint16(voteBitsBlockValid)
+	for agendaID, choiceID := range choices {
+		agenda := agendas[agendaID]
+		var choice *chaincfg.Choice
+		for i := range agenda.Choices {
+			if agenda.Choices[i].Id == choiceID {
+				choice = &agenda.Choices[i]
+				break
+			}
+		}
+		if choice == nil {
+			return 0, 0, fmt.Errorf("unknown choice %q for agenda %q",
+				choiceID, agendaID)
+		}
+		voteBits |= choice.Bits & agenda.Mask
+	}
+	return voteBits, voteVersion, nil
+}
+
+// agendaDeployed returns whether the agenda with the given ID is deployed under
+// any vote version of the active network.
+func (w *VotingWallet) agendaDeployed(agendaID string) bool {
+	for _, deployments := range w.hn.ActiveNet.Deployments {
+		for i := range deployments {
+			if deployments[i].Vote.Id == agendaID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extendedVoteBitsScript returns the vote bits script of a vote that casts the
+// given vote bits with the given vote version.
+func extendedVoteBitsScript(voteBits uint16, voteVersion uint32) ([]byte, error) {
+	var data [6]byte
+	binary.LittleEndian.PutUint16(data[0:2], voteBits)
+	binary.LittleEndian.PutUint32(data[2:6], voteVersion)
+	return txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).
+		AddData(data[:]).Script()
+}
+
+// VoteConfiguration describes the votes the wallet casts for subsequent winning
+// tickets notifications, as returned by VotingWallet.VoteConfiguration.
+type VoteConfiguration struct {
+	// VoteBits are the vote bits cast by the votes, which have the block
+	// validity bit cleared when BlockValid is false.
+	VoteBits uint16
+
+	// VoteVersion is the vote version cast by the votes.
+	VoteVersion uint32
+
+	// BlockValid indicates whether the votes approve the regular
+	// transaction tree of the block being voted on.
+	BlockValid bool
+
+	// AgendaChoices are the choices cast by the vote bits, keyed by agenda
+	// ID, for every agenda deployed under the vote version. Agendas for
+	// which the vote bits do not match any choice are omitted.
+	AgendaChoices map[string]string
+
+	// PerTicketChoices indicates a vote choices selector is set, which
+	// overrides the vote bits for the tickets it selects choices for.
+	PerTicketChoices bool
+
+	// TSpendVotes are the treasury votes set with VoteForTSpends,
+	// including the ones abstaining, which are omitted from the votes.
+	TSpendVotes []stake.TreasuryVoteTuple
+}
+
+// VoteConfiguration returns the configuration of the votes the wallet casts,
+// which allows tests to check the vote bits, agenda choices and treasury votes
+// are the intended ones before any votes are cast. This mirrors the
+// getvoteinfo RPC of the node, but from the point of view of the wallet.
+func (w *VotingWallet) VoteConfiguration() *VoteConfiguration {
+	w.mtx.Lock()
+	voteBits := w.voteBits
+	voteVersion := w.voteVersion
+	blockValid := !w.disapproveBlock
+	perTicketChoices := w.voteChoicesSelector != nil
+	tspendVotes := make([]stake.TreasuryVoteTuple, 0, len(w.tspendVotes))
+	for _, v := range w.tspendVotes {
+		tspendVotes = append(tspendVotes, *v)
+	}
+	w.mtx.Unlock()
+
+	choices := make(map[string]string)
+	deployments := w.hn.ActiveNet.Deployments[voteVersion]
+	for i := range deployments {
+		agenda := &deployments[i].Vote
+		bits := voteBits & agenda.Mask
+		for j := range agenda.Choices {
+			if agenda.Choices[j].Bits == bits {
+				choices[agenda.Id] = agenda.Choices[j].Id
+				break
+			}
+		}
+	}
+
+	if !blockValid {
+		voteBits &^= voteBitsBlockValid
+	}
+	return &VoteConfiguration{
+		VoteBits:         voteBits,
+		VoteVersion:      voteVersion,
+		BlockValid:       blockValid,
+		AgendaChoices:    choices,
+		PerTicketChoices: perTicketChoices,
+		TSpendVotes:      tspendVotes,
+	}
+}
+
+// SetMissedGracePeriod sets the number of blocks past its voting opportunity
+// that a winning ticket of the wallet may go without a confirmed vote before
+// it is considered missed. This avoids prematurely considering tickets missed
+// when their votes are confirmed late. Negative values are treated as zero.
+//
+// The default grace period is zero, which considers a ticket missed as soon as
+// the block following the one it was selected to vote on does not include its
+// vote.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetMissedGracePeriod(blocks int) {
+	if blocks < 0 {
+		blocks = 0
+	}
+	w.mtx.Lock()
+	w.missedGracePeriod = int64(blocks)
+	w.mtx.Unlock()
+}
+
+// SetMissedVoteRate makes the wallet deliberately skip voting with the given
+// fraction of its winning tickets, which simulates poor voter participation.
+// The skipped tickets are selected at random from a deterministically seeded
+// source, so the same tickets are skipped when a test is repeated. They are
+// later detected as missed and revoked like any other missed ticket.
+//
+// The wallet still casts enough votes for the block to be extended, as long as
+// it holds enough winning tickets to do so. A rate of zero disables skipping
+// votes, while rates outside of the [0, 1] range are rejected.
+//
+// Setting a rate restarts the source of randomness.
+func (w *VotingWallet) SetMissedVoteRate(fraction float64) error {
+	if fraction < 0 || fraction > 1 || math.IsNaN(fraction) {
+		return fmt.Errorf("missed vote rate %v is not in the range [0, 1]",
+			fraction)
+	}
+
+	w.mtx.Lock()
+	w.missedVoteRate = fraction
+	w.missedVoteRand = rand.New(rand.NewSource(missedVoteSeed))
+	w.mtx.Unlock()
+	return nil
+}
+
+// VoteSelection identifies how the voting wallet selects the winning tickets
+// that vote when it owns more of them than the number of votes it is limited
+// to.
+type VoteSelection int
+
+const (
+	// VoteSelectionFirstN, the default, votes with the first winning
+	// tickets in the order of the winning tickets notification.
+	VoteSelectionFirstN VoteSelection = iota
+
+	// VoteSelectionRandom votes with winning tickets selected at random
+	// from a deterministically seeded source.
+	VoteSelectionRandom
+)
+
+// SetVoteSelection sets how the wallet selects the winning tickets that vote
+// when the number of votes is limited by LimitNbVotes. Selecting them at random
+// avoids biasing tests towards the order of the winning tickets notification.
+// The random selection is deterministically seeded, so the same tickets vote
+// when a test is repeated.
+//
+// Setting a mode restarts the source of randomness.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetVoteSelection(mode VoteSelection) error {
+	switch mode {
+	case VoteSelectionFirstN, VoteSelectionRandom:
+	default:
+		return fmt.Errorf("unknown vote selection mode %d", mode)
+	}
+
+	w.mtx.Lock()
+	w.voteSelection = mode
+	w.voteSelectionRand = rand.New(rand.NewSource(voteSelectionSeed))
+	w.mtx.Unlock()
+	return nil
+}
+
+// selectMissedVotes selects the winning tickets of the wallet that skip voting
+// on the block of the given notification according to the missed vote rate.
+// It also records the number of votes the wallet casts on the block, which is
+// used by GenerateBlocks to wait for them.
+//
+// This must be called with the mtx held.
+func (w *VotingWallet) selectMissedVotes(ntfn *winningTicketsNtfn) map[chainhash.Hash]struct{} {
+	if w.missedVoteRate == 0 {
+		return nil
+	}
+
+	var winners []*chainhash.Hash
+	for _, wt := range ntfn.winningTickets {
+		if _, ok := w.tickets[*wt]; ok {
+			winners = append(winners, wt)
+		}
+	}
+
+	// Never skip below the majority of votes required to extend the block.
+	majority := int(w.hn.ActiveNet.TicketsPerBlock)/2 + 1
+	maxSkipped := len(winners) - majority
+	skipped := make(map[chainhash.Hash]struct{})
+	for _, wt := range winners {
+		if len(skipped) >= maxSkipped {
+			break
+		}
+		if w.missedVoteRand.Float64() < w.missedVoteRate {
+			skipped[*wt] = struct{}{}
+		}
+	}
+
+	nbVotes := len(winners) - len(skipped)
+	if nbVotes > w.limitNbVotes {
+		nbVotes = w.limitNbVotes
+	}
+	for hash, count := range w.castVotes {
+		if count.blockHeight < ntfn.blockHeight {
+			delete(w.castVotes, hash)
+		}
+	}
+	w.castVotes[*ntfn.blockHash] = castVoteCount{
+		blockHeight: ntfn.blockHeight,
+		nbVotes:     nbVotes,
+	}
+	return skipped
+}
+
+// expectedVotes returns the number of votes the wallet casts on the given
+// block and whether that number is already known.
+func (w *VotingWallet) expectedVotes(blockHash *chainhash.Hash) (int, bool) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.missedVoteRate == 0 {
+		return w.limitNbVotes, true
+	}
+	count, ok := w.castVotes[*blockHash]
+	return count.nbVotes, ok
+}
+
+// submittedFor returns the record of the transactions submitted by the wallet
+// in response to the given block, creating it and pruning the records of older
+// blocks when needed.
+//
+// This must be called with the mtx held.
+func (w *VotingWallet) submittedFor(blockHash *chainhash.Hash, blockHeight int64) *submittedTxs {
+	if sub, ok := w.submitted[*blockHash]; ok {
+		return sub
+	}
+	for hash, sub := range w.submitted {
+		if sub.blockHeight < blockHeight {
+			delete(w.submitted, hash)
+		}
+	}
+	sub := &submittedTxs{blockHeight: blockHeight}
+	w.submitted[*blockHash] = sub
+	w.lastSubmitted = sub
+	return sub
+}
+
+// PendingTxHashes returns the hashes of the votes and tickets the wallet
+// submitted in response to the most recently connected block, which are
+// pending inclusion in the next block. These are the exact transactions
+// GenerateBlocks waits for before generating the next block.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) PendingTxHashes() (votes, tickets []*chainhash.Hash) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	sub := w.lastSubmitted
+	if sub == nil {
+		return nil, nil
+	}
+	votes = make([]*chainhash.Hash, len(sub.votes))
+	for i := range sub.votes {
+		hash := sub.votes[i]
+		votes[i] = &hash
+	}
+	tickets = make([]*chainhash.Hash, len(sub.tickets))
+	for i := range sub.tickets {
+		hash := sub.tickets[i]
+		tickets[i] = &hash
+	}
+	return votes, tickets
+}
+
+// waitSubmitted waits until the given function reports the votes and tickets
+// of the wallet for the given block, generated at the given height, are ready
+// as required. It returns an error when they are not ready within the block
+// generation timeout, the wallet fails or the context is done.
+func (w *VotingWallet) waitSubmitted(ctx context.Context, blockHash *chainhash.Hash,
+	height int64, needsVotes, needsTickets bool,
+	ready func(context.Context, *chainhash.Hash) (bool, bool)) error {
+
+	timeout := time.After(w.blockGenTimeout)
+	testTimeout := time.After(w.blockGenPollInterval)
+	gotAllReqs := !needsVotes && !needsTickets
+	for !gotAllReqs {
+		select {
+		case <-timeout:
+			votesReady, ticketsReady := ready(ctx, blockHash)
+			var notGot []string
+			if needsVotes && !votesReady {
+				notGot = append(notGot, "votes")
+			}
+			if needsTickets && !ticketsReady {
+				notGot = append(notGot, "tickets")
+			}
+
+			return fmt.Errorf("timeout waiting for %s at height %d "+
+				"after %v", strings.Join(notGot, ","), height,
+				w.blockGenTimeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.failed:
+			return w.failErr
+		case <-testTimeout:
+			votesReady, ticketsReady := ready(ctx, blockHash)
+			gotAllReqs = (!needsTickets || ticketsReady) &&
+				(!needsVotes || votesReady)
+			testTimeout = time.After(w.blockGenPollInterval)
+		}
+	}
+	return nil
+}
+
+// submittedDone returns whether the wallet finished submitting the votes and
+// tickets in response to the given block at the given height, regardless of
+// whether they are still in the mempool of the node.
+//
+// The submissions for a block are forgotten once the wallet submits for a later
+// block, which it only does after it finished with the given one since
+// notifications are handled in order.
+func (w *VotingWallet) submittedDone(blockHash *chainhash.Hash, blockHeight int64) (votesDone, ticketsDone bool) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	sub, ok := w.submitted[*blockHash]
+	if !ok {
+		done := w.lastSubmitted != nil &&
+			w.lastSubmitted.blockHeight > blockHeight
+		return done, done
+	}
+	ticketsDone = sub.ticketsDone &&
+		(w.sharedTicketPool || len(sub.tickets) >= w.purchasedTicketsPerBlock())
+	return sub.votesDone, ticketsDone
+}
+
+// submittedReady returns whether the wallet finished submitting the votes and
+// tickets in response to the given block and whether all of them are in the
+// mempool of the node.
+//
+// The wallet must submit the configured number of tickets unless the ticket
+// pool is shared, in which case the transactions of the other participants are
+// unknown and the mempool must additionally hold a full block of tickets and
+// the expected number of votes.
+func (w *VotingWallet) submittedReady(ctx context.Context, blockHash *chainhash.Hash) (votesReady, ticketsReady bool) {
+	nbTickets := int(w.hn.ActiveNet.TicketsPerBlock)
+	w.mtx.Lock()
+	sub, ok := w.submitted[*blockHash]
+	if !ok {
+		w.mtx.Unlock()
+		return false, false
+	}
+	shared := w.sharedTicketPool
+	votesReady = sub.votesDone
+	ticketsReady = sub.ticketsDone &&
+		(shared || len(sub.tickets) >= w.purchasedTicketsPerBlock())
+	hashes := make([]*chainhash.Hash, 0, len(sub.votes)+len(sub.tickets))
+	for i := range sub.votes {
+		hash := sub.votes[i]
+		hashes = append(hashes, &hash)
+	}
+	for i := range sub.tickets {
+		hash := sub.tickets[i]
+		hashes = append(hashes, &hash)
+	}
+	nbVotes := len(sub.votes)
+	w.mtx.Unlock()
+
+	if len(hashes) > 0 {
+		exists, err := w.c.ExistsMempoolTxs(ctx, hashes)
+		if err != nil {
+			return false, false
+		}
+		b, err := hex.DecodeString(exists)
+		if err != nil {
+			return false, false
+		}
+		set := bitset.Bytes(b)
+		for i := range hashes {
+			if set.Get(i) {
+				continue
+			}
+			if i < nbVotes {
+				votesReady = false
+			} else {
+				ticketsReady = false
+			}
+		}
+	}
+
+	if shared {
+		mempoolTickets, _ := w.c.GetRawMempool(ctx, dcrdtypes.GRMTickets)
+		mempoolVotes, _ := w.c.GetRawMempool(ctx, dcrdtypes.GRMVotes)
+		wantVotes, knownVotes := w.expectedVotes(blockHash)
+		ticketsReady = ticketsReady && len(mempoolTickets) >= nbTickets
+		votesReady = votesReady && knownVotes && len(mempoolVotes) >= wantVotes
+	}
+	return votesReady, ticketsReady
+}
+
+// SetEarlyMaturityBlocks makes the wallet consider the outputs of its votes
+// spendable the given number of blocks before they actually mature, such that
+// the tickets funded by them may spend immature outputs and be rejected by the
+// network. This is intended to exercise the handling of premature spends.
+// Negative values are treated as zero, which is the default.
+//
+// Note that the regular ticket purchases only spend the outputs of votes on
+// the block after they are considered spendable, so they are only rejected
+// when the outputs are considered spendable more than one block early, while
+// BuyTicketFromStakeOutput spends them right away.
+//
+// It takes effect on the votes cast for subsequent winning tickets
+// notifications.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetEarlyMaturityBlocks(n int) {
+	if n < 0 {
+		n = 0
+	}
+	w.mtx.Lock()
+	w.earlyMaturityBlocks = int64(n)
+	w.mtx.Unlock()
+}
+
+// MissedTickets returns the hashes of the tickets of the wallet that are
+// considered missed.
+func (w *VotingWallet) MissedTickets() []chainhash.Hash {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	missed := make([]chainhash.Hash, 0, len(w.missedTickets))
+	for ticket := range w.missedTickets {
+		missed = append(missed, ticket)
+	}
+	return missed
+}
+
+// AssertFullParticipation returns an error naming the first block in the given
+// range of heights, inclusive, that does not include as many votes of the
+// wallet as its vote limit, which is TicketsPerBlock unless lowered by
+// LimitNbVotes. Note the votes included in a block are the ones cast on its
+// parent, so the first block that may include votes is the one at SVH.
+//
+// An error is also returned for blocks that were not observed by the wallet.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) AssertFullParticipation(fromHeight, toHeight int64) error {
+	if fromHeight > toHeight {
+		return fmt.Errorf("invalid height range [%d, %d]", fromHeight,
+			toHeight)
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for height := fromHeight; height <= toHeight; height++ {
+		rec, ok := w.participation[height]
+		if !ok {
+			return fmt.Errorf("no participation recorded for block at "+
+				"height %d", height)
+		}
+		if rec.votes < rec.limit {
+			return fmt.Errorf("block at height %d includes %d votes of "+
+				"the wallet, less than %d", height, rec.votes, rec.limit)
+		}
+	}
+	return nil
+}
+
+// VoteRecord returns the hash and height of the block the given ticket of the
+// wallet voted on along with the hash of its vote. It returns false when the
+// ticket has not voted, including when the block it voted on was disconnected.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) VoteRecord(ticket *chainhash.Hash) (blockHash *chainhash.Hash, height int64, voteTx *chainhash.Hash, ok bool) {
+	w.mtx.Lock()
+	rec, ok := w.votedTickets[*ticket]
+	w.mtx.Unlock()
+	if !ok {
+		return nil, 0, nil, false
+	}
+	return &rec.blockHash, rec.blockHeight, &rec.voteHash, true
+}
+
+// RecentWins returns up to n of the most recent winning tickets notifications
+// in which tickets of the wallet were selected to vote, ordered from oldest to
+// newest, which allows asserting on the selection of the tickets of the wallet
+// over a run. A non-positive n returns all the recorded notifications.
+//
+// Only the most recent notifications are recorded, up to the size set with
+// SetWinHistorySize.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) RecentWins(n int) []WinRecord {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.wins.recent(n)
+}
+
+// SetWinHistorySize sets the number of winning tickets notifications recorded
+// for RecentWins, keeping the most recent ones already recorded. A non-positive
+// size restores the default of 256.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetWinHistorySize(size int) {
+	if size <= 0 {
+		size = defaultWinHistorySize
+	}
+	w.mtx.Lock()
+	w.wins.resize(size)
+	w.mtx.Unlock()
+}
+
+// LimitNbVotes limits the number of votes issued by the voting wallet to the
+// given amount, which is useful for testing scenarios where less than the
+// total number of votes per block are cast in the network.
+//
+// The wallet funds its tickets with the outputs of its votes once they mature,
+// so while the number of votes is limited, it consumes more outputs than it
+// gets back and simnet voting stops once the outputs it was funded with run
+// out, unless auto refunding is enabled. The limit may be increased up to
+// TicketsPerBlock at any time, but the outputs of the additional votes only
+// mature CoinbaseMaturity blocks later. Thus, when increasing the limit of a
+// started wallet, it funds itself from the harness on the next block with the
+// outputs it is missing to keep purchasing tickets until then.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) LimitNbVotes(newLimit int) error {
+	net := w.hn.ActiveNet
+	if newLimit < 0 {
+		return fmt.Errorf("cannot use negative number of votes")
+	}
+	if newLimit > int(net.TicketsPerBlock) {
+		return fmt.Errorf("number of votes %d exceeds the number of "+
+			"tickets per block %d", newLimit, net.TicketsPerBlock)
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if newLimit > w.limitNbVotes && w.started && !w.observer {
+		// Funding happens on the next block and the new outputs are
+		// available once mined, so cover the blocks until the votes
+		// cast after that block mature.
+		tipHeight := w.stakeActivity.Height
+		throughHeight := tipHeight + 1 + int64(net.CoinbaseMaturity)
+		shortfall := w.fundingShortfall(tipHeight, w.ticketsPerBlock,
+			throughHeight)
+		if shortfall > w.pendingFunding {
+			w.pendingFunding = shortfall
+		}
+	}
+
+	w.limitNbVotes = newLimit
+	return nil
+}
+
+// LimitVotesByFraction limits the number of votes issued by the voting wallet
+// to the given fraction of TicketsPerBlock, which expresses the participation
+// of the wallet as a rate, such as 0.6 to vote with 60% of the winning tickets.
+// Fractions outside of the [0, 1] range are rejected.
+//
+// The fraction is converted to an absolute limit, rounded to the nearest
+// integer, which is then applied by LimitNbVotes, so increasing the fraction
+// funds the wallet in the same manner.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) LimitVotesByFraction(f float64) error {
+	if f < 0 || f > 1 || math.IsNaN(f) {
+		return fmt.Errorf("vote fraction %v is not in the range [0, 1]", f)
+	}
+	limit := math.Round(f * float64(w.hn.ActiveNet.TicketsPerBlock))
+	return w.LimitNbVotes(int(limit))
+}
+
+// GenerateBlocks generates blocks while ensuring the chain will continue past
+// SVH indefinitely. This will generate a block then wait for the votes from
+// this wallet to be sent and tickets to be purchased before either generating
+// the next block or returning. The exact transactions submitted by the wallet
+// are waited for, so transactions submitted by other parties do not affect it.
+//
+// This function will either return the hashes of the generated blocks or an
+// error if, after generating a candidate block, votes and tickets aren't
+// submitted in a timely fashion.
+//
+// Before generating each block that requires votes, the live ticket pool is
+// checked and an error wrapping ErrInsufficientLiveTickets is returned if it
+// cannot sustain voting.
+//
+// When the context is done partway through, the hashes of the blocks generated
+// so far are returned along with an error wrapping the error of the context.
+func (w *VotingWallet) GenerateBlocks(ctx context.Context, nb uint32) ([]*chainhash.Hash, error) {
+	stats, err := w.GenerateBlocksWithStats(ctx, nb)
+	return stats.hashes(), err
+}
+
+// hashes returns the hashes of the generated blocks, or nil when the stats are
+// nil.
+func (s *GenerateStats) hashes() []*chainhash.Hash {
+	if s == nil {
+		return nil
+	}
+	hashes := make([]*chainhash.Hash, len(s.Blocks))
+	for i := range s.Blocks {
+		hashes[i] = s.Blocks[i].Hash
+	}
+	return hashes
+}
+
+// bestHeight returns the height of the best block of the node, retrying the
+// query when it fails.
+func (w *VotingWallet) bestHeight(ctx context.Context) (int64, error) {
+	var height int64
+	err := retryQuery(ctx, "obtain best block", func() error {
+		var err error
+		_, height, err = w.c.GetBestBlock(ctx)
+		return err
+	})
+	return height, err
+}
+
+// GenerateBlocksWithStats generates blocks in the same manner as GenerateBlocks
+// and returns the stats of every generated block, which allows confirming the
+// wallet voted and purchased tickets the expected number of times.
+//
+// When the context is done partway through, the stats of the blocks generated
+// so far are returned along with an error wrapping the error of the context.
+// Only the hash and height are known of a block generated right before the
+// context is done.
+func (w *VotingWallet) GenerateBlocksWithStats(ctx context.Context, nb uint32) (*GenerateStats, error) {
+	// Start from the height known from the notifications of the wallet to
+	// avoid querying the node. It is corrected from the best block of the
+	// node after each generated block in case it lags behind.
+	height, ok := w.ConnectedHeight()
+	if !ok {
+		var err error
+		height, err = w.bestHeight(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stats := &GenerateStats{Blocks: make([]BlockStats, 0, nb)}
+
+	// cancelled returns the stats of the blocks generated so far, including
+	// the given blocks starting at the given height if any, when the context
+	// is done.
+	cancelled := func(height int64, hashes ...*chainhash.Hash) (*GenerateStats, error) {
+		for i, h := range hashes {
+			stats.Blocks = append(stats.Blocks, BlockStats{
+				Hash:   h,
+				Height: height + int64(i),
+			})
+		}
+		return stats, fmt.Errorf("block generation stopped after %d of %d "+
+			"blocks: %w", len(stats.Blocks), nb, ctx.Err())
+	}
+
+	miner := w.c.Generate
+	if w.miner != nil {
+		miner = w.miner
+	}
+	if w.timestampInterval > 0 {
+		miner = func(ctx context.Context, nb uint32) ([]*chainhash.Hash, error) {
+			return TimestampedSimnetMiner(ctx, w.c, nb,
+				w.deterministicTimestamp)
+		}
+	}
+
+	for uint32(len(stats.Blocks)) < nb {
+		// genHeight is the height of the _next_ block (the one that will be
+		// generated once we call generate()).
+		genHeight := height + 1
+
+		if ctx.Err() != nil {
+			return cancelled(0)
+		}
+		if err := w.failure(); err != nil {
+			return nil, err
+		}
+
+		// Ensure the live ticket pool can sustain voting before attempting
+		// to generate the block, since the node does not produce work for
+		// blocks that would exhaust it.
+		if err := w.checkLiveTickets(ctx, genHeight); err != nil {
+			if ctx.Err() != nil {
+				return cancelled(0)
+			}
+			return nil, err
+		}
+
+		batch := uint32(1)
+		if remaining := nb - uint32(len(stats.Blocks)); w.batchSize > 1 {
+			batch = w.batchSize
+			if batch > remaining {
+				batch = remaining
+			}
+		}
+		hashes, err := miner(ctx, batch)
+		if err == nil && len(hashes) == 0 {
+			err = errors.New("miner did not generate any blocks")
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return cancelled(0)
+			}
+			return nil, fmt.Errorf("unable to generate block at height %d: %v",
+				genHeight, err)
+		}
+
+		for i, h := range hashes {
+			genHeight := height + 1
+			w.log.Debugf("Generated block %s at height %d", h, genHeight)
+
+			// Right after a reorg, the node builds on the parent of its
+			// best block until it receives votes for it, so the generated
+			// block may be a side chain block. The wallet only votes on
+			// those, since tickets are purchased when blocks are connected.
+			// The blocks in the middle of a batch are built upon by the
+			// following ones, so only the final block is checked.
+			isBest := true
+			final := i == len(hashes)-1
+			if final {
+				var bestHash *chainhash.Hash
+				var bestHeight int64
+				err := retryQuery(ctx, "obtain best block", func() error {
+					var err error
+					bestHash, bestHeight, err = w.c.GetBestBlock(ctx)
+					return err
+				})
+				if err != nil {
+					if ctx.Err() != nil {
+						return cancelled(genHeight, hashes[i:]...)
+					}
+					return nil, err
+				}
+				isBest = *bestHash == *h
+				if isBest {
+					genHeight = bestHeight
+				}
+			}
+			height = genHeight
+
+			// Wait for the exact votes and tickets the wallet submits in
+			// response to the block to be accepted to the mempool. The
+			// ones for the blocks in the middle of a batch may already be
+			// mined, so only their submission is confirmed.
+			needsVotes := genHeight >= (w.hn.ActiveNet.StakeValidationHeight - 1)
+			needsTickets := isBest &&
+				genHeight >= ticketPurchaseStartHeight(w.hn.ActiveNet)
+			ready := w.submittedReady
+			if !final {
+				ready = func(context.Context, *chainhash.Hash) (bool, bool) {
+					return w.submittedDone(h, genHeight)
+				}
+			}
+			err := w.waitSubmitted(ctx, h, genHeight, needsVotes,
+				needsTickets, ready)
+			if err != nil {
+				if ctx.Err() != nil {
+					return cancelled(genHeight, hashes[i:]...)
+				}
+				return nil, err
+			}
+			if needsVotes || needsTickets {
+				w.log.Debugf("Votes and tickets for block %s at height "+
+					"%d reached the mempool", h, genHeight)
+			}
+
+			blockStats, nbVotes, nbTickets, err := w.blockStats(ctx, h)
+			if err != nil {
+				if ctx.Err() != nil {
+					return cancelled(genHeight, hashes[i:]...)
+				}
+				return nil, err
+			}
+			stats.Blocks = append(stats.Blocks, *blockStats)
+
+			w.mtx.Lock()
+			verifier := w.blockVerifier
+			w.mtx.Unlock()
+			if verifier != nil {
+				err := verifier(blockStats.Height, nbVotes, nbTickets)
+				if err != nil {
+					return nil, fmt.Errorf("verification of block %s "+
+						"at height %d failed: %w", h,
+						blockStats.Height, err)
+				}
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// GenerateBlocksToHeight generates blocks in the same manner as GenerateBlocks
+// until the best block of the chain is at the given target height. It returns
+// the hashes of the generated blocks, which are none when the chain is already
+// at the target height, or an error when the chain is past it.
+func (w *VotingWallet) GenerateBlocksToHeight(ctx context.Context, target int64) ([]*chainhash.Hash, error) {
+	stats, err := w.GenerateBlocksToHeightWithStats(ctx, target)
+	return stats.hashes(), err
+}
+
+// GenerateBlocksToHeightWithStats generates blocks in the same manner as
+// GenerateBlocksToHeight and returns the stats of every generated block in the
+// same manner as GenerateBlocksWithStats.
+func (w *VotingWallet) GenerateBlocksToHeightWithStats(ctx context.Context, target int64) (*GenerateStats, error) {
+	// The best block is queried from the node, since the height known from
+	// the notifications of the wallet may lag behind blocks generated by
+	// other means, which would overshoot the target.
+	height, err := w.bestHeight(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if height > target {
+		return nil, fmt.Errorf("chain at height %d is already past the "+
+			"target height %d", height, target)
+	}
+	return w.GenerateBlocksWithStats(ctx, uint32(target-height))
+}
+
+// GenerateBlocksUntil generates blocks in the same manner as GenerateBlocks,
+// one at a time, until the given predicate returns true after a block is
+// generated. This allows tests to mine until a condition of the chain holds
+// without computing the exact number of blocks required.
+//
+// It returns the hashes of the generated blocks, along with an error when the
+// predicate or the generation of a block fails, or the context is done.
+func (w *VotingWallet) GenerateBlocksUntil(ctx context.Context, predicate func(ctx context.Context) (bool, error)) ([]*chainhash.Hash, error) {
+	var hashes []*chainhash.Hash
+	for {
+		if err := ctx.Err(); err != nil {
+			return hashes, err
+		}
+		h, err := w.GenerateBlocks(ctx, 1)
+		hashes = append(hashes, h...)
+		if err != nil {
+			return hashes, err
+		}
+
+		done, err := predicate(ctx)
+		if err != nil {
+			return hashes, fmt.Errorf("predicate failed after block "+
+				"%s: %v", h[0], err)
+		}
+		if done {
+			return hashes, nil
+		}
+	}
+}
+
+// GenerateBlocksUntilMined generates blocks in the same manner as
+// GenerateBlocks, one at a time, until the given transaction is mined, which
+// keeps the chain voting while waiting for a transaction such as a tspend to be
+// included. It returns the hashes of the generated blocks, along with an error
+// when the transaction is not mined after the given maximum number of blocks,
+// is unknown to the node, or the generation of a block fails.
+func (w *VotingWallet) GenerateBlocksUntilMined(ctx context.Context, txHash *chainhash.Hash, maxBlocks int) ([]*chainhash.Hash, error) {
+	if maxBlocks <= 0 {
+		return nil, fmt.Errorf("maximum number of blocks %d is not "+
+			"positive", maxBlocks)
+	}
+
+	var hashes []*chainhash.Hash
+	for len(hashes) < maxBlocks {
+		h, err := w.GenerateBlocks(ctx, 1)
+		hashes = append(hashes, h...)
+		if err != nil {
+			return hashes, err
+		}
+
+		res, err := w.c.GetRawTransactionVerbose(ctx, txHash)
+		if err != nil {
+			return hashes, fmt.Errorf("unable to get tx %s: %v", txHash,
+				err)
+		}
+		if res.Confirmations > 0 {
+			return hashes, nil
+		}
+	}
+	return hashes, fmt.Errorf("tx %s was not mined after %d blocks", txHash,
+		maxBlocks)
+}
+
+// WaitForMatureTickets blocks until at least n tickets of the wallet are mature
+// and have neither voted nor been revoked, or the context is done. A ticket is
+// mature once TicketMaturity blocks are mined on top of the block that includes
+// it, according to the current best block of the network.
+//
+// This does not generate any blocks, so it is intended for tests that mine
+// blocks by other means.
+func (w *VotingWallet) WaitForMatureTickets(ctx context.Context, n int) error {
+	const pollInterval = 20 * time.Millisecond
+	ticketMaturity := int64(w.hn.ActiveNet.TicketMaturity)
+	for {
+		_, height, err := w.c.GetBestBlock(ctx)
+		if err != nil {
+			return err
+		}
+		var nbMature int
+		w.mtx.Lock()
+		for ticketHash, ticket := range w.tickets {
+			if ticket.minedHeight == 0 || ticket.revoked ||
+				height < ticket.minedHeight+ticketMaturity {
+				continue
+			}
+			if _, voted := w.votedTickets[ticketHash]; voted {
+				continue
+			}
+			nbMature++
+		}
+		w.mtx.Unlock()
+		if nbMature >= n {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("only %d of %d tickets are mature: %v",
+				nbMature, n, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// WaitForMempoolRemoval generates blocks in the same manner as GenerateBlocks
+// until the given transaction is no longer in the mempool of the node, either
+// because it was mined or because it was dropped, such as when it expires. It
+// returns whether the transaction was mined.
+//
+// An error is returned if the transaction is still in the mempool once the
+// timeout elapses.
+func (w *VotingWallet) WaitForMempoolRemoval(ctx context.Context, txid *chainhash.Hash, timeout time.Duration) (mined bool, err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		mempool, err := w.c.GetRawMempool(ctx, dcrdtypes.GRMAll)
+		if err != nil {
+			return false, fmt.Errorf("unable to get mempool: %v", err)
+		}
+		inMempool := false
+		for _, hash := range mempool {
+			if *hash == *txid {
+				inMempool = true
+				break
+			}
+		}
+		if !inMempool {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return false, fmt.Errorf("timeout waiting for tx %s to be "+
+				"removed from the mempool", txid)
+		}
+		if _, err := w.GenerateBlocks(ctx, 1); err != nil {
+			return false, err
+		}
+	}
+
+	// Transactions that were dropped from the mempool are unknown to the
+	// node.
+	res, err := w.c.GetRawTransactionVerbose(ctx, txid)
+	if err != nil {
+		var rpcErr *dcrjson.RPCError
+		if errors.As(err, &rpcErr) && rpcErr.Code == dcrjson.ErrRPCNoTxInfo {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to get tx %s: %v", txid, err)
+	}
+	return res.Confirmations > 0, nil
+}
+
+// blockStats returns the stats of the given block, identifying the votes and
+// tickets of the wallet included in it, along with the total number of votes
+// and tickets included in it.
+func (w *VotingWallet) blockStats(ctx context.Context, hash *chainhash.Hash) (*BlockStats, int, int, error) {
+	var block *wire.MsgBlock
+	err := retryQuery(ctx, fmt.Sprintf("get block %s", hash), func() error {
+		var err error
+		block, err = w.c.GetBlock(ctx, hash)
+		return err
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	stats := &BlockStats{
+		Hash:            hash,
+		Height:          int64(block.Header.Height),
+		Header:          &block.Header,
+		StakeDifficulty: dcrutil.Amount(block.Header.SBits),
+	}
+	var nbVotes, nbTickets int
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for _, tx := range block.STransactions {
+		switch {
+		case stake.IsSSGen(tx):
+			nbVotes++
+			if _, ok := w.tickets[tx.TxIn[1].PreviousOutPoint.Hash]; ok {
+				txHash := tx.TxHash()
+				stats.Votes = append(stats.Votes, &txHash)
+			}
+		case stake.IsSStx(tx):
+			nbTickets++
+			txHash := tx.TxHash()
+			if _, ok := w.tickets[txHash]; ok {
+				stats.Tickets = append(stats.Tickets, &txHash)
+			}
+		}
+	}
+	return stats, nbVotes, nbTickets, nil
+}
+
+// tipHeader returns the header of the best block of the chain, which is
+// expected to be at the given height. The header of the most recent block
+// connected notification is used when it is at that height, which avoids
+// querying the node before every generated block, and the node is queried
+// otherwise.
+func (w *VotingWallet) tipHeader(ctx context.Context, height int64) (*wire.BlockHeader, error) {
+	w.mtx.Lock()
+	if w.hasConnectedHeader && int64(w.connectedHeader.Height) == height {
+		header := w.connectedHeader
+		w.mtx.Unlock()
+		return &header, nil
+	}
+	w.mtx.Unlock()
+
+	bestHash, _, err := w.c.GetBestBlock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return w.c.GetBlockHeader(ctx, bestHash)
+}
+
+// checkLiveTickets returns an error wrapping ErrInsufficientLiveTickets when
+// the live ticket pool cannot sustain voting once a block at the given height
+// is generated on top of the current best block.
+//
+// This mirrors the ticket exhaustion check done by consensus: the live ticket
+// pool after the ticket maturity period, accounting for the tickets that will
+// mature and the ones that will be consumed by votes, must have enough tickets
+// for a full block of votes. The tickets in the mempool are assumed to be
+// included in the generated block.
+func (w *VotingWallet) checkLiveTickets(ctx context.Context, genHeight int64) error {
+	net := w.hn.ActiveNet
+	svh := net.StakeValidationHeight
+	ticketMaturity := int64(net.TicketMaturity)
+	if genHeight+ticketMaturity+1 < svh {
+		return nil
+	}
+
+	tip, err := w.tipHeader(ctx, genHeight-1)
+	if err != nil {
+		return err
+	}
+
+	// Determine the tickets consumed by votes during the maturity period.
+	votingBlocks := ticketMaturity + 2
+	if tipHeight := int64(tip.Height); tipHeight < svh {
+		votingBlocks -= svh - tipHeight
+	}
+	votesPerBlock := int64(net.TicketsPerBlock)
+	consumed := votingBlocks * votesPerBlock
+
+	// The tickets that mature during the maturity period only add to the
+	// pool, so avoid querying the headers of the period when the current
+	// pool is already large enough on its own.
+	if int64(tip.PoolSize)-consumed >= votesPerBlock {
+		return nil
+	}
+
+	// Note that the pool size in the header is the size before applying the
+	// block, so the tickets that mature during the maturity period are added
+	// starting with the ones purchased in the tip block.
+	finalPoolSize := int64(tip.PoolSize)
+	header := tip
+	for i := int64(0); ; i++ {
+		finalPoolSize += int64(header.FreshStake)
+		if i == ticketMaturity || header.Height == 0 {
+			break
+		}
+		header, err = w.c.GetBlockHeader(ctx, &header.PrevBlock)
+		if err != nil {
+			return err
+		}
+	}
+	mempoolTickets, err := w.c.GetRawMempool(ctx, dcrdtypes.GRMTickets)
+	if err != nil {
+		return err
+	}
+	purchases := int64(len(mempoolTickets))
+	if purchases > int64(net.MaxFreshStakePerBlock) {
+		purchases = int64(net.MaxFreshStakePerBlock)
+	}
+	finalPoolSize += purchases
+
+	finalPoolSize -= consumed
+
+	if finalPoolSize < votesPerBlock {
+		return fmt.Errorf("%w to generate block at height %d: projected "+
+			"live ticket pool size %d is less than %d tickets per block",
+			ErrInsufficientLiveTickets, genHeight, finalPoolSize,
+			votesPerBlock)
+	}
+	return nil
+}
+
+// GenerateBlock generates exactly one block in the same manner as
+// GenerateBlocks and returns its decoded header along with the hashes of the
+// votes and tickets of this wallet that were included in it.
+func (w *VotingWallet) GenerateBlock(ctx context.Context) (*BlockResult, error) {
+	stats, err := w.GenerateBlocksWithStats(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &stats.Blocks[0], nil
+}
+
+// GenerateOneBlock generates a single block in the same manner as
+// GenerateBlock and returns its hash along with the hashes of the votes and
+// tickets of this wallet that were included in it.
+func (w *VotingWallet) GenerateOneBlock(ctx context.Context) (hash *chainhash.Hash, votes, tickets []*chainhash.Hash, err error) {
+	block, err := w.GenerateBlock(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return block.Hash, block.Votes, block.Tickets, nil
+}
+
+func (w *VotingWallet) logError(err error) {
+	w.mtx.Lock()
+	reporter, failFast := w.errorReporter, w.failFast
+	w.mtx.Unlock()
+	w.log.Errorf("Voting wallet error: %v", err)
+	if reporter != nil {
+		reporter(err)
+	}
+	w.emitEvent(WalletEvent{Type: WalletEventError, Err: err})
+	if failFast && !errors.Is(err, ErrTicketPriceRejected) {
+		w.failOnce.Do(func() {
+			w.failErr = err
+			close(w.failed)
+			w.cancelHandler()
+		})
+	}
+}
+
+// cancelHandler cancels the context of the notification handler, if the wallet
+// was started.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) cancelHandler() {
+	w.cancelMtx.Lock()
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.cancelMtx.Unlock()
+}
+
+// isRetryableSendError returns whether sending a transaction that failed with
+// the given error may succeed when sent again. The network nodes report every
+// transaction rejected by the mempool rules with the generic rule error code,
+// so only the rejections whose reason is an input not yet known to the mempool
+// are retried, while any other rejection is final. Errors that are not
+// reported by the node, such as a dropped connection, are retried unless the
+// client was shut down.
+func isRetryableSendError(err error) bool {
+	var rpcErr *dcrjson.RPCError
+	if errors.As(err, &rpcErr) {
+		if rpcErr.Code != dcrjson.ErrRPCMisc {
+			return false
+		}
+		for _, reason := range transientRejectReasons {
+			if strings.Contains(rpcErr.Message, reason) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return !errors.Is(err, rpcclient.ErrClientShutdown) &&
+		!errors.Is(err, rpcclient.ErrClientNotConnected) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
+
+// receiveSentTx waits for the result of sending the given transaction with the
+// given promise, resending the transaction with an exponential backoff while
+// sending fails with a transient error. A transaction that is already known to
+// the network is considered sent.
+func (w *VotingWallet) receiveSentTx(ctx context.Context, tx *wire.MsgTx,
+	promise *rpcclient.FutureSendRawTransactionResult) (*chainhash.Hash, error) {
+
+	delay := sendRetryDelay
+	for attempt := 1; ; attempt++ {
+		h, err := promise.Receive()
+		if err == nil {
+			return h, nil
+		}
+		var rpcErr *dcrjson.RPCError
+		if errors.As(err, &rpcErr) && rpcErr.Code == dcrjson.ErrRPCDuplicateTx {
+			txHash := tx.TxHash()
+			return &txHash, nil
+		}
+		if attempt >= sendRetryAttempts || !isRetryableSendError(err) {
+			atomic.AddUint64(&w.broadcastErrors, 1)
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			atomic.AddUint64(&w.broadcastErrors, 1)
+			return nil, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+		promise = w.c.SendRawTransactionAsync(ctx, tx, true)
+	}
+}
+
+// retryQuery performs the given query until it succeeds, up to
+// queryRetryAttempts times, so that a transient failure of the connection to
+// the node does not abort block generation. The returned error describes what
+// the query does, the number of attempts and the last failure. Retrying stops
+// once the context is done.
+func retryQuery(ctx context.Context, what string, query func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := query()
+		if err == nil {
+			return nil
+		}
+		if attempt >= queryRetryAttempts || ctx.Err() != nil {
+			return fmt.Errorf("unable to %s after %d attempts: %v", what,
+				attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("unable to %s after %d attempts: %v", what,
+				attempt, err)
+		case <-time.After(time.Duration(attempt) * queryRetryDelay):
+		}
+	}
+}
+
+// sendTx sends the given transaction to the network in the same manner as
+// receiveSentTx.
+func (w *VotingWallet) sendTx(ctx context.Context, tx *wire.MsgTx) (*chainhash.Hash, error) {
+	return w.receiveSentTx(ctx, tx, w.c.SendRawTransactionAsync(ctx, tx, true))
+}
+
+func (w *VotingWallet) onBlockConnected(blockHeader []byte, transactions [][]byte) {
+	w.checkBacklog("block connected", len(w.blockConnectedNtfnChan),
+		&w.blockConnectedBacklogged)
+	select {
+	case w.blockConnectedNtfnChan <- blockConnectedNtfn{
+		blockHeader:  blockHeader,
+		transactions: transactions,
+	}:
+	case <-w.quit:
+	}
+}
+
+func (w *VotingWallet) onBlockDisconnected(blockHeader []byte) {
+	select {
+	case w.blockDisconnectedNtfnChan <- blockDisconnectedNtfn{
+		blockHeader: blockHeader,
+	}:
+	case <-w.quit:
+	}
+}
+
+// NotificationBacklog returns the number of block connected and winning tickets
+// notifications received from the node that are queued for the wallet to
+// handle. A backlog that approaches the notification buffer length, set by
+// WithNotificationBufferLen, indicates blocks are generated faster than the
+// wallet handles them, which eventually blocks the rpc client and causes block
+// generation to time out.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) NotificationBacklog() (blockConnected, winningTickets int) {
+	return len(w.blockConnectedNtfnChan), len(w.winningTicketsNtfnChan)
+}
+
+// SetNotificationBacklogWarning sets the number of queued notifications of a
+// kind, as reported by NotificationBacklog, at which the wallet reports an
+// ErrNotificationBacklog error. The error is reported once each time a backlog
+// reaches the high-water mark, which allows detecting backpressure before it
+// causes block generation to time out. It is reported by the notification
+// handler, so only after it finishes handling its current notification. A
+// high-water mark that is not positive disables the reports, which is the
+// default.
+//
+// Note that the reports stop a wallet in fail-fast mode like any other error.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SetNotificationBacklogWarning(highWater int) {
+	if highWater < 0 {
+		highWater = 0
+	}
+	atomic.StoreInt64(&w.backlogHighWater, int64(highWater))
+}
+
+// checkBacklog queues an error for the notification handler to report when the
+// given backlog of the notifications of the given kind reaches the high-water
+// mark, unless it was already reported as indicated by backlogged and has not
+// receded below the mark since.
+//
+// This function MUST be called from the goroutine of the rpc client.
+func (w *VotingWallet) checkBacklog(kind string, backlog int, backlogged *bool) {
+	highWater := int(atomic.LoadInt64(&w.backlogHighWater))
+	if highWater == 0 || backlog < highWater {
+		*backlogged = false
+		return
+	}
+	if *backlogged {
+		return
+	}
+	*backlogged = true
+	err := walletError(ErrNotificationBacklog, fmt.Errorf("%d %s "+
+		"notifications are queued, reaching the high-water mark of %d "+
+		"(buffer length %d)", backlog, kind, highWater,
+		w.notificationBufferLen))
+	select {
+	case w.backlogReports <- err:
+	default:
+	}
+}
+
+// newTxOut returns a new transaction output with the given parameters.
+func newTxOut(amount int64, pkScriptVer uint16, pkScript []byte) *wire.TxOut {
+	return &wire.TxOut{
+		Value:    amount,
+		Version:  pkScriptVer,
+		PkScript: pkScript,
+	}
+}
+
+func (w *VotingWallet) handleBlockConnectedNtfn(ctx context.Context, ntfn *blockConnectedNtfn) {
+	var header wire.BlockHeader
+	err := header.FromBytes(ntfn.blockHeader)
+	if err != nil {
+		w.logError(walletError(ErrNotificationDecodeFailed, err))
+		return
+	}
+
+	blockHeight := int64(header.Height)
+	w.log.Debugf("Block %s connected at height %d", header.BlockHash(),
+		blockHeight)
+	w.mtx.Lock()
+	w.stakeActivity.Height = blockHeight
+	w.connectedHeight = blockHeight
+	w.hasConnected = true
+	w.stakeActivity.VotesSeen += int64(header.Voters)
+	w.stakeActivity.TicketsSeen += int64(header.FreshStake)
+	w.stakeActivity.RevocationsSeen += int64(header.Revocations)
+	w.stakeActivity.LiveTicketPoolSize = header.PoolSize
+	w.connectedHeader = header
+	w.hasConnectedHeader = true
+	w.mtx.Unlock()
+
+	if w.observer {
+		return
+	}
+
+	// Mark all maturing outputs (if any) as available for spending once
+	// the block is handled, including when no tickets are purchased.
+	defer w.releaseMaturedOutputs(blockHeight)
+
+	// Confirm the votes of the wallet included in the block, track the
+	// expiry of its tickets and the revocations of the ones that missed
+	// their vote or expired, then check for tickets that missed their vote.
+	var nbVotes int
+	for _, txBytes := range ntfn.transactions {
+		var tx wire.MsgTx
+		if err := tx.FromBytes(txBytes); err != nil {
+			w.logError(walletError(ErrNotificationDecodeFailed,
+				fmt.Errorf("unable to decode block tx: %v", err)))
+			return
+		}
+		switch {
+		case stake.IsSSGen(&tx):
+			ticketHash := &tx.TxIn[1].PreviousOutPoint.Hash
+			w.mtx.Lock()
+			if _, ok := w.tickets[*ticketHash]; ok {
+				nbVotes++
+			}
+			w.mtx.Unlock()
+			w.confirmVote(ticketHash)
+		case stake.IsSStx(&tx):
+			w.trackTicketExpiry(&tx, blockHeight)
+		case stake.IsSSRtx(&tx):
+			w.confirmRevocation(&tx, blockHeight)
+		case stake.IsTAdd(&tx):
+			w.confirmTAdd(&tx, blockHeight)
+		default:
+			w.confirmRefund(&tx)
+		}
+	}
+	if blockHeight >= w.hn.ActiveNet.StakeValidationHeight {
+		w.mtx.Lock()
+		w.participation[blockHeight] = blockParticipation{
+			votes: nbVotes,
+			limit: w.limitNbVotes,
+		}
+		w.mtx.Unlock()
+		if nbVotes > 0 {
+			w.readyOnce.Do(func() { close(w.ready) })
+		}
+	}
+	w.detectMissedTickets(blockHeight)
+	w.revokeTickets(ctx, blockHeight, ntfn.blockHeader)
+
+	purchaseHeight := ticketPurchaseStartHeight(w.hn.ActiveNet)
+	if blockHeight < purchaseHeight {
+		// No need to purchase tickets yet.
+		return
+	}
+
+	// Top up the wallet when it runs low on outputs.
+	w.refund(ctx)
+
+	// Purchase the configured number of tickets, or as many as the funds
+	// of the wallet allow when the ticket pool is shared.
+	w.mtx.Lock()
+	nbTickets := w.purchasedTicketsPerBlock()
+	shared := w.sharedTicketPool
+	w.mtx.Unlock()
+	ticketPrice, nextStakeDiff, err := w.ticketPrice(ctx, &header)
+	if err != nil {
+		w.logError(walletError(ErrQueryFailed, err))
+		return
+	}
+	minAmount := w.ticketCommitAmount(ticketPrice)
+
+	// Select the most recent utxos that are able to fund a ticket and mark
+	// them used. Smaller ones, such as the outputs of revocations which
+	// only return the committed amount, are kept for when the price drops.
+	// The utxos are selected from the greatest outpoints instead when they
+	// are sorted. Selected utxos that were spent by another party, such as
+	// one sharing the address of the wallet, are dropped and replaced.
+	var utxos []utxoInfo
+	for {
+		w.mtx.Lock()
+		if w.sortUtxos {
+			sortUtxosByOutpoint(w.utxos)
+		}
+		selected := make([]int, 0, nbTickets)
+		for i := len(w.utxos) - 1; i >= 0 && len(selected) < nbTickets; i-- {
+			if w.utxos[i].amount >= minAmount {
+				selected = append(selected, i)
+			}
+		}
+		if shared {
+			nbTickets = len(selected)
+		}
+		if nbUtxos := len(selected); nbUtxos < nbTickets {
+			w.mtx.Unlock()
+			balance, _ := w.SpendableBalance()
+			w.logError(walletError(ErrFundingFailed, fmt.Errorf("number of "+
+				"available utxos (%d, totaling %v) less than number of "+
+				"tickets to purchase (%d)", nbUtxos, balance, nbTickets)))
+			return
+		}
+		utxos = make([]utxoInfo, nbTickets)
+		for i, idx := range selected {
+			utxos[i] = w.utxos[idx]
+			w.utxos = append(w.utxos[:idx], w.utxos[idx+1:]...)
+		}
+		w.mtx.Unlock()
+
+		unspent, err := w.unspentUtxos(ctx, utxos)
+		if err != nil {
+			w.mtx.Lock()
+			w.utxos = append(w.utxos, utxos...)
+			w.mtx.Unlock()
+			w.logError(walletError(ErrQueryFailed, err))
+			return
+		}
+		if len(unspent) == len(utxos) {
+			break
+		}
+		w.mtx.Lock()
+		w.utxos = append(w.utxos, unspent...)
+		w.mtx.Unlock()
+	}
+
+	tickets, err := w.newTickets(utxos, ticketPrice)
+	if err != nil {
+		w.logError(err)
+		return
+	}
+
+	// In dry-run mode, the utxos remain available since the tickets are
+	// not published.
+	if w.dryRunTxs(tickets) {
+		w.mtx.Lock()
+		w.utxos = append(w.utxos, utxos...)
+		w.mtx.Unlock()
+	} else {
+		blockHash := header.BlockHash()
+		w.publishTickets(ctx, &blockHash, blockHeight, ticketPrice,
+			nextStakeDiff, tickets, utxos)
+	}
+}
+
+// releaseMaturedOutputs makes the maturing outputs of the wallet that mature at
+// or before the given height available for purchasing new tickets, in order of
+// their maturing height. Outputs maturing at earlier heights remain only when
+// the block at their maturing height was not handled, such as when it was
+// skipped by a reorg.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) releaseMaturedOutputs(height int64) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	heights := make([]int64, 0, len(w.maturingVotes))
+	for maturingHeight := range w.maturingVotes {
+		if maturingHeight <= height {
+			heights = append(heights, maturingHeight)
+		}
+	}
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] < heights[j]
+	})
+	for _, maturingHeight := range heights {
+		w.utxos = append(w.utxos, w.maturingVotes[maturingHeight]...)
+		delete(w.maturingVotes, maturingHeight)
+	}
+}
+
+// addMaturingOutputs adds the given outputs to the ones maturing at the given
+// height. Winning tickets notifications are not ordered with respect to block
+// connected notifications, so the block at the maturing height may already be
+// handled, in which case the outputs are made available right away instead.
+//
+// This function MUST be called with the wallet mutex held.
+func (w *VotingWallet) addMaturingOutputs(height int64, utxos ...utxoInfo) {
+	if w.hasConnected && height <= w.connectedHeight {
+		w.utxos = append(w.utxos, utxos...)
+		return
+	}
+	w.maturingVotes[height] = append(w.maturingVotes[height], utxos...)
+}
+
+// unspentUtxos returns the given utxos that are still unspent according to the
+// node, including the transactions in its mempool. The spent ones are logged
+// and must no longer be considered available by the caller.
+func (w *VotingWallet) unspentUtxos(ctx context.Context, utxos []utxoInfo) ([]utxoInfo, error) {
+	promises := make([]*rpcclient.FutureGetTxOutResult, len(utxos))
+	for i := range utxos {
+		outpoint := &utxos[i].outpoint
+		promises[i] = w.c.GetTxOutAsync(ctx, &outpoint.Hash, outpoint.Index,
+			outpoint.Tree, true)
+	}
+
+	unspent := make([]utxoInfo, 0, len(utxos))
+	for i := range utxos {
+		txOut, err := promises[i].Receive()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get output %v: %v",
+				utxos[i].outpoint, err)
+		}
+		if txOut == nil {
+			w.log.Warnf("Dropping output %v that was spent outside of "+
+				"the wallet", utxos[i].outpoint)
+			continue
+		}
+		unspent = append(unspent, utxos[i])
+	}
+	return unspent, nil
+}
+
+// publishTickets publishes the given tickets purchased with the given price,
+// based on the given stake difficulty of the next block, in response to the
+// given block, which are funded by the given utxos in order.
+//
+// The tickets are tracked before being submitted so that they are accounted for
+// as soon as they may be seen in the mempool.
+func (w *VotingWallet) publishTickets(ctx context.Context, blockHash *chainhash.Hash,
+	blockHeight, ticketPrice, nextStakeDiff int64, tickets []*wire.MsgTx, utxos []utxoInfo) {
+
+	w.mtx.Lock()
+	sub := w.submittedFor(blockHash, blockHeight)
+	ticketPurchased := w.ticketPurchased
+	w.mtx.Unlock()
+	promises := make([]*rpcclient.FutureSendRawTransactionResult, len(tickets))
+	for i := range tickets {
+		ticketHash := tickets[i].TxHash()
+		w.addTicket(&ticketHash, ticketPrice, &utxos[i])
+		promises[i] = w.c.SendRawTransactionAsync(ctx, tickets[i], true)
+	}
+
+	for i := range tickets {
+		h, err := w.receiveSentTx(ctx, tickets[i], promises[i])
+		if err != nil {
+			ticketHash := tickets[i].TxHash()
+			w.removeTicket(&ticketHash)
+			w.logError(ticketSendError(ticketPrice, nextStakeDiff, err))
+			continue
+		}
+		w.mtx.Lock()
+		sub.tickets = append(sub.tickets, *h)
+		w.mtx.Unlock()
+		atomic.AddUint64(&w.ticketsPurchased, 1)
+
+		w.trackBurnedChange(tickets[i])
+		w.reportTicketFee(h, tickets[i], utxos[i].amount)
+		if ticketPurchased != nil {
+			ticketPurchased(h, ticketPrice, blockHeight)
+		}
+		w.emitEvent(WalletEvent{
+			Type:   WalletEventTicketPurchased,
+			Hash:   h,
+			Height: blockHeight,
+			Price:  ticketPrice,
+		})
+	}
+	w.mtx.Lock()
+	sub.ticketsDone = true
+	nbPublished := len(sub.tickets)
+	w.mtx.Unlock()
+	w.log.Infof("Purchased %d tickets at price %v on block %s at height %d",
+		nbPublished, dcrutil.Amount(ticketPrice), blockHash, blockHeight)
+}
+
+// ticketSendError returns the error reported when sending a ticket with the
+// given price failed with the given error, which is of kind
+// ErrTicketPriceRejected when the price is below the given stake difficulty of
+// the next block the price was based on.
+func ticketSendError(ticketPrice, nextStakeDiff int64, err error) error {
+	if ticketPrice < nextStakeDiff {
+		return walletError(ErrTicketPriceRejected, fmt.Errorf("ticket "+
+			"price %v is below the next stake difficulty %v: %v",
+			dcrutil.Amount(ticketPrice), dcrutil.Amount(nextStakeDiff),
+			err))
+	}
+	return walletError(ErrBroadcastFailed,
+		fmt.Errorf("unable to send ticket tx: %v", err))
+}
+
+// nextStakeDifficulty returns the stake difficulty of the block following the
+// block with the given header. The stake difficulty only changes when a new
+// stake difficulty window starts, so it is the SBits of the header unless the
+// next block starts a new window, in which case the network is queried for the
+// stake difficulty of the block following its current best block.
+func (w *VotingWallet) nextStakeDifficulty(ctx context.Context, header *wire.BlockHeader) (int64, error) {
+	net := w.hn.ActiveNet
+	nextHeight := int64(header.Height) + 1
+	if nextHeight < int64(net.CoinbaseMaturity)+1 {
+		return net.MinimumStakeDiff, nil
+	}
+	if nextHeight%net.StakeDiffWindowSize != 0 {
+		return header.SBits, nil
+	}
+
+	res, err := w.c.GetStakeDifficulty(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get stake difficulty: %v", err)
+	}
+	sbits, err := dcrutil.NewAmount(res.NextStakeDifficulty)
+	if err != nil {
+		return 0, fmt.Errorf("invalid next stake difficulty: %v", err)
+	}
+	return int64(sbits), nil
+}
+
+// TicketPriceStrategy returns the price of the tickets purchased by the voting
+// wallet in response to the block with the given header, given the stake
+// difficulty of the next block, which is the minimum price accepted by the
+// network for the tickets included in it. It differs from the SBits of the
+// header when the next block starts a new stake difficulty window.
+type TicketPriceStrategy func(header *wire.BlockHeader, nextStakeDiff int64) int64
+
+// TicketPriceExact is a TicketPriceStrategy that purchases tickets at exactly
+// the stake difficulty of the next block, as reported by the network, rather
+// than the SBits of the header.
+//
+// The stake difficulty of the next block is known exactly from the current best
+// block, including when it starts a new stake difficulty window, so any buffer
+// would only overpay for the tickets. Tickets that are not included in the
+// next block remain valid until the next window starts, after which they are
+// rejected if the difficulty increased.
+func TicketPriceExact(header *wire.BlockHeader, nextStakeDiff int64) int64 {
+	return nextStakeDiff
+}
+
+// TicketPricePadded is the default TicketPriceStrategy, which purchases tickets
+// at the stake difficulty of the next block padded by a sixth, such that the
+// tickets remain valid when they are included after an increase of the
+// difficulty of up to that amount.
+func TicketPricePadded(header *wire.BlockHeader, nextStakeDiff int64) int64 {
+	return nextStakeDiff + nextStakeDiff/6
+}
+
+// ticketPrice returns the price used to purchase tickets that are expected to
+// be included in the block after the one with the given header, or the current
+// best block when it is nil, according to the ticket price strategy of the
+// wallet, along with the stake difficulty of the next block the price is based
+// on.
+func (w *VotingWallet) ticketPrice(ctx context.Context, header *wire.BlockHeader) (price, nextStakeDiff int64, err error) {
+	w.mtx.Lock()
+	strategy := w.ticketPriceStrategy
+	w.mtx.Unlock()
+	if strategy == nil {
+		strategy = TicketPricePadded
+	}
+	if header == nil {
+		bestHash, _, err := w.c.GetBestBlock(ctx)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unable to get best block: %v", err)
+		}
+		header, err = w.c.GetBlockHeader(ctx, bestHash)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unable to get best block header: %v",
+				err)
+		}
+	}
+	nextStakeDiff, err = w.nextStakeDifficulty(ctx, header)
+	if err != nil {
+		return 0, 0, err
+	}
+	return strategy(header, nextStakeDiff), nextStakeDiff, nil
+}
+
+// newTicket creates a signed ticket purchase transaction with the given price
+// that spends the provided utxo.
+func (w *VotingWallet) newTicket(utxo *utxoInfo, ticketPrice int64) (*wire.MsgTx, error) {
+	t := w.ticketTemplate(&utxo.outpoint, ticketPrice)
+
+	// The commitment amount includes the fee and everything else is sent as
+	// change.
+	commitAmount := w.ticketCommitAmount(ticketPrice)
+	changeAmount := utxo.amount - commitAmount
+	if changeAmount < 0 {
+		return nil, walletError(ErrFundingFailed, fmt.Errorf("utxo "+
+			"amount %d is not enough to purchase ticket with price %d",
+			utxo.amount, ticketPrice))
+	}
+	w.mtx.Lock()
+	voteFeeLimit, revokeFeeLimit := w.voteFeeLimit, w.revokeFeeLimit
+	w.mtx.Unlock()
+	_, t.TxOut[1].PkScript = w.address.RewardCommitmentScript(commitAmount,
+		voteFeeLimit, revokeFeeLimit)
+	t.TxOut[2].Value = changeAmount
+
+	sig, err := w.signer.Sign(t, 0, w.utxoScript(utxo))
+	if err != nil {
+		return nil, walletError(ErrSignFailed,
+			fmt.Errorf("failed to sign ticket tx: %v", err))
+	}
+	t.TxIn[0].SignatureScript = sig
+
+	return t, nil
+}
+
+// newTickets returns tickets that purchase a ticket with the given price each,
+// funded by the given utxos in order. The tickets are validated once signed, so
+// that malformed tickets are reported along with their index instead of being
+// rejected by the network.
+func (w *VotingWallet) newTickets(utxos []utxoInfo, ticketPrice int64) ([]*wire.MsgTx, error) {
+	tickets := make([]*wire.MsgTx, len(utxos))
+	for i := range utxos {
+		var err error
+		tickets[i], err = w.newTicket(&utxos[i], ticketPrice)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := checkTickets(tickets); err != nil {
+		return nil, err
+	}
+	return tickets, nil
+}
+
+// checkTickets ensures the given tickets are valid tickets.
+func checkTickets(tickets []*wire.MsgTx) error {
+	for i, ticket := range tickets {
+		if err := stake.CheckSStx(ticket); err != nil {
+			return walletError(ErrTxCreationFailed, fmt.Errorf("ticket "+
+				"%d (%s) is not a valid ticket: %v", i, ticket.TxHash(),
+				err))
+		}
+	}
+	return nil
+}
+
+// utxoScript returns the script paid to by the given utxo of the wallet.
+// Matured outputs in the stake tree pay to the vote return, revocation return
+// or stake change scripts instead of the regular p2pkh one.
+func (w *VotingWallet) utxoScript(utxo *utxoInfo) []byte {
+	switch {
+	case utxo.outpoint.Tree != wire.TxTreeStake:
+		return w.p2pkh
+	case utxo.revocation:
+		return w.revokeRetScript
+	case utxo.taddChange, utxo.ticketChange:
+		return w.stakeChange
+	}
+	return w.voteRetScript
+}
+
+// ticketTemplate returns a ticket that spends the given outpoint to purchase a
+// ticket with the given price, without any commitment or change amounts.
+func (w *VotingWallet) ticketTemplate(outpoint *wire.OutPoint, ticketPrice int64) *wire.MsgTx {
+	var changeScriptVer uint16
+	changeScript := w.changeScript
+	w.mtx.Lock()
+	commitScriptVer, commitScript := w.address.RewardCommitmentScript(0,
+		w.voteFeeLimit, w.revokeFeeLimit)
+	if w.recycleChange {
+		changeScriptVer, changeScript = w.stakeChangeVer, w.stakeChange
+	}
+	w.mtx.Unlock()
+	t := wire.NewMsgTx()
+	t.AddTxIn(wire.NewTxIn(outpoint, wire.NullValueIn, nil))
+	t.AddTxOut(newTxOut(ticketPrice, w.p2sstxVer, w.p2sstx))
+	t.AddTxOut(newTxOut(0, commitScriptVer, commitScript))
+	t.AddTxOut(newTxOut(0, changeScriptVer, changeScript))
+	return t
+}
+
+// ticketCommitAmount returns the amount committed by a ticket with the given
+// price, which includes the fee paid by the ticket. This is the minimum amount
+// of the utxo that funds the ticket.
+func (w *VotingWallet) ticketCommitAmount(ticketPrice int64) int64 {
+	// The commitment script has a fixed size regardless of the committed
+	// amount, so the final size of the ticket is known once the signature
+	// script is accounted for.
+	t := w.ticketTemplate(&wire.OutPoint{}, ticketPrice)
+	size := t.SerializeSize() + p2pkhSigScriptSize
+	fee := int64(w.txFeeRate()) * int64(size) / 1000
+	return ticketPrice + fee
+}
+
+// addTicket records a ticket of the wallet that is about to be published.
+func (w *VotingWallet) addTicket(hash *chainhash.Hash, ticketPrice int64, utxo *utxoInfo) {
+	w.mtx.Lock()
+	w.tickets[*hash] = ticketInfo{
+		ticketPrice: ticketPrice,
+		utxo:        *utxo,
+	}
+	w.mtx.Unlock()
+}
+
+// removeTicket stops tracking the given ticket, such as when it was rejected
+// by the network.
+func (w *VotingWallet) removeTicket(hash *chainhash.Hash) {
+	w.mtx.Lock()
+	delete(w.tickets, *hash)
+	w.mtx.Unlock()
+}
+
+// reportTicketFee reports the fee paid by the given ticket to the fee
+// reporter, if any.
+func (w *VotingWallet) reportTicketFee(hash *chainhash.Hash, ticket *wire.MsgTx, inputAmount int64) {
+	w.mtx.Lock()
+	reporter := w.feeReporter
+	w.mtx.Unlock()
+	if reporter != nil {
+		reporter(newTxFeeInfo(hash, ticket, stake.TxTypeSStx, inputAmount))
+	}
+}
+
+// trackBurnedChange accounts for the change of the given published ticket when
+// it is sent to the null change script, and is therefore unspendable.
+func (w *VotingWallet) trackBurnedChange(ticket *wire.MsgTx) {
+	change := ticket.TxOut[2]
+	if !bytes.Equal(change.PkScript, nullPay2SSTXChange) {
+		return
+	}
+	w.mtx.Lock()
+	w.burnedChange += dcrutil.Amount(change.Value)
+	w.mtx.Unlock()
+}
+
+// TotalBurnedChange returns the cumulative amount of change of the tickets
+// published by the wallet that was sent to the null change script, which is
+// unspendable. This is the amount wasted by the wallet, unless it is created
+// with a custom change script by WithDefaultChangeScript.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) TotalBurnedChange() dcrutil.Amount {
+	w.mtx.Lock()
+	burned := w.burnedChange
+	w.mtx.Unlock()
+	return burned
+}
+
+// TotalSubsidyEarned returns the cumulative stakebase subsidy of the votes
+// published by the wallet. The subsidy of a vote is accounted for once the vote
+// is accepted by the network, so votes that are later dropped due to a reorg
+// remain accounted for.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) TotalSubsidyEarned() dcrutil.Amount {
+	return dcrutil.Amount(atomic.LoadInt64(&w.subsidyEarned))
+}
+
+// WalletMetrics is a snapshot of the counters of the activity of a voting
+// wallet, returned by Metrics. The counters never decrease, so they may be
+// exported as counters of a metrics registry.
+type WalletMetrics struct {
+	// TicketsPurchased, VotesCast and RevocationsPublished are the number
+	// of tickets, votes and revocations published by the wallet.
+	TicketsPurchased     uint64
+	VotesCast            uint64
+	RevocationsPublished uint64
+
+	// BroadcastErrors is the number of transactions of the wallet that were
+	// rejected by the network.
+	BroadcastErrors uint64
+
+	// SubsidyEarned is the cumulative stakebase subsidy of the votes
+	// published by the wallet, as reported by TotalSubsidyEarned.
+	SubsidyEarned dcrutil.Amount
+}
+
+// Metrics returns a snapshot of the counters of the activity of the wallet.
+// This allows long running tests to track the behavior of the wallet over
+// many blocks without parsing its logs.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) Metrics() WalletMetrics {
+	return WalletMetrics{
+		TicketsPurchased:     atomic.LoadUint64(&w.ticketsPurchased),
+		VotesCast:            atomic.LoadUint64(&w.votesCast),
+		RevocationsPublished: atomic.LoadUint64(&w.revocationsPublished),
+		BroadcastErrors:      atomic.LoadUint64(&w.broadcastErrors),
+		SubsidyEarned:        w.TotalSubsidyEarned(),
+	}
+}
+
+// Snapshot returns a checkpoint of the available utxos, the tickets and the
+// maturing outputs of the wallet, which may later be restored with Restore.
+// This allows tests that manipulate the chain out of band to roll back the
+// state of the wallet, independently of the reorg handling of the wallet.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) Snapshot() WalletState {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	state := WalletState{
+		utxos:         w.utxos,
+		tickets:       w.tickets,
+		maturingVotes: w.maturingVotes,
+	}
+	return state.copy()
+}
+
+// Restore replaces the available utxos, the tickets and the maturing outputs of
+// the wallet with the ones of the given checkpoint created by Snapshot. The
+// rest of the state of the wallet, such as its votes and submitted
+// transactions, is unaffected, so it is up to the caller to ensure the restored
+// state is consistent with the chain before generating further blocks.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) Restore(state WalletState) {
+	restored := state.copy()
+	w.mtx.Lock()
+	w.utxos = restored.utxos
+	w.tickets = restored.tickets
+	w.maturingVotes = restored.maturingVotes
+	w.mtx.Unlock()
+}
+
+// LiveTicketCount returns the number of outstanding tickets of the wallet. The
+// count includes the tickets that were submitted to the network but are not yet
+// mined.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) LiveTicketCount() int {
+	w.mtx.Lock()
+	n := len(w.tickets)
+	w.mtx.Unlock()
+	return n
+}
+
+// SpendableBalance returns the total value and the number of the outputs of the
+// wallet that are available for purchasing tickets. Outputs of votes are only
+// accounted for once they mature.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) SpendableBalance() (dcrutil.Amount, int) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	var total int64
+	for i := range w.utxos {
+		total += w.utxos[i].amount
+	}
+	return dcrutil.Amount(total), len(w.utxos)
+}
+
+// MaturingVotesAt returns the number of maturing outputs of the wallet that
+// become available for purchasing tickets once the block at the given height
+// is connected. Besides the outputs of votes, this includes the outputs of
+// revocations, the change of treasury adds and the recycled change of tickets.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) MaturingVotesAt(height int64) int {
+	w.mtx.Lock()
+	n := len(w.maturingVotes[height])
+	w.mtx.Unlock()
+	return n
+}
+
+// MaturingVoteSchedule returns the number of maturing outputs of the wallet,
+// as reported by MaturingVotesAt, keyed by the height of the block at which
+// they become available for purchasing tickets. Heights without maturing
+// outputs are omitted. The returned map is a copy, so it may be freely modified
+// by the caller.
+//
+// This allows verifying the funding pipeline of the wallet, such as whether
+// enough outputs mature before the next purchase to keep funding tickets.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) MaturingVoteSchedule() map[int64]int {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	schedule := make(map[int64]int, len(w.maturingVotes))
+	for height, utxos := range w.maturingVotes {
+		if len(utxos) > 0 {
+			schedule[height] = len(utxos)
+		}
+	}
+	return schedule
+}
+
+// MaxSustainableHeight returns the height of the last block for which the
+// wallet is able to purchase a full block of tickets given the outputs that are
+// currently available and the outputs of the votes that are already maturing.
+// The outputs of revocations are not counted since they are not enough to
+// purchase a ticket.
+//
+// The outputs of votes cast after the call replenish the wallet, so this is a
+// lower bound that is only reached when the wallet stops voting, but it allows
+// tests to determine a safe operating range. It assumes the wallet has already
+// processed the current best block.
+func (w *VotingWallet) MaxSustainableHeight(ctx context.Context) (int64, error) {
+	_, tipHeight, err := w.c.GetBestBlock(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("unable to obtain best block: %v", err)
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.sustainableHeight(tipHeight, w.ticketsPerBlock), nil
+}
+
+// sustainableHeight returns the height of the last block for which the wallet
+// is able to purchase the given number of tickets per block, starting after
+// the given tip height, as described by MaxSustainableHeight.
+//
+// This must be called with the mtx held.
+func (w *VotingWallet) sustainableHeight(tipHeight int64, nbTickets int) int64 {
+	if nbTickets == 0 {
+		return math.MaxInt64
+	}
+
+	// Tickets are purchased as each block is connected, and the votes
+	// maturing at that block only become available afterwards.
+	nbUtxos := countTicketFunding(w.utxos)
+	purchaseHeight := ticketPurchaseStartHeight(w.hn.ActiveNet)
+	for height := tipHeight + 1; ; height++ {
+		if height >= purchaseHeight {
+			if nbUtxos < nbTickets {
+				return height - 1
+			}
+			nbUtxos -= nbTickets
+		}
+		nbUtxos += countTicketFunding(w.maturingVotes[height])
+	}
+}
+
+// countTicketFunding returns the number of the given outputs that may fund a
+// ticket, which excludes the outputs of revocations since they only return the
+// committed amount.
+func countTicketFunding(utxos []utxoInfo) int {
+	var n int
+	for i := range utxos {
+		if !utxos[i].revocation {
+			n++
+		}
+	}
+	return n
+}
+
+// fundingShortfall returns the number of additional outputs the wallet needs to
+// purchase the given number of tickets per block on every block after the
+// given tip height through the given height, accounting for the outputs that
+// are available, pending refunds and the outputs of the votes that are already
+// maturing.
+//
+// This must be called with the mtx held.
+func (w *VotingWallet) fundingShortfall(tipHeight int64, nbTickets int, throughHeight int64) int {
+	nbUtxos := countTicketFunding(w.utxos)
+	for _, utxos := range w.pendingRefunds {
+		nbUtxos += len(utxos)
+	}
+	var shortfall int
+	purchaseHeight := ticketPurchaseStartHeight(w.hn.ActiveNet)
+	for height := tipHeight + 1; height <= throughHeight; height++ {
+		if height >= purchaseHeight {
+			nbUtxos -= nbTickets
+			if nbUtxos < 0 {
+				shortfall -= nbUtxos
+				nbUtxos = 0
+			}
+		}
+		nbUtxos += countTicketFunding(w.maturingVotes[height])
+	}
+	return shortfall
+}
+
+// BuyTicketFromStakeOutput purchases a single ticket at the current stake
+// difficulty that is specifically funded by a matured vote output (that is,
+// an output in the stake tree), returning the hash of the new ticket.
+//
+// This is useful to exercise the signing path of stake tree outputs in
+// isolation. It errors if the wallet does not have any such outputs available,
+// or when spending one would leave the wallet without the outputs required to
+// purchase the tickets of the next block, so that the regular ticket purchases
+// are never starved. Wallets that purchase tickets from stake outputs should
+// therefore be funded with additional outputs via SetInitialFundingOutputs.
+func (w *VotingWallet) BuyTicketFromStakeOutput(ctx context.Context) (*chainhash.Hash, error) {
+	ticketPrice, _, err := w.ticketPrice(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	minAmount := w.ticketCommitAmount(ticketPrice)
+
+	// Select the most recent stake output able to fund the ticket and mark
+	// it used, as long as the remaining outputs able to fund a ticket cover
+	// the purchases of the next block.
+	w.mtx.Lock()
+	idx, nbFunding := -1, 0
+	for i := len(w.utxos) - 1; i >= 0; i-- {
+		if w.utxos[i].amount < minAmount {
+			continue
+		}
+		nbFunding++
+		if idx == -1 && w.utxos[i].outpoint.Tree == wire.TxTreeStake {
+			idx = i
+		}
+	}
+	if idx == -1 {
+		w.mtx.Unlock()
+		return nil, fmt.Errorf("no matured stake outputs available")
+	}
+	if nbTickets := w.purchasedTicketsPerBlock(); nbFunding-1 < nbTickets {
+		w.mtx.Unlock()
+		return nil, walletError(ErrFundingFailed, fmt.Errorf("spending a "+
+			"stake output would leave %d outputs to purchase the %d "+
+			"tickets of the next block", nbFunding-1, nbTickets))
+	}
+	utxo := w.utxos[idx]
+	w.utxos = append(w.utxos[:idx], w.utxos[idx+1:]...)
+	w.mtx.Unlock()
+
+	ticket, err := w.newTicket(&utxo, ticketPrice)
+	if err != nil {
+		return nil, err
+	}
+	ticketHash := ticket.TxHash()
+	w.addTicket(&ticketHash, ticketPrice, &utxo)
+	h, err := w.sendTx(ctx, ticket)
+	if err != nil {
+		w.removeTicket(&ticketHash)
+		return nil, fmt.Errorf("unable to send ticket tx: %v", err)
+	}
+	atomic.AddUint64(&w.ticketsPurchased, 1)
+	w.trackBurnedChange(ticket)
+	w.reportTicketFee(h, ticket, utxo.amount)
+	return h, nil
+}
+
+// AddToTreasury publishes a treasury add of the given amount funded by a utxo
+// of the wallet, returning the hash of the treasury add. The treasury add pays
+// a fee according to the fee rate of the wallet and returns the change to the
+// wallet, which becomes available for purchasing tickets once the treasury add
+// is mined and its change matures. Change below the dust limit is added to the
+// fee instead.
+//
+// Note that the treasury add is funded by the most recent utxo of the wallet
+// able to fund it, which is then no longer available for purchasing tickets, so
+// this is best used with amounts well below the ticket price.
+func (w *VotingWallet) AddToTreasury(ctx context.Context, amount dcrutil.Amount) (*chainhash.Hash, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("invalid treasury add amount %v", amount)
+	}
+
+	// The size of the treasury add with a change output is known once the
+	// signature script is accounted for.
+	tx := wire.NewMsgTx()
+	tx.Version = wire.TxVersionTreasury
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, wire.NullValueIn, nil))
+	tx.AddTxOut(wire.NewTxOut(int64(amount), []byte{txscript.OP_TADD}))
+	tx.AddTxOut(newTxOut(0, w.stakeChangeVer, w.stakeChange))
+	size := tx.SerializeSize() + p2pkhSigScriptSize
+	fee := int64(w.txFeeRate()) * int64(size) / 1000
+	minAmount := int64(amount) + fee
+
+	// Select the most recent utxo able to fund the treasury add and mark
+	// it used.
+	var utxo utxoInfo
+	found := false
+	w.mtx.Lock()
+	for i := len(w.utxos) - 1; i >= 0; i-- {
+		if w.utxos[i].amount >= minAmount {
+			utxo = w.utxos[i]
+			w.utxos = append(w.utxos[:i], w.utxos[i+1:]...)
+			found = true
+			break
+		}
+	}
+	w.mtx.Unlock()
+	if !found {
+		return nil, fmt.Errorf("no utxo available to fund treasury add of "+
+			"%v", amount)
+	}
+	restoreUtxo := func() {
+		w.mtx.Lock()
+		w.utxos = append(w.utxos, utxo)
+		w.mtx.Unlock()
+	}
+
+	tx.TxIn[0].PreviousOutPoint = utxo.outpoint
+	tx.TxIn[0].ValueIn = utxo.amount
+	changeAmount := utxo.amount - minAmount
+	if changeAmount < p2pkhDustLimit {
+		tx.TxOut = tx.TxOut[:1]
+	} else {
+		tx.TxOut[1].Value = changeAmount
+	}
+	sig, err := w.signer.Sign(tx, 0, w.utxoScript(&utxo))
+	if err != nil {
+		restoreUtxo()
+		return nil, fmt.Errorf("failed to sign treasury add: %v", err)
+	}
+	tx.TxIn[0].SignatureScript = sig
+	if err := stake.CheckTAdd(tx); err != nil {
+		restoreUtxo()
+		return nil, fmt.Errorf("transaction is not a valid treasury add: %v",
+			err)
+	}
+
+	// Track the change before publishing the treasury add, so that it is
+	// accounted for as soon as the treasury add may be mined.
+	txHash := tx.TxHash()
+	if len(tx.TxOut) > 1 {
+		w.mtx.Lock()
+		w.pendingTAdds[txHash] = utxoInfo{
+			outpoint: wire.OutPoint{
+				Hash:  txHash,
+				Index: 1,
+				Tree:  wire.TxTreeStake,
+			},
+			amount:     changeAmount,
+			taddChange: true,
+		}
+		w.mtx.Unlock()
+	}
+	h, err := w.sendTx(ctx, tx)
+	if err != nil {
+		w.mtx.Lock()
+		delete(w.pendingTAdds, txHash)
+		w.mtx.Unlock()
+		restoreUtxo()
+		return nil, fmt.Errorf("unable to send treasury add: %v", err)
+	}
+	return h, nil
+}
+
+// SendToAddress publishes a regular transaction that pays the given amount to
+// the given address, funded by utxos of the wallet, and returns its hash. This
+// allows funding other actors of a test from the surplus of the wallet. The
+// transaction pays a fee according to the fee rate of the wallet and returns
+// the change to the wallet, which becomes available for purchasing tickets once
+// the transaction is mined. Change below the dust limit is added to the fee
+// instead.
+//
+// The transaction is funded by the most recent utxos of the wallet, which are
+// then no longer available for purchasing tickets, so sending large amounts may
+// leave the wallet unable to purchase the tickets it requires.
+func (w *VotingWallet) SendToAddress(ctx context.Context, addr stdaddr.Address, amount dcrutil.Amount) (*chainhash.Hash, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("invalid send amount %v", amount)
+	}
+
+	payScriptVer, payScript := addr.PaymentScript()
+	tx := wire.NewMsgTx()
+	tx.AddTxOut(newTxOut(int64(amount), payScriptVer, payScript))
+	tx.AddTxOut(newTxOut(0, w.p2pkhVer, w.p2pkh))
+
+	// Select the most recent utxos until they are able to fund the amount
+	// and the fee of the transaction with all of its inputs, and mark them
+	// used.
+	var utxos []utxoInfo
+	var inputAmount, minAmount int64
+	funded := false
+	w.mtx.Lock()
+	for i := len(w.utxos) - 1; i >= 0; i-- {
+		utxo := w.utxos[i]
+		tx.AddTxIn(wire.NewTxIn(&utxo.outpoint, utxo.amount, nil))
+		utxos = append(utxos, utxo)
+		inputAmount += utxo.amount
+		size := tx.SerializeSize() + len(tx.TxIn)*p2pkhSigScriptSize
+		minAmount = int64(amount) + int64(w.feeRate)*int64(size)/1000
+		if inputAmount >= minAmount {
+			w.utxos = w.utxos[:i]
+			funded = true
+			break
+		}
+	}
+	if !funded {
+		w.mtx.Unlock()
+		return nil, fmt.Errorf("available utxos totaling %v unable to "+
+			"fund send of %v", dcrutil.Amount(inputAmount), amount)
+	}
+	w.mtx.Unlock()
+	restoreUtxos := func() {
+		w.mtx.Lock()
+		w.utxos = append(w.utxos, utxos...)
+		w.mtx.Unlock()
+	}
+
+	changeAmount := inputAmount - minAmount
+	if changeAmount < p2pkhDustLimit {
+		tx.TxOut = tx.TxOut[:1]
+	} else {
+		tx.TxOut[1].Value = changeAmount
+	}
+	for i := range utxos {
+		sig, err := w.signer.Sign(tx, i, w.utxoScript(&utxos[i]))
+		if err != nil {
+			restoreUtxos()
+			return nil, fmt.Errorf("failed to sign input %d of send: %v",
+				i, err)
+		}
+		tx.TxIn[i].SignatureScript = sig
+	}
+
+	// Track the change before publishing the transaction, so that it is
+	// accounted for as soon as the transaction may be mined.
+	txHash := tx.TxHash()
+	if len(tx.TxOut) > 1 {
+		w.mtx.Lock()
+		w.pendingRefunds[txHash] = []utxoInfo{{
+			outpoint: wire.OutPoint{
+				Hash:  txHash,
+				Index: 1,
+				Tree:  wire.TxTreeRegular,
+			},
+			amount: changeAmount,
+		}}
+		w.mtx.Unlock()
+	}
+	h, err := w.sendTx(ctx, tx)
+	if err != nil {
+		w.mtx.Lock()
+		delete(w.pendingRefunds, txHash)
+		w.mtx.Unlock()
+		restoreUtxos()
+		return nil, fmt.Errorf("unable to send transaction: %v", err)
+	}
+	return h, nil
+}
+
+// trackWinner records that the given ticket of the wallet was selected to vote
+// on the block at the given height.
+func (w *VotingWallet) trackWinner(ticket *chainhash.Hash, height int64) {
+	w.unconfirmedWinners[*ticket] = height
+}
+
+// confirmVote records that the vote of the given ticket was included in a
+// block.
+func (w *VotingWallet) confirmVote(ticket *chainhash.Hash) {
+	delete(w.unconfirmedWinners, *ticket)
+}
+
+// detectMissedTickets considers missed every winning ticket without a
+// confirmed vote after the grace period has elapsed, given the height of the
+// most recently connected block.
+func (w *VotingWallet) detectMissedTickets(height int64) {
+	w.mtx.Lock()
+	gracePeriod := w.missedGracePeriod
+	w.mtx.Unlock()
+	for ticket, winHeight := range w.unconfirmedWinners {
+		// The vote of a ticket selected on the block at winHeight is
+		// expected on the next block.
+		if height < winHeight+1+gracePeriod {
+			continue
+		}
+		delete(w.unconfirmedWinners, ticket)
+		w.mtx.Lock()
+		w.missedTickets[ticket] = winHeight
+		w.mtx.Unlock()
+	}
+}
+
+// trackTicketExpiry records the mined and expiry heights of the given ticket,
+// if it belongs to the wallet, which was mined in the block at the given
+// height.
+func (w *VotingWallet) trackTicketExpiry(tx *wire.MsgTx, height int64) {
+	ticketHash := tx.TxHash()
+	net := w.hn.ActiveNet
+	w.mtx.Lock()
+	if ticket, ok := w.tickets[ticketHash]; ok {
+		ticket.minedHeight = height
+		ticket.expiryHeight = height + int64(net.TicketMaturity) +
+			int64(net.TicketExpiry)
+		w.tickets[ticketHash] = ticket
+		w.trackTicketChange(tx, height)
+	}
+	w.mtx.Unlock()
+}
+
+// trackTicketChange makes the change of the given ticket of the wallet, mined
+// in the block at the given height, available for purchasing new tickets once
+// it matures when the ticket pays it to the stake change script of the wallet.
+// Change that is dust or already spent by another ticket is ignored.
+//
+// This function MUST be called with the wallet mutex held.
+func (w *VotingWallet) trackTicketChange(tx *wire.MsgTx, height int64) {
+	change := tx.TxOut[2]
+	if change.Value <= p2pkhDustLimit || change.Version != w.stakeChangeVer ||
+		!bytes.Equal(change.PkScript, w.stakeChange) {
+		return
+	}
+	outpoint := wire.OutPoint{Hash: tx.TxHash(), Index: 2, Tree: wire.TxTreeStake}
+	for _, ticket := range w.tickets {
+		if ticket.utxo.outpoint == outpoint {
+			return
+		}
+	}
+
+	// Ticket change may be spent once it has SStxChangeMaturity
+	// confirmations.
+	maturingHeight := height + int64(w.hn.ActiveNet.SStxChangeMaturity) - 1
+	w.addMaturingOutputs(maturingHeight, utxoInfo{
+		outpoint:     outpoint,
+		amount:       change.Value,
+		ticketChange: true,
+	})
+}
+
+// confirmRevocation records the given revocation, mined in the block at the
+// given height, of a ticket of the wallet. The outputs it pays to the wallet
+// become available for purchasing new tickets once they mature.
+func (w *VotingWallet) confirmRevocation(tx *wire.MsgTx, height int64) {
+	ticketHash := tx.TxIn[0].PreviousOutPoint.Hash
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	ticket, ok := w.tickets[ticketHash]
+	if !ok {
+		return
+	}
+	ticket.revoked = true
+	w.tickets[ticketHash] = ticket
+
+	revocationHash := tx.TxHash()
+	w.emitEvent(WalletEvent{
+		Type:       WalletEventRevocation,
+		Hash:       &revocationHash,
+		TicketHash: &ticketHash,
+		Height:     height,
+	})
+
+	// Revocation outputs mature in the same manner as the ones of votes,
+	// so they are first spent by the tickets purchased one block after
+	// the maturing height.
+	maturingHeight := height + int64(w.hn.ActiveNet.CoinbaseMaturity) - 1
+	for i, txOut := range tx.TxOut {
+		if !bytes.Equal(txOut.PkScript, w.revokeRetScript) {
+			continue
+		}
+		utxo := utxoInfo{
+			outpoint: wire.OutPoint{
+				Hash:  revocationHash,
+				Index: uint32(i),
+				Tree:  wire.TxTreeStake,
+			},
+			amount:     txOut.Value,
+			revocation: true,
+		}
+		w.addMaturingOutputs(maturingHeight, utxo)
+	}
+}
+
+// confirmTAdd records the given treasury add of the wallet, mined in the block
+// at the given height. Its change becomes available for purchasing new tickets
+// once it matures.
+func (w *VotingWallet) confirmTAdd(tx *wire.MsgTx, height int64) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	txHash := tx.TxHash()
+	change, ok := w.pendingTAdds[txHash]
+	if !ok {
+		return
+	}
+	delete(w.pendingTAdds, txHash)
+
+	// The change matures in the same manner as the outputs of revocations.
+	maturingHeight := height + int64(w.hn.ActiveNet.CoinbaseMaturity) - 1
+	w.addMaturingOutputs(maturingHeight, change)
+}
+
+// revokeTickets publishes revocations for the tickets of the wallet that are
+// considered missed or that expired as of the block at the given height and
+// that were not revoked yet. The block is the one the revocations build on.
+//
+// Once the automatic ticket revocations agenda is active, the network revokes
+// missed and expired tickets in the block where they become so, which is
+// confirmed by confirmRevocation, so this only publishes revocations for the
+// tickets that remain unspent.
+func (w *VotingWallet) revokeTickets(ctx context.Context, height int64, blockHeader []byte) {
+	revoke := w.revocableTickets(height)
+	for i := range revoke {
+		ticketHash := &revoke[i]
+		out, err := w.c.GetTxOut(ctx, ticketHash, 0, wire.TxTreeStake, true)
+		if err != nil {
+			w.logError(walletError(ErrQueryFailed, fmt.Errorf("unable "+
+				"to query ticket %s: %v", ticketHash, err)))
+			continue
+		}
+		if out == nil {
+			continue
+		}
+		_, err = w.revokeTicket(ctx, ticketHash, height, blockHeader)
+		if err != nil {
+			w.logError(err)
+			continue
+		}
+		w.mtx.Lock()
+		ticket := w.tickets[*ticketHash]
+		ticket.revoked = true
+		w.tickets[*ticketHash] = ticket
+		w.mtx.Unlock()
+	}
+}
+
+// revocableTickets returns the tickets of the wallet that are considered missed
+// or that expired as of the block at the given height, that were not revoked
+// yet and that the revocation policy of the wallet revokes at that height.
 //
-// Note that due to limitations in the current implementation of the voting
-// wallet, you can only reduce this amount (never increase it) and simnet
-// voting will stop once CoinbaseMaturity blocks have passed (so this needs to
-// be used only at the end of a test run).
-func (w *VotingWallet) LimitNbVotes(newLimit int) error {
-	if newLimit < 0 {
-		return fmt.Errorf("cannot use negative number of votes")
+// This function is safe for concurrent access.
+func (w *VotingWallet) revocableTickets(height int64) []chainhash.Hash {
+	var revoke []chainhash.Hash
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	policy := w.revocationPolicy
+	if policy == nil {
+		policy = RevokeImmediately
 	}
+	for ticketHash, ticket := range w.tickets {
+		if ticket.revoked {
+			continue
+		}
 
-	if newLimit > w.limitNbVotes {
-		return fmt.Errorf("cannot increase number of votes")
+		// Missed tickets are eligible once the block that should have
+		// included their vote is connected.
+		winHeight, missed := w.missedTickets[ticketHash]
+		_, voted := w.votedTickets[ticketHash]
+		expired := ticket.expiryHeight != 0 && height >= ticket.expiryHeight &&
+			!voted
+		var eligibleHeight int64
+		switch {
+		case missed:
+			eligibleHeight = winHeight + 1
+		case expired:
+			eligibleHeight = ticket.expiryHeight
+		default:
+			continue
+		}
+		if policy(eligibleHeight, height) {
+			revoke = append(revoke, ticketHash)
+		}
 	}
+	return revoke
+}
 
-	w.limitNbVotes = newLimit
-	return nil
+// RevocationPolicy determines whether the voting wallet revokes a ticket on
+// the block at the given height, given the height at which the ticket became
+// eligible for revocation, which is the height of the block that should have
+// included its vote for missed tickets and the expiry height for expired ones.
+type RevocationPolicy func(eligibleHeight, height int64) bool
+
+// RevokeImmediately is the default RevocationPolicy, which revokes tickets on
+// the first block where they are eligible for revocation.
+func RevokeImmediately(eligibleHeight, height int64) bool {
+	return true
 }
 
-// GenerateBlocks generates blocks while ensuring the chain will continue past
-// SVH indefinitely. This will generate a block then wait for the votes from
-// this wallet to be sent and tickets to be purchased before either generating
-// the next block or returning.
-//
-// This function will either return the hashes of the generated blocks or an
-// error if, after generating a candidate block, votes and tickets aren't
-// submitted in a timely fashion.
-func (w *VotingWallet) GenerateBlocks(ctx context.Context, nb uint32) ([]*chainhash.Hash, error) {
-	_, startHeight, err := w.c.GetBestBlock(ctx)
+// RevokeNever is a RevocationPolicy that never revokes tickets, such that they
+// remain missed or expired until the network revokes them automatically.
+func RevokeNever(eligibleHeight, height int64) bool {
+	return false
+}
+
+// RevokeAfter returns a RevocationPolicy that revokes tickets once the given
+// number of blocks are connected after the one where they became eligible for
+// revocation.
+func RevokeAfter(delay int64) RevocationPolicy {
+	return func(eligibleHeight, height int64) bool {
+		return height >= eligibleHeight+delay
+	}
+}
+
+// revokeTicket creates and publishes a revocation for the given ticket that
+// builds on the block at the given height with the given header and returns its
+// hash.
+func (w *VotingWallet) revokeTicket(ctx context.Context, ticketHash *chainhash.Hash, height int64, blockHeader []byte) (*chainhash.Hash, error) {
+	ticketTx, err := w.c.GetRawTransaction(ctx, ticketHash)
+	if err != nil {
+		return nil, walletError(ErrQueryFailed, fmt.Errorf("unable to get "+
+			"ticket %s: %v", ticketHash, err))
+	}
+	autoRevocations, err := w.isAgendaActive(ctx,
+		chaincfg.VoteIDAutoRevocations, height+1)
+	if err != nil {
+		return nil, walletError(ErrQueryFailed, err)
+	}
+	revocation, err := w.newRevocation(ticketHash, ticketTx.MsgTx(),
+		blockHeader, autoRevocations)
 	if err != nil {
 		return nil, err
 	}
-
-	nbVotes := w.limitNbVotes
-	nbTickets := int(w.hn.ActiveNet.TicketsPerBlock)
-	hashes := make([]*chainhash.Hash, nb)
-
-	miner := w.c.Generate
-	if w.miner != nil {
-		miner = w.miner
+	hash, err := w.sendTx(ctx, revocation)
+	if err != nil {
+		return nil, walletError(ErrBroadcastFailed, fmt.Errorf("unable to "+
+			"send revocation tx: %v", err))
 	}
+	atomic.AddUint64(&w.revocationsPublished, 1)
+	w.log.Infof("Published revocation %s of ticket %s on block at height %d",
+		hash, ticketHash, height)
+	return hash, nil
+}
 
-	for i := uint32(0); i < nb; i++ {
-		// genHeight is the height of the _next_ block (the one that will be
-		// generated once we call generate()).
-		genHeight := startHeight + int64(i) + 1
-
-		h, err := miner(ctx, 1)
+// newRevocation returns a revocation for the given ticket that builds on the
+// block with the given header.
+//
+// Once the automatic ticket revocations agenda is active, revocations must not
+// pay any fees and do not require signatures, so the revoke fee limit of the
+// ticket does not apply. Otherwise, the revocation pays a fee at the fee rate
+// of the wallet and is signed by the signer of the wallet, since it spends the
+// voting rights of the ticket.
+func (w *VotingWallet) newRevocation(ticketHash *chainhash.Hash, ticket *wire.MsgTx, blockHeader []byte, autoRevocations bool) (*wire.MsgTx, error) {
+	minOuts := stake.ConvertToMinimalOutputs(ticket)
+	create := func(fee dcrutil.Amount) (*wire.MsgTx, error) {
+		txVersion := uint16(wire.TxVersion)
+		if autoRevocations {
+			txVersion = stake.TxVersionAutoRevocations
+		}
+		revocation, err := stake.CreateRevocationFromTicket(ticketHash,
+			minOuts, fee, txVersion, w.hn.ActiveNet, blockHeader,
+			autoRevocations)
 		if err != nil {
-			return nil, fmt.Errorf("unable to generate block at height %d: %v",
-				genHeight, err)
+			return nil, walletError(ErrTxCreationFailed, fmt.Errorf("unable "+
+				"to create revocation for ticket %s: %v", ticketHash, err))
 		}
-		hashes[i] = h[0]
-
-		needsVotes := genHeight >= (w.hn.ActiveNet.StakeValidationHeight - 1)
-		needsTickets := genHeight >= ticketPurchaseStartHeight(w.hn.ActiveNet)
-
-		timeout := time.After(time.Second * 5)
-		testTimeout := time.After(time.Millisecond * 2)
-		gotAllReqs := !needsVotes && !needsTickets
-		for !gotAllReqs {
-			select {
-			case <-timeout:
-				mempoolTickets, _ := w.c.GetRawMempool(ctx, dcrdtypes.GRMTickets)
-				mempoolVotes, _ := w.c.GetRawMempool(ctx, dcrdtypes.GRMVotes)
-				var notGot []string
-				if len(mempoolVotes) != nbVotes {
-					notGot = append(notGot, "votes")
-				}
-				if len(mempoolTickets) != nbTickets {
-					notGot = append(notGot, "tickets")
-				}
-
-				return nil, fmt.Errorf("timeout waiting for %s "+
-					"at height %d", strings.Join(notGot, ","), genHeight)
-			case <-ctx.Done():
-				return nil, fmt.Errorf("wallet is stopping")
-			case <-testTimeout:
-				mempoolTickets, _ := w.c.GetRawMempool(ctx, dcrdtypes.GRMTickets)
-				mempoolVotes, _ := w.c.GetRawMempool(ctx, dcrdtypes.GRMVotes)
-
-				gotAllReqs = (!needsTickets || (len(mempoolTickets) >= nbVotes)) &&
-					(!needsVotes || (len(mempoolVotes) >= nbVotes))
-				testTimeout = time.After(time.Millisecond * 2)
-			}
+		if autoRevocations {
+			return revocation, nil
+		}
+		sigScript, err := w.signer.Sign(revocation, 0, ticket.TxOut[0].PkScript)
+		if err != nil {
+			return nil, walletError(ErrSignFailed, fmt.Errorf("failed to "+
+				"sign revocation tx: %v", err))
 		}
+		revocation.TxIn[0].SignatureScript = sigScript
+		return revocation, nil
 	}
 
-	return hashes, nil
+	// The length of the signature, and thus the fee required by the size of
+	// the revocation, may change with the fee, so the revocation is recreated
+	// until it pays enough.
+	var revocation *wire.MsgTx
+	for fee := dcrutil.Amount(0); ; {
+		var err error
+		if revocation, err = create(fee); err != nil {
+			return nil, err
+		}
+		if autoRevocations {
+			break
+		}
+		required := w.txFeeRate() * dcrutil.Amount(revocation.SerializeSize()) / 1000
+		if fee >= required {
+			break
+		}
+		fee = required
+	}
+	if err := stake.CheckSSRtx(revocation); err != nil {
+		return nil, walletError(ErrTxCreationFailed, fmt.Errorf("transaction "+
+			"is not a valid revocation: %v", err))
+	}
+	return revocation, nil
 }
 
-func (w *VotingWallet) logError(err error) {
-	if w.errorReporter != nil {
-		w.errorReporter(err)
+// RevokeAllTickets publishes revocations for all outstanding tickets of the
+// wallet that are eligible for revocation, which are the ones considered
+// missed and the ones that expired as of the current best block, and returns
+// the hashes of the published revocations. This allows reclaiming the funds
+// committed by the tickets of the wallet, for example to assert on the stake
+// accounting at the end of a test.
+//
+// Tickets that were already revoked, either by the wallet or automatically by
+// the network, are skipped. When outstanding tickets are not eligible for
+// revocation yet, the revocations of the eligible tickets are still published
+// and their hashes are returned along with an error listing the tickets that
+// are not eligible.
+func (w *VotingWallet) RevokeAllTickets(ctx context.Context) ([]*chainhash.Hash, error) {
+	bestHash, bestHeight, err := w.c.GetBestBlock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get best block: %v", err)
+	}
+	header, err := w.c.GetBlockHeader(ctx, bestHash)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get block header %s: %v", bestHash,
+			err)
+	}
+	blockHeader, err := header.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("unable to serialize block header %s: %v",
+			bestHash, err)
 	}
-}
 
-func (w *VotingWallet) onBlockConnected(blockHeader []byte, transactions [][]byte) {
-	w.blockConnectedNtfnChan <- blockConnectedNtfn{
-		blockHeader:  blockHeader,
-		transactions: transactions,
+	var eligible, notEligible []chainhash.Hash
+	w.mtx.Lock()
+	for ticketHash, ticket := range w.tickets {
+		if ticket.revoked {
+			continue
+		}
+		if _, voted := w.votedTickets[ticketHash]; voted {
+			continue
+		}
+		_, missed := w.missedTickets[ticketHash]
+		expired := ticket.expiryHeight != 0 && bestHeight >= ticket.expiryHeight
+		if missed || expired {
+			eligible = append(eligible, ticketHash)
+		} else {
+			notEligible = append(notEligible, ticketHash)
+		}
 	}
-}
+	w.mtx.Unlock()
 
-// newTxOut returns a new transaction output with the given parameters.
-func newTxOut(amount int64, pkScriptVer uint16, pkScript []byte) *wire.TxOut {
-	return &wire.TxOut{
-		Value:    amount,
-		Version:  pkScriptVer,
-		PkScript: pkScript,
+	// Revoke the tickets in a deterministic order.
+	sortHashes := func(hashes []chainhash.Hash) {
+		sort.Slice(hashes, func(i, j int) bool {
+			return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+		})
+	}
+	sortHashes(eligible)
+	sortHashes(notEligible)
+
+	revocations := make([]*chainhash.Hash, 0, len(eligible))
+	for i := range eligible {
+		ticketHash := &eligible[i]
+		out, err := w.c.GetTxOut(ctx, ticketHash, 0, wire.TxTreeStake, true)
+		if err != nil {
+			return revocations, walletError(ErrQueryFailed,
+				fmt.Errorf("unable to query ticket %s: %v", ticketHash,
+					err))
+		}
+		if out == nil {
+			// The ticket was already revoked by the network.
+			continue
+		}
+		hash, err := w.revokeTicket(ctx, ticketHash, bestHeight, blockHeader)
+		if err != nil {
+			return revocations, err
+		}
+		w.mtx.Lock()
+		ticket := w.tickets[*ticketHash]
+		ticket.revoked = true
+		w.tickets[*ticketHash] = ticket
+		w.mtx.Unlock()
+		revocations = append(revocations, hash)
+	}
+
+	if len(notEligible) > 0 {
+		return revocations, fmt.Errorf("%d tickets are not eligible for "+
+			"revocation as of block %d: %v", len(notEligible), bestHeight,
+			notEligible)
 	}
+	return revocations, nil
 }
 
-func (w *VotingWallet) handleBlockConnectedNtfn(ctx context.Context, ntfn *blockConnectedNtfn) {
+func (w *VotingWallet) handleBlockDisconnectedNtfn(ctx context.Context, ntfn *blockDisconnectedNtfn) {
 	var header wire.BlockHeader
 	err := header.FromBytes(ntfn.blockHeader)
 	if err != nil {
-		w.logError(err)
+		w.logError(walletError(ErrNotificationDecodeFailed, err))
 		return
 	}
 
-	blockHeight := int64(header.Height)
-	purchaseHeight := ticketPurchaseStartHeight(w.hn.ActiveNet)
-	if blockHeight < purchaseHeight {
-		// No need to purchase tickets yet.
-		return
+	w.log.Debugf("Block %s disconnected at height %d", header.BlockHash(),
+		header.Height)
+	w.mtx.Lock()
+	w.connectedHeight = int64(header.Height) - 1
+	w.hasConnected = true
+	w.hasConnectedHeader = false
+	w.mtx.Unlock()
+
+	// Votes cast on the disconnected block are no longer valid, so forget
+	// about them. This allows the tickets to vote again in case they are
+	// also selected on the new branch. Their outputs will never mature, so
+	// they are removed from the maturing votes as well.
+	blockHash := header.BlockHash()
+	staleVotes := make(map[chainhash.Hash]struct{})
+	w.mtx.Lock()
+	for ticket, rec := range w.votedTickets {
+		if rec.blockHash == blockHash {
+			staleVotes[rec.voteHash] = struct{}{}
+			delete(w.votedTickets, ticket)
+		}
 	}
+	w.removeMaturingOutputs(staleVotes)
+	delete(w.participation, int64(header.Height))
+	w.mtx.Unlock()
 
-	// Purchase TicketsPerBlock tickets.
-	nbTickets := int(w.hn.ActiveNet.TicketsPerBlock)
-	if len(w.utxos) < nbTickets {
-		w.logError(fmt.Errorf("number of available utxos (%d) less than "+
-			"number of tickets to purchase (%d)", len(w.utxos), nbTickets))
+	if w.observer {
 		return
 	}
 
-	// Use a slightly higher ticket price than the current minimum, to allow us
-	// to ignore stakediff changes at exactly the next block (where purchasing
-	// at the current value would cause our tickets to be rejected).
-	ticketPrice := header.SBits + (header.SBits / 6)
-	commitAmount := w.hn.ActiveNet.MinimumStakeDiff * commitAmountMultiplier
-
-	// Select utxos to use and mark them used.
-	utxos := make([]utxoInfo, nbTickets)
-	copy(utxos, w.utxos[len(w.utxos)-nbTickets:])
-	w.utxos = w.utxos[:len(w.utxos)-nbTickets]
-
-	tickets := make([]wire.MsgTx, nbTickets)
-	for i := 0; i < nbTickets; i++ {
-		changeAmount := utxos[i].amount - commitAmount
-
-		t := &tickets[i]
-		t.Version = wire.TxVersion
-		t.AddTxIn(wire.NewTxIn(&utxos[i].outpoint, wire.NullValueIn, nil))
-		t.AddTxOut(newTxOut(ticketPrice, w.p2sstxVer, w.p2sstx))
-		t.AddTxOut(newTxOut(0, w.commitScriptVer, w.commitScript))
-		t.AddTxOut(wire.NewTxOut(changeAmount, nullPay2SSTXChange))
-
-		prevScript := w.p2pkh
-		if utxos[i].outpoint.Tree == wire.TxTreeStake {
-			prevScript = w.voteRetScript
-		}
-
-		sig, err := sign.SignatureScript(t, 0, prevScript, txscript.SigHashAll,
-			w.privateKey, dcrec.STEcdsaSecp256k1, true)
-		if err != nil {
-			w.logError(fmt.Errorf("failed to sign ticket tx: %v", err))
-			return
+	// Tickets considered missed as of the disconnected block may still
+	// have their votes confirmed on the new branch.
+	blockHeight := int64(header.Height)
+	w.mtx.Lock()
+	for ticket, winHeight := range w.missedTickets {
+		if winHeight+1+w.missedGracePeriod >= blockHeight {
+			delete(w.missedTickets, ticket)
+			w.trackWinner(&ticket, winHeight)
 		}
-		t.TxIn[0].SignatureScript = sig
 	}
+	w.mtx.Unlock()
 
-	// Submit all tickets to the network.
-	promises := make([]*rpcclient.FutureSendRawTransactionResult, nbTickets)
-	for i := 0; i < nbTickets; i++ {
-		promises[i] = w.c.SendRawTransactionAsync(ctx, &tickets[i], true)
+	// The node still has the disconnected block, so use it to reverse the
+	// effects of the stake transactions of the wallet included in it.
+	block, err := w.c.GetBlock(ctx, &blockHash)
+	if err != nil {
+		w.logError(walletError(ErrQueryFailed, fmt.Errorf("unable to get "+
+			"disconnected block %s: %v", blockHash, err)))
+		return
 	}
+	for _, tx := range block.STransactions {
+		switch {
+		case stake.IsSSGen(tx):
+			// The vote is no longer confirmed unless it was also
+			// included in a block of the new branch.
+			ticketHash := &tx.TxIn[1].PreviousOutPoint.Hash
+			w.mtx.Lock()
+			_, ok := w.tickets[*ticketHash]
+			w.mtx.Unlock()
+			if !ok {
+				continue
+			}
+			voteHash := tx.TxHash()
+			res, err := w.c.GetRawTransactionVerbose(ctx, &voteHash)
+			if err == nil && res.Confirmations > 0 {
+				continue
+			}
+			w.trackWinner(ticketHash, blockHeight-1)
 
-	for i := 0; i < nbTickets; i++ {
-		h, err := promises[i].Receive()
-		if err != nil {
-			w.logError(fmt.Errorf("unable to send ticket tx: %v", err))
-			return
-		}
+		case stake.IsSStx(tx):
+			// The node adds the transactions of disconnected blocks back
+			// to the mempool, so only tickets that are no longer known
+			// as either mined or unmined were dropped. Their funding
+			// outputs are available again.
+			ticketHash := tx.TxHash()
+			w.mtx.Lock()
+			ticket, ok := w.tickets[ticketHash]
+			w.mtx.Unlock()
+			if !ok {
+				continue
+			}
+			out, err := w.c.GetTxOut(ctx, &ticketHash, 0, wire.TxTreeStake,
+				true)
+			if err != nil {
+				w.logError(walletError(ErrQueryFailed,
+					fmt.Errorf("unable to query ticket %s: %v",
+						ticketHash, err)))
+				continue
+			}
+			if out != nil {
+				// The ticket is unmined until it is included in a
+				// block of the new branch, and so is its change.
+				w.mtx.Lock()
+				ticket.minedHeight = 0
+				ticket.expiryHeight = 0
+				w.tickets[ticketHash] = ticket
+				w.removeMaturingOutputs(map[chainhash.Hash]struct{}{
+					ticketHash: {},
+				})
+				kept := w.utxos[:0]
+				for _, utxo := range w.utxos {
+					if utxo.outpoint.Hash != ticketHash {
+						kept = append(kept, utxo)
+					}
+				}
+				w.utxos = kept
+				w.mtx.Unlock()
+				continue
+			}
+			w.mtx.Lock()
+			delete(w.tickets, ticketHash)
+			w.utxos = append(w.utxos, ticket.utxo)
+			w.mtx.Unlock()
 
-		w.tickets[*h] = ticketInfo{
-			ticketPrice: ticketPrice,
+		case stake.IsSSRtx(tx):
+			// The outputs of the revocation no longer mature unless it
+			// is mined again, in which case it is confirmed again.
+			ticketHash := tx.TxIn[0].PreviousOutPoint.Hash
+			revocationHash := tx.TxHash()
+			w.mtx.Lock()
+			if ticket, ok := w.tickets[ticketHash]; ok {
+				ticket.revoked = false
+				w.tickets[ticketHash] = ticket
+				w.removeMaturingOutputs(map[chainhash.Hash]struct{}{
+					revocationHash: {},
+				})
+			}
+			w.mtx.Unlock()
 		}
 	}
+}
 
-	// Mark all maturing votes (if any) as available for spending.
-	if maturingVotes, has := w.maturingVotes[blockHeight]; has {
-		w.utxos = append(w.utxos, maturingVotes...)
-		delete(w.maturingVotes, blockHeight)
+// removeMaturingOutputs removes the maturing outputs created by any of the
+// given transactions.
+//
+// This function MUST be called with the wallet mutex held.
+func (w *VotingWallet) removeMaturingOutputs(txHashes map[chainhash.Hash]struct{}) {
+	for height, utxos := range w.maturingVotes {
+		kept := utxos[:0]
+		for _, utxo := range utxos {
+			if _, ok := txHashes[utxo.outpoint.Hash]; !ok {
+				kept = append(kept, utxo)
+			}
+		}
+		if len(kept) == 0 {
+			delete(w.maturingVotes, height)
+			continue
+		}
+		w.maturingVotes[height] = kept
 	}
 }
 
 func (w *VotingWallet) onWinningTickets(blockHash *chainhash.Hash, blockHeight int64,
 	winningTickets []*chainhash.Hash) {
 
-	w.winningTicketsNtfnChan <- winningTicketsNtfn{
+	w.checkBacklog("winning tickets", len(w.winningTicketsNtfnChan),
+		&w.winningTicketsBacklogged)
+	select {
+	case w.winningTicketsNtfnChan <- winningTicketsNtfn{
 		blockHash:      blockHash,
 		blockHeight:    blockHeight,
 		winningTickets: winningTickets,
+	}:
+	case <-w.quit:
 	}
 }
 
 func (w *VotingWallet) handleWinningTicketsNtfn(ctx context.Context, ntfn *winningTicketsNtfn) {
-	blockRefScript, err := txscript.GenerateSSGenBlockRef(*ntfn.blockHash,
+	if w.observer {
+		return
+	}
+
+	w.mtx.Lock()
+	blockRefScriptFunc := w.blockRefScriptFunc
+	stakeBaseSigScript := w.stakeBaseSigScript
+	extraVoteOutput := w.extraVoteOutput
+	w.mtx.Unlock()
+	customBlockRef := blockRefScriptFunc != nil
+	customStakeBase := stakeBaseSigScript != nil
+	if !customStakeBase {
+		stakeBaseSigScript = w.hn.ActiveNet.StakeBaseSigScript
+	}
+	if !customBlockRef {
+		blockRefScriptFunc = txscript.GenerateSSGenBlockRef
+	}
+	blockRefScript, err := blockRefScriptFunc(*ntfn.blockHash,
 		uint32(ntfn.blockHeight))
 	if err != nil {
-		w.logError(fmt.Errorf("unable to generate ssgen block ref: %v", err))
+		w.logError(walletError(ErrTxCreationFailed,
+			fmt.Errorf("unable to generate ssgen block ref: %v", err)))
 		return
 	}
 
-	// Always consider the subsidy split enabled since the test voting wallet
-	// is only used with simnet where the agenda is always active.
-	const isSubsidySplitEnabled = true
+	// The votes are included in the block after the one being voted on, so
+	// the stakebase depends on whether the subsidy split is enabled for it.
+	isSubsidySplitEnabled, err := w.isAgendaActive(ctx,
+		chaincfg.VoteIDChangeSubsidySplit, ntfn.blockHeight+1)
+	if err != nil {
+		w.logError(walletError(ErrQueryFailed, err))
+		return
+	}
 	stakebaseValue := w.subsidyCache.CalcStakeVoteSubsidyV2(ntfn.blockHeight,
 		isSubsidySplitEnabled)
 
 	// Create the votes. nbVotes is the number of tickets from the wallet that
 	// voted.
-	votes := make([]wire.MsgTx, w.limitNbVotes)
+	w.mtx.Lock()
+	limitNbVotes := w.limitNbVotes
+	voteScriptVer, voteScript := w.voteScriptVer, w.voteScript
+	disapproveBlock := w.disapproveBlock
+	selector := w.voteChoicesSelector
+	tspendVotes := append([]*stake.TreasuryVoteTuple(nil), w.tspendVotes...)
+	w.mtx.Unlock()
+
+	votes := make([]wire.MsgTx, limitNbVotes)
 	nbVotes := 0
 
 	var (
@@ -518,36 +5769,127 @@ func (w *VotingWallet) handleWinningTicketsNtfn(ctx context.Context, ntfn *winni
 		myTicket bool
 	)
 
+	// Track every winning ticket of the wallet, including the ones that
+	// do not vote due to the vote limit or simulated missed votes, so that
+	// missed votes are detected.
+	summary := VoteSummary{
+		BlockHash:   *ntfn.blockHash,
+		BlockHeight: ntfn.blockHeight,
+	}
+	var winners []chainhash.Hash
+	w.mtx.Lock()
 	for _, wt := range ntfn.winningTickets {
-		if ticket, myTicket = w.tickets[*wt]; !myTicket {
+		if _, ok := w.tickets[*wt]; ok {
+			w.trackWinner(wt, ntfn.blockHeight)
+			winners = append(winners, *wt)
+		}
+	}
+	summary.Winners = len(winners)
+	if len(winners) > 0 {
+		w.wins.add(WinRecord{
+			BlockHash:   *ntfn.blockHash,
+			BlockHeight: ntfn.blockHeight,
+			Tickets:     winners,
+		})
+	}
+	skipped := w.selectMissedVotes(ntfn)
+
+	// Vote in a random order so the vote limit selects random tickets.
+	winningTickets := ntfn.winningTickets
+	if w.voteSelection == VoteSelectionRandom {
+		winningTickets = append([]*chainhash.Hash(nil), winningTickets...)
+		w.voteSelectionRand.Shuffle(len(winningTickets), func(i, j int) {
+			winningTickets[i], winningTickets[j] =
+				winningTickets[j], winningTickets[i]
+		})
+	}
+	w.mtx.Unlock()
+
+	for _, wt := range winningTickets {
+		w.mtx.Lock()
+		ticket, myTicket = w.tickets[*wt]
+		w.mtx.Unlock()
+		if !myTicket {
+			continue
+		}
+		if _, skip := skipped[*wt]; skip {
+			summary.Missed++
+			continue
+		}
+
+		// A ticket that already voted on this same block is the result of
+		// a duplicate notification (such as when the chain reorganizes
+		// back to a previous tip), so it's skipped. Note that a ticket
+		// may legitimately win on different blocks at the same height,
+		// such as when a block is replaced during a reorg, so those are
+		// voted on as usual.
+		w.mtx.Lock()
+		rec, voted := w.votedTickets[*wt]
+		w.mtx.Unlock()
+		if voted && rec.blockHash == *ntfn.blockHash {
+			summary.Duplicates++
+			continue
+		}
+
+		// Limit the total number of issued votes if requested.
+		if nbVotes >= limitNbVotes {
+			summary.Limited++
 			continue
 		}
 
 		voteRetValue := ticket.ticketPrice + stakebaseValue
 
+		// Cast the agenda choices selected for this ticket, if any.
+		ticketVoteScriptVer, ticketVoteScript := voteScriptVer, voteScript
+		if selector != nil {
+			script, ok, err := w.selectedVoteScript(selector, wt,
+				disapproveBlock)
+			switch {
+			case err != nil:
+				w.logError(walletError(ErrTxCreationFailed,
+					fmt.Errorf("unable to select vote choices "+
+						"of ticket %s: %v", wt, err)))
+			case ok:
+				ticketVoteScriptVer, ticketVoteScript = 0, script
+			}
+		}
+
 		// Create a corresponding vote transaction.
 		vote := &votes[nbVotes]
 		nbVotes++
 		vote.Version = wire.TxVersion
 		vote.AddTxIn(wire.NewTxIn(
-			&stakebaseOutPoint, stakebaseValue, w.hn.ActiveNet.StakeBaseSigScript,
+			&stakebaseOutPoint, stakebaseValue, stakeBaseSigScript,
 		))
 		vote.AddTxIn(wire.NewTxIn(
 			wire.NewOutPoint(wt, 0, wire.TxTreeStake),
 			wire.NullValueIn, nil,
 		))
 		vote.AddTxOut(wire.NewTxOut(0, blockRefScript))
-		vote.AddTxOut(newTxOut(0, w.voteScriptVer, w.voteScript))
+		vote.AddTxOut(newTxOut(0, ticketVoteScriptVer, ticketVoteScript))
 		vote.AddTxOut(newTxOut(voteRetValue, w.voteRetScriptVer, w.voteRetScript))
 
 		// If there are tspends to vote for, create an additional
-		// output.
-		if len(w.tspendVotes) > 0 {
-			n := len(w.tspendVotes)
+		// output. Tspends the wallet abstains on are omitted from it,
+		// so the vote is a treasury vote without that output when the
+		// wallet abstains on all of them.
+		if len(tspendVotes) > 0 {
+			vote.Version = wire.TxVersionTreasury
+		}
+		var n int
+		for _, v := range tspendVotes {
+			if v.Vote != TreasuryVoteAbstain {
+				n++
+			}
+		}
+		if n > 0 {
 			opReturnLen := 2 + chainhash.HashSize*n + n
 			opReturnData := make([]byte, 0, opReturnLen)
 			opReturnData = append(opReturnData, 'T', 'V')
-			for _, v := range w.tspendVotes {
+			for _, v := range tspendVotes {
+				if v.Vote == TreasuryVoteAbstain {
+					continue
+				}
 				opReturnData = append(opReturnData, v.Hash[:]...)
 				opReturnData = append(opReturnData, byte(v.Vote))
 			}
@@ -556,54 +5898,133 @@ func (w *VotingWallet) handleWinningTicketsNtfn(ctx context.Context, ntfn *winni
 			bldr.AddData(opReturnData)
 			voteScript, err := bldr.Script()
 			if err != nil {
-				w.logError(fmt.Errorf("unable to construct vote script: %v", err))
+				w.logError(walletError(ErrTxCreationFailed,
+					fmt.Errorf("unable to construct vote script: %v",
+						err)))
 				return
 			}
 			vote.AddTxOut(wire.NewTxOut(0, voteScript))
+		}
+		if extraVoteOutput != nil {
 			vote.Version = wire.TxVersionTreasury
+			vote.AddTxOut(wire.NewTxOut(0, extraVoteOutput))
 		}
 
-		sig, err := sign.SignatureScript(vote, 1, w.p2sstx, txscript.SigHashAll,
-			w.privateKey, dcrec.STEcdsaSecp256k1, true)
+		sig, err := w.signer.Sign(vote, 1, w.p2sstx)
 		if err != nil {
-			w.logError(fmt.Errorf("failed to sign ticket tx: %v", err))
+			w.logError(walletError(ErrSignFailed,
+				fmt.Errorf("failed to sign vote tx: %v", err)))
 			return
 		}
 		vote.TxIn[1].SignatureScript = sig
 
-		err = stake.CheckSSGen(vote)
-		if err != nil {
-			w.logError(fmt.Errorf("transaction is not a valid vote: %v", err))
-			return
+		// Votes with a custom block reference or stakebase script are
+		// left for the network to validate.
+		if !customBlockRef && !customStakeBase {
+			err = stake.CheckSSGen(vote)
+			if err != nil {
+				w.logError(walletError(ErrTxCreationFailed,
+					fmt.Errorf("transaction is not a valid vote: %v",
+						err)))
+				return
+			}
 		}
+	}
+	w.mtx.Lock()
+	voteSummary := w.voteSummary
+	w.mtx.Unlock()
+	if voteSummary != nil {
+		defer voteSummary(&summary)
+	}
 
-		// Limit the total number of issued votes if requested.
-		if nbVotes >= w.limitNbVotes {
-			break
+	if nbVotes > 0 {
+		dryRunVotes := make([]*wire.MsgTx, nbVotes)
+		for i := range dryRunVotes {
+			dryRunVotes[i] = &votes[i]
+		}
+		if w.dryRunTxs(dryRunVotes) {
+			return
 		}
 	}
 
-	newUtxos := make([]utxoInfo, nbVotes)
+	newUtxos := make([]utxoInfo, 0, nbVotes)
 
 	// Publish the votes.
+	w.mtx.Lock()
+	sub := w.submittedFor(ntfn.blockHash, ntfn.blockHeight)
+	feeReporter, voteCast := w.feeReporter, w.voteCast
+	w.mtx.Unlock()
 	promises := make([]*rpcclient.FutureSendRawTransactionResult, nbVotes)
 	for i := 0; i < nbVotes; i++ {
 		promises[i] = w.c.SendRawTransactionAsync(ctx, &votes[i], true)
 	}
 	for i := 0; i < nbVotes; i++ {
-		h, err := promises[i].Receive()
+		h, err := w.receiveSentTx(ctx, &votes[i], promises[i])
 		if err != nil {
-			w.logError(fmt.Errorf("unable to send vote tx: %v", err))
-			return
+			w.logError(walletError(ErrBroadcastFailed,
+				fmt.Errorf("unable to send vote tx: %v", err)))
+			continue
 		}
-		newUtxos[i] = utxoInfo{
+		summary.Cast++
+		atomic.AddUint64(&w.votesCast, 1)
+		atomic.AddInt64(&w.subsidyEarned, votes[i].TxIn[0].ValueIn)
+		newUtxos = append(newUtxos, utxoInfo{
 			outpoint: wire.OutPoint{Hash: *h, Index: 2, Tree: wire.TxTreeStake},
 			amount:   votes[i].TxOut[2].Value,
+		})
+
+		w.mtx.Lock()
+		sub.votes = append(sub.votes, *h)
+		w.votedTickets[votes[i].TxIn[1].PreviousOutPoint.Hash] = voteRecord{
+			blockHash:   *ntfn.blockHash,
+			blockHeight: ntfn.blockHeight,
+			voteHash:    *h,
+		}
+		w.mtx.Unlock()
+
+		// The inputs of a vote are the stakebase and the ticket being
+		// redeemed.
+		if feeReporter != nil {
+			ticketHash := &votes[i].TxIn[1].PreviousOutPoint.Hash
+			w.mtx.Lock()
+			inputAmount := votes[i].TxIn[0].ValueIn +
+				w.tickets[*ticketHash].ticketPrice
+			w.mtx.Unlock()
+			feeReporter(newTxFeeInfo(h, &votes[i], stake.TxTypeSSGen,
+				inputAmount))
 		}
+		ticketHash := &votes[i].TxIn[1].PreviousOutPoint.Hash
+		if voteCast != nil {
+			voteCast(h, ticketHash, ntfn.blockHeight)
+		}
+		w.emitEvent(WalletEvent{
+			Type:       WalletEventVoteCast,
+			Hash:       h,
+			TicketHash: ticketHash,
+			Height:     ntfn.blockHeight,
+		})
 	}
 
-	maturingHeight := ntfn.blockHeight + int64(w.hn.ActiveNet.CoinbaseMaturity)
-	w.maturingVotes[maturingHeight] = newUtxos
+	w.mtx.Lock()
+	maturingHeight := ntfn.blockHeight +
+		int64(w.hn.ActiveNet.CoinbaseMaturity) - w.earlyMaturityBlocks
+	w.addMaturingOutputs(maturingHeight, newUtxos...)
+	sub.votesDone = true
+	w.mtx.Unlock()
+	w.log.Infof("Cast %d votes with %d winning tickets on block %s at "+
+		"height %d", summary.Cast, summary.Winners, ntfn.blockHash,
+		ntfn.blockHeight)
+
+	// Signal the first time a full block of votes has been published.
+	if !w.reachedSteadyState && summary.Cast == int(w.hn.ActiveNet.TicketsPerBlock) {
+		w.reachedSteadyState = true
+		w.mtx.Lock()
+		onSteadyState := w.onSteadyState
+		w.mtx.Unlock()
+		if onSteadyState != nil {
+			onSteadyState(ntfn.blockHeight)
+		}
+	}
 }
 
 // handleNotifications handles all notifications. This blocks until the passed
@@ -615,16 +6036,294 @@ func (w *VotingWallet) handleNotifications(ctx context.Context) {
 			return
 		case ntfn := <-w.blockConnectedNtfnChan:
 			w.handleBlockConnectedNtfn(ctx, &ntfn)
+		case ntfn := <-w.blockDisconnectedNtfnChan:
+			w.handleBlockDisconnectedNtfn(ctx, &ntfn)
 		case ntfn := <-w.winningTicketsNtfnChan:
 			w.handleWinningTicketsNtfn(ctx, &ntfn)
+		case err := <-w.backlogReports:
+			w.logError(err)
+		}
+	}
+}
+
+// TreasuryVoteAbstain is the treasury vote of the tuples passed to
+// VoteForTSpends for the tspends the wallet abstains on. Abstaining is not
+// encoded in votes, so these tspends are omitted from the treasury votes of
+// the wallet.
+const TreasuryVoteAbstain stake.TreasuryVoteT = 0xff
+
+// MaxTSpendVotes is the maximum number of tspends a vote may vote yes or no on,
+// which is the number of hash and vote pairs that fit in the data of its
+// treasury vote output after the 'TV' marker. Abstained tspends are omitted
+// from votes, so they do not count towards it.
+const MaxTSpendVotes = (stake.MaxDataCarrierSize - 2) / (chainhash.HashSize + 1)
+
+// AbstainTSpendVote returns a treasury vote tuple for VoteForTSpends that
+// abstains on the tspend with the given hash.
+func AbstainTSpendVote(hash *chainhash.Hash) *stake.TreasuryVoteTuple {
+	return &stake.TreasuryVoteTuple{Hash: *hash, Vote: TreasuryVoteAbstain}
+}
+
+// isAgendaActive returns whether the agenda with the given vote ID is active
+// for the block at the given height, which must not be before the block after
+// the current best block.
+//
+// Consensus considers an agenda always active on networks that do not define
+// it, such as the subsidy split and automatic ticket revocations agendas on
+// simnet. Otherwise, its status is queried from the network.
+//
+// This function is safe for concurrent access.
+func (w *VotingWallet) isAgendaActive(ctx context.Context, deploymentID string, height int64) (bool, error) {
+	w.mtx.Lock()
+	_, active := w.activeAgendas[deploymentID]
+	w.mtx.Unlock()
+	if active {
+		return true, nil
+	}
+
+	active = !agendaDefined(w.hn.ActiveNet, deploymentID)
+	if !active {
+		info, err := w.c.GetBlockChainInfo(ctx)
+		if err != nil {
+			return false, fmt.Errorf("unable to get blockchain info: %v",
+				err)
+		}
+		agenda, ok := info.Deployments[deploymentID]
+		if !ok {
+			return false, fmt.Errorf("network does not report the status "+
+				"of agenda %q", deploymentID)
+		}
+		active = agendaEnabled(&agenda, height,
+			w.hn.ActiveNet.RuleChangeActivationInterval)
+	}
+	if active {
+		w.mtx.Lock()
+		w.activeAgendas[deploymentID] = struct{}{}
+		w.mtx.Unlock()
+	}
+	return active, nil
+}
+
+// agendaDefined returns whether the given network defines a deployment for the
+// agenda with the given vote ID.
+func agendaDefined(net *chaincfg.Params, deploymentID string) bool {
+	for _, deployments := range net.Deployments {
+		for i := range deployments {
+			if deployments[i].Vote.Id == deploymentID {
+				return true
+			}
 		}
 	}
+	return false
+}
+
+// agendaEnabled returns whether the agenda with the given status, as reported
+// by the network as of a block before the given height, is active for the block
+// at the given height.
+//
+// An agenda that is locked in becomes active one rule change interval after it
+// was locked in.
+func agendaEnabled(agenda *dcrdtypes.AgendaInfo, height int64, ruleChangeInterval uint32) bool {
+	switch agenda.Status {
+	case dcrdtypes.AgendaInfoStatusActive:
+		return height >= agenda.Since
+	case dcrdtypes.AgendaInfoStatusLockedIn:
+		return height >= agenda.Since+int64(ruleChangeInterval)
+	}
+	return false
 }
 
 // VoteForTSpends sets the wallet to vote for the provided tspends when
-// creating vote transactions.
-func (w *VotingWallet) VoteForTSpends(votes []*stake.TreasuryVoteTuple) {
+// creating vote transactions. The votes are treasury votes whenever any tspend
+// is provided, including when the wallet abstains on all of them with
+// TreasuryVoteAbstain.
+//
+// It returns an error, keeping the tspends previously set, when a tuple is nil,
+// has a zero hash, has a vote other than stake.TreasuryVoteYes,
+// stake.TreasuryVoteNo or TreasuryVoteAbstain, or refers to the same tspend as
+// another tuple, or when more than MaxTSpendVotes tuples are not abstaining,
+// since those would produce invalid votes. Callers that ignore the returned
+// error behave as before for valid tuples.
+//
+// Each tspend is voted with the vote of its own tuple, in the order of the
+// tuples. SetTSpendVote changes the vote on a single tspend.
+func (w *VotingWallet) VoteForTSpends(votes []*stake.TreasuryVoteTuple) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.setTSpendVotesLocked(votes)
+}
+
+// setTSpendVotesLocked validates and sets the tspends to vote for as described
+// by VoteForTSpends.
+//
+// This function MUST be called with the wallet mutex held.
+func (w *VotingWallet) setTSpendVotesLocked(votes []*stake.TreasuryVoteTuple) error {
+	seen := make(map[chainhash.Hash]struct{}, len(votes))
+	var n int
+	for i, v := range votes {
+		if v == nil {
+			return fmt.Errorf("treasury vote %d is nil", i)
+		}
+		if v.Hash == (chainhash.Hash{}) {
+			return fmt.Errorf("treasury vote %d has a zero tspend hash", i)
+		}
+		if v.Vote != TreasuryVoteAbstain {
+			if _, err := stake.CheckTreasuryVote(v.Vote); err != nil {
+				return fmt.Errorf("treasury vote %d for tspend %s: %v",
+					i, v.Hash, err)
+			}
+			n++
+		}
+		if _, ok := seen[v.Hash]; ok {
+			return fmt.Errorf("duplicate treasury vote for tspend %s",
+				v.Hash)
+		}
+		seen[v.Hash] = struct{}{}
+	}
+	if n > MaxTSpendVotes {
+		return fmt.Errorf("%d treasury votes exceed the maximum of %d "+
+			"that fit in a vote", n, MaxTSpendVotes)
+	}
 	w.tspendVotes = votes
+	return nil
+}
+
+// SetTSpendVote sets the vote of the wallet on the tspend with the given hash,
+// replacing its tuple among the tspends set with VoteForTSpends or appending a
+// new one when the wallet is not voting on it yet, while the votes on the other
+// tspends are unchanged.
+//
+// It returns an error, keeping the tspends previously set, under the same
+// conditions as VoteForTSpends.
+func (w *VotingWallet) SetTSpendVote(hash chainhash.Hash, vote stake.TreasuryVoteT) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	votes := make([]*stake.TreasuryVoteTuple, 0, len(w.tspendVotes)+1)
+	var found bool
+	for _, v := range w.tspendVotes {
+		if v.Hash == hash {
+			v = &stake.TreasuryVoteTuple{Hash: hash, Vote: vote}
+			found = true
+		}
+		votes = append(votes, v)
+	}
+	if !found {
+		votes = append(votes, &stake.TreasuryVoteTuple{Hash: hash, Vote: vote})
+	}
+	return w.setTSpendVotesLocked(votes)
+}
+
+// CreateTSpend creates a treasury spend that pays the given payouts, expires at
+// the given expiry and is signed with the given Pi private key, such that it
+// may be published and then voted on by the wallet with VoteForTSpends. The
+// treasury spend pays a fee according to the fee rate of the wallet.
+//
+// An error is returned when the expiry is not two more than a treasury vote
+// interval or its voting window has already ended, when the key is not one of
+// the Pi keys of the network, or when the payouts and fee are not positive or
+// exceed the current treasury balance. Note that treasury spends that exceed
+// the expenditure policy of the network are still created, but are never
+// mined.
+func (w *VotingWallet) CreateTSpend(ctx context.Context, payouts []TSpendPayout, expiry uint32, piKey []byte) (*wire.MsgTx, error) {
+	net := w.hn.ActiveNet
+	if len(payouts) == 0 {
+		return nil, fmt.Errorf("treasury spend must have at least one payout")
+	}
+	var totalPayout int64
+	for i, payout := range payouts {
+		if payout.Amount <= 0 || payout.Amount > dcrutil.MaxAmount {
+			return nil, fmt.Errorf("invalid amount %v of payout %d",
+				payout.Amount, i)
+		}
+		totalPayout += int64(payout.Amount)
+		if totalPayout > int64(dcrutil.MaxAmount) {
+			return nil, fmt.Errorf("total payout exceeds the maximum "+
+				"amount %v", dcrutil.MaxAmount)
+		}
+	}
+
+	// Ensure the expiry defines a voting window that has not ended yet.
+	tvi := net.TreasuryVoteInterval
+	mul := net.TreasuryVoteIntervalMultiplier
+	_, voteEnd, err := standalone.CalcTSpendWindow(expiry, tvi, mul)
+	if err != nil {
+		return nil, fmt.Errorf("invalid treasury spend expiry: %v", err)
+	}
+	_, height, err := w.c.GetBestBlock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to obtain best block: %v", err)
+	}
+	if height+1 > int64(voteEnd) {
+		return nil, fmt.Errorf("voting window of expiry %d ended at height "+
+			"%d", expiry, voteEnd)
+	}
+
+	if len(piKey) != secp256k1.PrivKeyBytesLen {
+		return nil, fmt.Errorf("invalid Pi key length %d", len(piKey))
+	}
+	pubKey := secp256k1.PrivKeyFromBytes(piKey).PubKey().SerializeCompressed()
+	if !net.PiKeyExists(pubKey) {
+		return nil, fmt.Errorf("key %x is not a Pi key of the network",
+			pubKey)
+	}
+
+	// The first output commits to the value in of the treasury spend,
+	// which is signed, in the first 8 bytes of its payload: OP_RETURN
+	// OP_DATA_32 <8 byte LE value in> <24 random bytes>.
+	opReturnScript := make([]byte, 2+chainhash.HashSize)
+	opReturnScript[0] = txscript.OP_RETURN
+	opReturnScript[1] = txscript.OP_DATA_32
+	if _, err := crand.Read(opReturnScript[10:]); err != nil {
+		return nil, fmt.Errorf("unable to generate treasury spend "+
+			"payload: %v", err)
+	}
+	tx := wire.NewMsgTx()
+	tx.Version = wire.TxVersionTreasury
+	tx.Expiry = expiry
+	tx.AddTxOut(wire.NewTxOut(0, opReturnScript))
+	for _, payout := range payouts {
+		scriptVer, script := payout.Address.PayFromTreasuryScript()
+		txOut := wire.NewTxOut(int64(payout.Amount), script)
+		txOut.Version = scriptVer
+		tx.AddTxOut(txOut)
+	}
+
+	// Treasury spends have no inputs since the funds are sourced from the
+	// treasury, so the previous outpoint is the zero hash and max index.
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex, wire.TxTreeRegular),
+		Sequence:    wire.MaxTxInSequenceNum,
+		BlockHeight: wire.NullBlockHeight,
+		BlockIndex:  wire.NullBlockIndex,
+	})
+
+	size := tx.SerializeSize() + tspendSigScriptSize
+	fee := int64(w.txFeeRate()) * int64(size) / 1000
+	valueIn := totalPayout + fee
+	balance, err := w.c.GetTreasuryBalance(ctx, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get treasury balance: %v", err)
+	}
+	if uint64(valueIn) > balance.Balance {
+		return nil, fmt.Errorf("treasury spend of %v (including fee %v) "+
+			"exceeds the treasury balance %v", dcrutil.Amount(valueIn),
+			dcrutil.Amount(fee), dcrutil.Amount(balance.Balance))
+	}
+	binary.LittleEndian.PutUint64(opReturnScript[2:10], uint64(valueIn))
+	tx.TxIn[0].ValueIn = valueIn
+
+	sigScript, err := sign.TSpendSignatureScript(tx, piKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign treasury spend: %v", err)
+	}
+	tx.TxIn[0].SignatureScript = sigScript
+
+	if _, _, err := stake.CheckTSpend(tx); err != nil {
+		return nil, fmt.Errorf("transaction is not a valid treasury spend: %v",
+			err)
+	}
+	return tx, nil
 }
 
 // ticketPurchaseStartHeight returns the block height where ticket buying
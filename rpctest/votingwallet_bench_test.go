@@ -0,0 +1,46 @@
+// Copyright (c) 2022 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpctest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/wire"
+)
+
+// BenchmarkNewTickets benchmarks creating and signing the tickets a wallet
+// purchases in a block.
+func BenchmarkNewTickets(b *testing.B) {
+	params := chaincfg.SimNetParams()
+	w, err := newVotingWallet(&Harness{ActiveNet: params}, hardcodedPrivateKey)
+	if err != nil {
+		b.Fatalf("unable to create wallet: %v", err)
+	}
+
+	const ticketPrice = 2e8
+	nbTickets := int(params.MaxFreshStakePerBlock)
+	utxos := make([]utxoInfo, nbTickets)
+	for i := range utxos {
+		utxos[i] = utxoInfo{
+			outpoint: wire.OutPoint{Hash: chainhash.Hash{byte(i + 1)}},
+			amount:   ticketPrice * 2,
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := w.newTickets(utxos, ticketPrice); err != nil {
+			b.Fatalf("unable to create tickets: %v", err)
+		}
+	}
+	elapsed := time.Since(start)
+	b.ReportMetric(float64(elapsed.Nanoseconds())/float64(b.N*nbTickets),
+		"ns/ticket")
+}
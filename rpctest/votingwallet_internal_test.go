@@ -0,0 +1,867 @@
+// Copyright (c) 2022 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpctest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/decred/dcrd/blockchain/stake/v5"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec"
+	"github.com/decred/dcrd/dcrjson/v4"
+	"github.com/decred/dcrd/dcrutil/v4"
+	dcrdtypes "github.com/decred/dcrd/rpc/jsonrpc/types/v4"
+	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/decred/dcrd/txscript/v4"
+	"github.com/decred/dcrd/txscript/v4/stdaddr"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/slog"
+)
+
+// TestMissedGracePeriod ensures winning tickets are only considered missed
+// when their votes are not confirmed within the grace period.
+func TestMissedGracePeriod(t *testing.T) {
+	const winHeight = 100
+
+	tests := []struct {
+		name        string
+		gracePeriod int
+		voteHeight  int64 // zero for no vote
+		wantMissed  bool
+	}{{
+		name:       "vote confirmed on time",
+		voteHeight: winHeight + 1,
+	}, {
+		name:       "no vote without grace period",
+		wantMissed: true,
+	}, {
+		name:       "late vote without grace period",
+		voteHeight: winHeight + 2,
+		wantMissed: true,
+	}, {
+		name:        "late vote within grace period",
+		gracePeriod: 2,
+		voteHeight:  winHeight + 3,
+	}, {
+		name:        "late vote after grace period",
+		gracePeriod: 2,
+		voteHeight:  winHeight + 4,
+		wantMissed:  true,
+	}, {
+		name:        "no vote with grace period",
+		gracePeriod: 2,
+		wantMissed:  true,
+	}}
+
+	ticket := chainhash.Hash{0x01}
+	for _, test := range tests {
+		w := &VotingWallet{
+			unconfirmedWinners: make(map[chainhash.Hash]int64),
+			missedTickets:      make(map[chainhash.Hash]int64),
+		}
+		w.SetMissedGracePeriod(test.gracePeriod)
+		w.trackWinner(&ticket, winHeight)
+
+		// Connect blocks until well after the grace period, confirming the
+		// vote at the specified height.
+		for height := int64(winHeight + 1); height <= winHeight+10; height++ {
+			if height == test.voteHeight {
+				w.confirmVote(&ticket)
+			}
+			w.detectMissedTickets(height)
+		}
+
+		gotMissed := len(w.MissedTickets()) != 0
+		if gotMissed != test.wantMissed {
+			t.Errorf("%s: unexpected missed status; got %v, want %v",
+				test.name, gotMissed, test.wantMissed)
+		}
+	}
+}
+
+// TestAgendaEnabled ensures an agenda is considered enabled for the blocks
+// starting at its activation height.
+func TestAgendaEnabled(t *testing.T) {
+	const interval = 10
+
+	tests := []struct {
+		name   string
+		status string
+		since  int64
+		height int64
+		want   bool
+	}{{
+		name:   "started",
+		status: dcrdtypes.AgendaInfoStatusStarted,
+		since:  100,
+		height: 150,
+	}, {
+		name:   "locked in before activation",
+		status: dcrdtypes.AgendaInfoStatusLockedIn,
+		since:  100,
+		height: 100 + interval - 1,
+	}, {
+		name:   "locked in at activation",
+		status: dcrdtypes.AgendaInfoStatusLockedIn,
+		since:  100,
+		height: 100 + interval,
+		want:   true,
+	}, {
+		name:   "active",
+		status: dcrdtypes.AgendaInfoStatusActive,
+		since:  110,
+		height: 111,
+		want:   true,
+	}, {
+		name:   "active before activation height",
+		status: dcrdtypes.AgendaInfoStatusActive,
+		since:  110,
+		height: 109,
+	}, {
+		name:   "failed",
+		status: dcrdtypes.AgendaInfoStatusFailed,
+		since:  100,
+		height: 150,
+	}}
+
+	for _, test := range tests {
+		agenda := &dcrdtypes.AgendaInfo{Status: test.status, Since: test.since}
+		got := agendaEnabled(agenda, test.height, interval)
+		if got != test.want {
+			t.Errorf("%s: unexpected result; got %v, want %v", test.name,
+				got, test.want)
+		}
+	}
+}
+
+// TestIsRetryableSendError ensures that only transactions rejected for spending
+// outputs not yet known to the mempool and transactions that failed to reach
+// the node are considered for resending.
+func TestIsRetryableSendError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{{
+		name: "orphan transaction",
+		err: dcrjson.NewRPCError(dcrjson.ErrRPCMisc, "rejected transaction "+
+			"abcd: orphan transaction abcd references outputs of unknown "+
+			"or fully-spent transaction ef01"),
+		want: true,
+	}, {
+		name: "wrapped missing input",
+		err: fmt.Errorf("send failed: %w", dcrjson.NewRPCError(
+			dcrjson.ErrRPCMisc, "rejected transaction abcd: output ef01:0 "+
+				"referenced from transaction abcd:0 either does not exist "+
+				"or has already been spent")),
+		want: true,
+	}, {
+		name: "rule error",
+		err: dcrjson.NewRPCError(dcrjson.ErrRPCMisc, "rejected transaction "+
+			"abcd: transaction abcd has insufficient priority"),
+	}, {
+		name: "duplicate transaction",
+		err:  dcrjson.NewRPCError(dcrjson.ErrRPCDuplicateTx, "duplicate"),
+	}, {
+		name: "undecodable transaction",
+		err: dcrjson.NewRPCError(dcrjson.ErrRPCDeserialization,
+			"rejected"),
+	}, {
+		name: "connection closed",
+		err:  errors.New("connection closed"),
+		want: true,
+	}, {
+		name: "client disconnected",
+		err:  rpcclient.ErrClientDisconnect,
+		want: true,
+	}, {
+		name: "client shutdown",
+		err:  rpcclient.ErrClientShutdown,
+	}, {
+		name: "canceled context",
+		err:  fmt.Errorf("send failed: %w", context.Canceled),
+	}}
+
+	for _, test := range tests {
+		got := isRetryableSendError(test.err)
+		if got != test.want {
+			t.Errorf("%s: unexpected result; got %v, want %v", test.name,
+				got, test.want)
+		}
+	}
+}
+
+// TestWalletError ensures that wallet errors print their underlying error and
+// can be checked against both their kind and their underlying error.
+func TestWalletError(t *testing.T) {
+	rpcErr := dcrjson.NewRPCError(dcrjson.ErrRPCMisc, "rejected")
+	var err error = walletError(ErrBroadcastFailed,
+		fmt.Errorf("unable to send vote tx: %w", rpcErr))
+
+	const wantStr = "unable to send vote tx: -1: rejected"
+	if got := err.Error(); got != wantStr {
+		t.Errorf("unexpected error string; got %q, want %q", got, wantStr)
+	}
+	if !errors.Is(err, ErrBroadcastFailed) {
+		t.Errorf("error is not of kind %v", ErrBroadcastFailed)
+	}
+	if errors.Is(err, ErrSignFailed) {
+		t.Errorf("error is unexpectedly of kind %v", ErrSignFailed)
+	}
+	var gotRPCErr *dcrjson.RPCError
+	if !errors.As(err, &gotRPCErr) || gotRPCErr != rpcErr {
+		t.Errorf("underlying rpc error is not accessible")
+	}
+	var walletErr WalletError
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &walletErr) {
+		t.Fatalf("wrapped wallet error is not accessible")
+	}
+	if walletErr.Kind != ErrBroadcastFailed {
+		t.Errorf("unexpected error kind; got %v, want %v", walletErr.Kind,
+			ErrBroadcastFailed)
+	}
+}
+
+// TestNextStakeDifficulty ensures the stake difficulty of the next block is
+// derived from the header of the current block without querying the network
+// while the next block does not start a new stake difficulty window.
+func TestNextStakeDifficulty(t *testing.T) {
+	params := chaincfg.SimNetParams()
+	windowSize := uint32(params.StakeDiffWindowSize)
+	const sbits = 3e8
+
+	tests := []struct {
+		name   string
+		height uint32
+		want   int64
+	}{{
+		name:   "before stake difficulty start",
+		height: uint32(params.CoinbaseMaturity) - 1,
+		want:   params.MinimumStakeDiff,
+	}, {
+		name:   "first block of window",
+		height: windowSize * 10,
+		want:   sbits,
+	}, {
+		name:   "middle of window",
+		height: windowSize*10 + windowSize/2,
+		want:   sbits,
+	}, {
+		name:   "second to last block of window",
+		height: windowSize*11 - 2,
+		want:   sbits,
+	}}
+
+	// The wallet has no rpc client, so querying the network panics.
+	w := &VotingWallet{hn: &Harness{ActiveNet: params}}
+	for _, test := range tests {
+		header := &wire.BlockHeader{Height: test.height, SBits: sbits}
+		got, err := w.nextStakeDifficulty(context.Background(), header)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: unexpected stake difficulty; got %d, want %d",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestWinHistory ensures the win history keeps the most recent records in
+// order as it wraps around and is resized.
+func TestWinHistory(t *testing.T) {
+	heights := func(records []WinRecord) []int64 {
+		res := make([]int64, len(records))
+		for i := range records {
+			res[i] = records[i].BlockHeight
+		}
+		return res
+	}
+
+	h := winHistory{records: make([]WinRecord, 3)}
+	if got := h.recent(0); len(got) != 0 {
+		t.Fatalf("unexpected records in empty history: %v", heights(got))
+	}
+	for height := int64(1); height <= 5; height++ {
+		h.add(WinRecord{BlockHeight: height})
+	}
+	tests := []struct {
+		name string
+		n    int
+		want []int64
+	}{
+		{name: "all", n: 0, want: []int64{3, 4, 5}},
+		{name: "more than recorded", n: 10, want: []int64{3, 4, 5}},
+		{name: "most recent", n: 2, want: []int64{4, 5}},
+	}
+	for _, test := range tests {
+		got := heights(h.recent(test.n))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: unexpected records; got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+
+	h.resize(2)
+	if got := heights(h.recent(0)); !reflect.DeepEqual(got, []int64{4, 5}) {
+		t.Fatalf("unexpected records after shrinking: %v", got)
+	}
+	h.resize(4)
+	h.add(WinRecord{BlockHeight: 6})
+	h.add(WinRecord{BlockHeight: 7})
+	h.add(WinRecord{BlockHeight: 8})
+	want := []int64{5, 6, 7, 8}
+	if got := heights(h.recent(0)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected records after growing: got %v, want %v",
+			got, want)
+	}
+}
+
+// TestTestNetWallet ensures a wallet using the testnet parameters, backed by a
+// mocked harness that is never connected to a node, derives its scripts for
+// testnet and creates valid revocations both before and after the activation
+// of the automatic ticket revocations agenda, which is defined on testnet.
+func TestTestNetWallet(t *testing.T) {
+	params := chaincfg.TestNet3Params()
+	w, err := newVotingWallet(&Harness{ActiveNet: params}, hardcodedPrivateKey)
+	if err != nil {
+		t.Fatalf("unable to create wallet: %v", err)
+	}
+	if _, err := stdaddr.DecodeAddress(w.address.String(), params); err != nil {
+		t.Fatalf("address %v is not a testnet address: %v", w.address, err)
+	}
+
+	// Testnet defines the agendas that simnet considers always active, so
+	// their status must be queried, unlike agendas it does not define.
+	for _, id := range []string{chaincfg.VoteIDAutoRevocations,
+		chaincfg.VoteIDChangeSubsidySplit} {
+
+		if !agendaDefined(params, id) {
+			t.Fatalf("agenda %q not defined on testnet", id)
+		}
+		if agendaDefined(chaincfg.SimNetParams(), id) {
+			t.Fatalf("agenda %q unexpectedly defined on simnet", id)
+		}
+	}
+	active, err := w.isAgendaActive(context.Background(), "undefined", 1)
+	if err != nil || !active {
+		t.Fatalf("undefined agenda not considered active (err: %v)", err)
+	}
+
+	const ticketPrice = 2e8
+	utxo := utxoInfo{amount: ticketPrice * 2}
+	ticket, err := w.newTicket(&utxo, ticketPrice)
+	if err != nil {
+		t.Fatalf("unable to create ticket: %v", err)
+	}
+	if err := stake.CheckSStx(ticket); err != nil {
+		t.Fatalf("ticket is not a valid ticket: %v", err)
+	}
+	ticketHash := ticket.TxHash()
+	blockHeader, err := params.GenesisBlock.Header.Bytes()
+	if err != nil {
+		t.Fatalf("unable to serialize block header: %v", err)
+	}
+
+	// Before the agenda activates, revocations pay a fee and spend the
+	// voting rights of the ticket with a signature.
+	revocation, err := w.newRevocation(&ticketHash, ticket, blockHeader, false)
+	if err != nil {
+		t.Fatalf("unable to create revocation: %v", err)
+	}
+	if revocation.Version != wire.TxVersion {
+		t.Fatalf("unexpected revocation version %d", revocation.Version)
+	}
+	var outputs int64
+	for _, txOut := range revocation.TxOut {
+		outputs += txOut.Value
+	}
+	fee := dcrutil.Amount(ticket.TxOut[0].Value - outputs)
+	minFee := w.feeRate * dcrutil.Amount(revocation.SerializeSize()) / 1000
+	if fee < minFee {
+		t.Fatalf("revocation fee %v is less than %v", fee, minFee)
+	}
+	vm, err := txscript.NewEngine(ticket.TxOut[0].PkScript, revocation, 0,
+		0, ticket.TxOut[0].Version, nil)
+	if err != nil {
+		t.Fatalf("unable to create script engine: %v", err)
+	}
+	if err := vm.Execute(); err != nil {
+		t.Fatalf("revocation has an invalid signature: %v", err)
+	}
+
+	// Once the agenda is active, revocations neither pay fees nor require
+	// signatures.
+	revocation, err = w.newRevocation(&ticketHash, ticket, blockHeader, true)
+	if err != nil {
+		t.Fatalf("unable to create automatic revocation: %v", err)
+	}
+	if revocation.Version != stake.TxVersionAutoRevocations {
+		t.Fatalf("unexpected automatic revocation version %d",
+			revocation.Version)
+	}
+	if len(revocation.TxIn[0].SignatureScript) != 0 {
+		t.Fatal("automatic revocation unexpectedly signed")
+	}
+}
+
+// TestSortUtxosByOutpoint ensures utxos are sorted by outpoint regardless of
+// their initial order.
+func TestSortUtxosByOutpoint(t *testing.T) {
+	var utxos []utxoInfo
+	for i := 0; i < 4; i++ {
+		hash := chainhash.Hash{byte(i), 0xff - byte(i)}
+		for index := uint32(0); index < 3; index++ {
+			for _, tree := range []int8{wire.TxTreeRegular, wire.TxTreeStake} {
+				utxos = append(utxos, utxoInfo{outpoint: wire.OutPoint{
+					Hash:  hash,
+					Index: index,
+					Tree:  tree,
+				}})
+			}
+		}
+	}
+	want := append([]utxoInfo(nil), utxos...)
+
+	for seed := int64(0); seed < 3; seed++ {
+		got := append([]utxoInfo(nil), utxos...)
+		rng := rand.New(rand.NewSource(seed))
+		rng.Shuffle(len(got), func(i, j int) {
+			got[i], got[j] = got[j], got[i]
+		})
+		sortUtxosByOutpoint(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("seed %d: unexpected order %v", seed, got)
+		}
+	}
+}
+
+// TestFundingUtxos ensures the outputs of a funding transaction that pay to the
+// wallet are identified regardless of where the change is placed.
+func TestFundingUtxos(t *testing.T) {
+	w := &VotingWallet{
+		hn:         &Harness{ActiveNet: chaincfg.SimNetParams()},
+		privateKey: hardcodedPrivateKey,
+	}
+	if err := w.deriveScripts(dcrec.STEcdsaSecp256k1); err != nil {
+		t.Fatalf("unable to derive scripts: %v", err)
+	}
+	changeScript := make([]byte, len(w.p2pkh))
+	copy(changeScript, w.p2pkh)
+	changeScript[3] ^= 0xff
+
+	const value = 1e8
+	tx := wire.NewMsgTx()
+	tx.AddTxOut(wire.NewTxOut(5e8, changeScript))
+	tx.AddTxOut(wire.NewTxOut(value, w.p2pkh))
+	tx.AddTxOut(wire.NewTxOut(value, w.p2pkh))
+	tx.AddTxOut(wire.NewTxOut(3e8, changeScript))
+	tx.AddTxOut(wire.NewTxOut(value, w.p2pkh))
+
+	utxos := w.fundingUtxos(tx)
+	wantIndexes := []uint32{1, 2, 4}
+	if len(utxos) != len(wantIndexes) {
+		t.Fatalf("unexpected number of utxos; got %d, want %d", len(utxos),
+			len(wantIndexes))
+	}
+	txHash := tx.TxHash()
+	for i, utxo := range utxos {
+		want := wire.OutPoint{
+			Hash:  txHash,
+			Index: wantIndexes[i],
+			Tree:  wire.TxTreeRegular,
+		}
+		if utxo.outpoint != want || utxo.amount != value {
+			t.Fatalf("unexpected utxo %d; got %v of %d, want %v of %d", i,
+				utxo.outpoint, utxo.amount, want, int64(value))
+		}
+	}
+}
+
+// TestVoteForTSpends ensures the treasury votes of the wallet are validated
+// before they are set and that invalid ones keep the previous votes.
+func TestVoteForTSpends(t *testing.T) {
+	hash1 := chainhash.Hash{0x01}
+	hash2 := chainhash.Hash{0x02}
+	valid := []*stake.TreasuryVoteTuple{
+		{Hash: hash1, Vote: stake.TreasuryVoteYes},
+		{Hash: hash2, Vote: stake.TreasuryVoteNo},
+	}
+	w := &VotingWallet{}
+	if err := w.VoteForTSpends(valid); err != nil {
+		t.Fatalf("unexpected error setting valid treasury votes: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		votes []*stake.TreasuryVoteTuple
+	}{{
+		name:  "nil tuple",
+		votes: []*stake.TreasuryVoteTuple{nil},
+	}, {
+		name: "zero hash",
+		votes: []*stake.TreasuryVoteTuple{
+			{Hash: chainhash.Hash{}, Vote: stake.TreasuryVoteYes},
+		},
+	}, {
+		name: "invalid vote",
+		votes: []*stake.TreasuryVoteTuple{
+			{Hash: hash1, Vote: stake.TreasuryVoteInvalid},
+		},
+	}, {
+		name: "out of range vote",
+		votes: []*stake.TreasuryVoteTuple{
+			{Hash: hash1, Vote: 0x03},
+		},
+	}, {
+		name: "duplicate tspend",
+		votes: []*stake.TreasuryVoteTuple{
+			{Hash: hash1, Vote: stake.TreasuryVoteYes},
+			AbstainTSpendVote(&hash1),
+		},
+	}, {
+		name:  "too many tspends",
+		votes: tspendVotes(MaxTSpendVotes+1, stake.TreasuryVoteYes),
+	}}
+	for _, test := range tests {
+		if err := w.VoteForTSpends(test.votes); err == nil {
+			t.Fatalf("%s: unexpected success setting treasury votes",
+				test.name)
+		}
+		if !reflect.DeepEqual(w.tspendVotes, valid) {
+			t.Fatalf("%s: treasury votes changed to %v", test.name,
+				w.tspendVotes)
+		}
+	}
+
+	// Abstained tspends are omitted from votes, so they do not count
+	// towards the maximum.
+	votes := tspendVotes(MaxTSpendVotes, stake.TreasuryVoteNo)
+	abstainHash := chainhash.Hash{0xff}
+	votes = append(votes, AbstainTSpendVote(&abstainHash))
+	if err := w.VoteForTSpends(votes); err != nil {
+		t.Fatalf("unexpected error setting the maximum treasury votes: %v",
+			err)
+	}
+}
+
+// TestRetryQuery ensures queries are retried until they succeed, up to the
+// maximum number of attempts, and that retrying stops with the context.
+func TestRetryQuery(t *testing.T) {
+	ctx := context.Background()
+	errQuery := errors.New("query failed")
+
+	var calls int
+	err := retryQuery(ctx, "query", func() error {
+		calls++
+		if calls < 3 {
+			return errQuery
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("unexpected number of attempts; got %d, want 3", calls)
+	}
+
+	calls = 0
+	err = retryQuery(ctx, "query", func() error {
+		calls++
+		return errQuery
+	})
+	if calls != queryRetryAttempts {
+		t.Fatalf("unexpected number of attempts; got %d, want %d", calls,
+			queryRetryAttempts)
+	}
+	want := fmt.Sprintf("unable to query after %d attempts: %v",
+		queryRetryAttempts, errQuery)
+	if err == nil || err.Error() != want {
+		t.Fatalf("unexpected error; got %v, want %q", err, want)
+	}
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	calls = 0
+	err = retryQuery(cancelCtx, "query", func() error {
+		calls++
+		return errQuery
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("unexpected result with a done context; got %v after %d "+
+			"attempts", err, calls)
+	}
+}
+
+// TestRevocationPolicy ensures the tickets revoked by the wallet follow its
+// revocation policy.
+func TestRevocationPolicy(t *testing.T) {
+	missed := chainhash.Hash{0x01}
+	expired := chainhash.Hash{0x02}
+	voted := chainhash.Hash{0x03}
+	revoked := chainhash.Hash{0x04}
+	w := &VotingWallet{
+		tickets: map[chainhash.Hash]ticketInfo{
+			missed:  {},
+			expired: {expiryHeight: 110},
+			voted:   {expiryHeight: 105},
+			revoked: {revoked: true},
+		},
+		missedTickets: map[chainhash.Hash]int64{
+			missed:  100,
+			revoked: 100,
+		},
+		votedTickets: map[chainhash.Hash]voteRecord{
+			voted: {},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		policy RevocationPolicy
+		height int64
+		want   []chainhash.Hash
+	}{{
+		name:   "default",
+		height: 101,
+		want:   []chainhash.Hash{missed},
+	}, {
+		name:   "immediately",
+		policy: RevokeImmediately,
+		height: 110,
+		want:   []chainhash.Hash{missed, expired},
+	}, {
+		name:   "never",
+		policy: RevokeNever,
+		height: 200,
+	}, {
+		name:   "before delay",
+		policy: RevokeAfter(3),
+		height: 103,
+	}, {
+		name:   "after delay of missed",
+		policy: RevokeAfter(3),
+		height: 104,
+		want:   []chainhash.Hash{missed},
+	}, {
+		name:   "after delay of expired",
+		policy: RevokeAfter(3),
+		height: 113,
+		want:   []chainhash.Hash{missed, expired},
+	}}
+	for _, test := range tests {
+		w.SetRevocationPolicy(test.policy)
+		got := w.revocableTickets(test.height)
+		sort.Slice(got, func(i, j int) bool {
+			return bytes.Compare(got[i][:], got[j][:]) < 0
+		})
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: unexpected revoked tickets; got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// tspendVotes returns treasury vote tuples with the given vote on n distinct
+// tspends.
+func tspendVotes(n int, vote stake.TreasuryVoteT) []*stake.TreasuryVoteTuple {
+	votes := make([]*stake.TreasuryVoteTuple, 0, n)
+	for i := 0; i < n; i++ {
+		hash := chainhash.Hash{byte(i + 1)}
+		votes = append(votes, &stake.TreasuryVoteTuple{Hash: hash, Vote: vote})
+	}
+	return votes
+}
+
+// TestSetTSpendVote ensures setting the vote on a single tspend updates or
+// appends its tuple without changing the votes on the other tspends.
+func TestSetTSpendVote(t *testing.T) {
+	hash1 := chainhash.Hash{0x01}
+	hash2 := chainhash.Hash{0x02}
+	w := &VotingWallet{}
+	if err := w.SetTSpendVote(hash1, stake.TreasuryVoteYes); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.SetTSpendVote(hash2, stake.TreasuryVoteYes); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.SetTSpendVote(hash1, stake.TreasuryVoteNo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []*stake.TreasuryVoteTuple{
+		{Hash: hash1, Vote: stake.TreasuryVoteNo},
+		{Hash: hash2, Vote: stake.TreasuryVoteYes},
+	}
+	if !reflect.DeepEqual(w.tspendVotes, want) {
+		t.Fatalf("unexpected treasury votes; got %v, want %v",
+			w.tspendVotes, want)
+	}
+
+	if err := w.SetTSpendVote(hash2, stake.TreasuryVoteInvalid); err == nil {
+		t.Fatalf("unexpected success setting an invalid treasury vote")
+	}
+	if err := w.SetTSpendVote(chainhash.Hash{}, stake.TreasuryVoteYes); err == nil {
+		t.Fatalf("unexpected success voting on a zero tspend hash")
+	}
+	if !reflect.DeepEqual(w.tspendVotes, want) {
+		t.Fatalf("treasury votes changed to %v", w.tspendVotes)
+	}
+}
+
+// TestVoteConfiguration ensures the vote configuration of the wallet reports
+// the vote bits, agenda choices and treasury votes it casts.
+func TestVoteConfiguration(t *testing.T) {
+	// Simnet does not define any deployments, so use a copy of its params
+	// with test agendas.
+	const voteVersion = 10
+	params := chaincfg.SimNetParams()
+	params.Deployments = map[uint32][]chaincfg.ConsensusDeployment{
+		voteVersion: {{
+			Vote: chaincfg.Vote{
+				Id:   "agenda1",
+				Mask: 0x0006,
+				Choices: []chaincfg.Choice{
+					{Id: "abstain", Bits: 0x0000, IsAbstain: true},
+					{Id: "no", Bits: 0x0002, IsNo: true},
+					{Id: "yes", Bits: 0x0004},
+				},
+			},
+		}, {
+			Vote: chaincfg.Vote{
+				Id:   "agenda2",
+				Mask: 0x0018,
+				Choices: []chaincfg.Choice{
+					{Id: "abstain", Bits: 0x0000, IsAbstain: true},
+					{Id: "no", Bits: 0x0008, IsNo: true},
+					{Id: "yes", Bits: 0x0010},
+				},
+			},
+		}},
+	}
+	w := &VotingWallet{
+		hn:       &Harness{ActiveNet: params},
+		voteBits: voteBitsBlockValid,
+	}
+
+	want := &VoteConfiguration{
+		VoteBits:      voteBitsBlockValid,
+		BlockValid:    true,
+		AgendaChoices: map[string]string{},
+		TSpendVotes:   []stake.TreasuryVoteTuple{},
+	}
+	if got := w.VoteConfiguration(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected default configuration; got %+v, want %+v",
+			got, want)
+	}
+
+	err := w.SetAgendaChoices(map[string]string{"agenda1": "yes"})
+	if err != nil {
+		t.Fatalf("unable to set agenda choices: %v", err)
+	}
+	if err := w.SetBlockValidity(false); err != nil {
+		t.Fatalf("unable to set block validity: %v", err)
+	}
+	tspendHash := chainhash.Hash{0x01}
+	abstainHash := chainhash.Hash{0x02}
+	err = w.VoteForTSpends([]*stake.TreasuryVoteTuple{
+		{Hash: tspendHash, Vote: stake.TreasuryVoteNo},
+		AbstainTSpendVote(&abstainHash),
+	})
+	if err != nil {
+		t.Fatalf("unable to vote for treasury spends: %v", err)
+	}
+	w.SetVoteChoicesSelector(func(*chainhash.Hash) map[string]string {
+		return nil
+	})
+
+	want = &VoteConfiguration{
+		VoteBits:    0x0004,
+		VoteVersion: voteVersion,
+		BlockValid:  false,
+		AgendaChoices: map[string]string{
+			"agenda1": "yes",
+			"agenda2": "abstain",
+		},
+		PerTicketChoices: true,
+		TSpendVotes: []stake.TreasuryVoteTuple{
+			{Hash: tspendHash, Vote: stake.TreasuryVoteNo},
+			{Hash: abstainHash, Vote: TreasuryVoteAbstain},
+		},
+	}
+	if got := w.VoteConfiguration(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected configuration; got %+v, want %+v", got, want)
+	}
+}
+
+// TestOutOfOrderNotifications ensures the outputs maturing at a height are made
+// available for spending regardless of whether the winning tickets notification
+// that creates them is handled before or after the block connected
+// notification for that height.
+func TestOutOfOrderNotifications(t *testing.T) {
+	w := &VotingWallet{
+		hn:                 &Harness{ActiveNet: chaincfg.SimNetParams()},
+		tickets:            make(map[chainhash.Hash]ticketInfo),
+		maturingVotes:      make(map[int64][]utxoInfo),
+		unconfirmedWinners: make(map[chainhash.Hash]int64),
+		missedTickets:      make(map[chainhash.Hash]int64),
+		participation:      make(map[int64]blockParticipation),
+		log:                slog.Disabled,
+	}
+	connectBlock := func(height uint32) {
+		t.Helper()
+		header := wire.BlockHeader{Height: height}
+		headerBytes, err := header.Bytes()
+		if err != nil {
+			t.Fatalf("unable to serialize header: %v", err)
+		}
+		w.handleBlockConnectedNtfn(context.Background(), &blockConnectedNtfn{
+			blockHeader: headerBytes,
+		})
+	}
+	newUtxo := func(b byte) utxoInfo {
+		return utxoInfo{outpoint: wire.OutPoint{Hash: chainhash.Hash{b}}}
+	}
+	assertUtxos := func(want ...utxoInfo) {
+		t.Helper()
+		if !reflect.DeepEqual(w.utxos, want) {
+			t.Fatalf("unexpected utxos; got %v, want %v", w.utxos, want)
+		}
+	}
+
+	// Outputs of winning tickets handled before the block at their maturing
+	// height are released once it is connected.
+	early, onTime, late := newUtxo(1), newUtxo(2), newUtxo(3)
+	w.mtx.Lock()
+	w.addMaturingOutputs(11, onTime)
+	w.addMaturingOutputs(10, early)
+	w.addMaturingOutputs(12, late)
+	w.mtx.Unlock()
+	connectBlock(11)
+	assertUtxos(early, onTime)
+
+	// Outputs of winning tickets handled after the block at their maturing
+	// height are available right away.
+	delayed := newUtxo(4)
+	w.mtx.Lock()
+	w.addMaturingOutputs(11, delayed)
+	w.mtx.Unlock()
+	assertUtxos(early, onTime, delayed)
+
+	connectBlock(12)
+	assertUtxos(early, onTime, delayed, late)
+	if len(w.maturingVotes) != 0 {
+		t.Fatalf("unexpected maturing outputs left: %v", w.maturingVotes)
+	}
+}
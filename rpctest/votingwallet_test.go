@@ -9,12 +9,37 @@
 package rpctest
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/decred/dcrd/blockchain/stake/v5"
+	"github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/chaincfg/chainhash"
 	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrutil/v4"
+	dcrdtypes "github.com/decred/dcrd/rpc/jsonrpc/types/v4"
 	"github.com/decred/dcrd/rpcclient/v8"
+	"github.com/decred/dcrd/txscript/v4"
+	"github.com/decred/dcrd/txscript/v4/sign"
+	"github.com/decred/dcrd/txscript/v4/stdaddr"
+	"github.com/decred/dcrd/txscript/v4/stdscript"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/slog"
 )
 
 // testCanPassSVH tests whether the wallet can maintain the chain going past SVH
@@ -55,92 +80,5383 @@ func testCanPassSVH(ctx context.Context, t *testing.T, vw *VotingWallet) {
 	t.Logf("Generated up to block %d\n", targetHeight)
 }
 
-func TestMinimalVotingWallet(t *testing.T) {
-	// Skip tests when running with -short
-	if testing.Short() {
-		t.Skip("Skipping minimal voting wallet in short mode")
+// testSteadyStateSignal tests that the steady state callback is invoked exactly
+// once, when the wallet first casts a full block of votes.
+func testSteadyStateSignal(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	steadyStateChan := make(chan int64, 2)
+	vw.SetOnSteadyState(func(height int64) {
+		steadyStateChan <- height
+	})
+
+	// The first full block of votes is cast on the block immediately prior
+	// to SVH, given tickets are purchased such that they are mature by then.
+	net := vw.hn.ActiveNet
+	wantHeight := net.StakeValidationHeight - 1
+	targetHeight := net.StakeValidationHeight + 10
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	var handlers *rpcclient.NotificationHandlers
-	net := chaincfg.SimNetParams()
+	select {
+	case height := <-steadyStateChan:
+		if height != wantHeight {
+			t.Fatalf("unexpected steady state height: got %d, want %d",
+				height, wantHeight)
+		}
+	default:
+		t.Fatalf("steady state signal was not emitted")
+	}
 
-	logDir := "./dcrdlogs"
-	extraArgs := []string{
-		"--debuglevel=debug",
+	select {
+	case height := <-steadyStateChan:
+		t.Fatalf("steady state signal emitted more than once (height %d)",
+			height)
+	default:
 	}
+}
 
-	info, err := os.Stat(logDir)
-	if err != nil && !os.IsNotExist(err) {
-		t.Fatalf("error stating log dir: %v", err)
+// testFeeReporting tests that the fees reported for tickets match the wallet's
+// fee rate and that votes do not pay any fees.
+func testFeeReporting(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	var mtx sync.Mutex
+	var ticketFees, voteFees []*TxFeeInfo
+	vw.SetFeeReporting(func(info *TxFeeInfo) {
+		mtx.Lock()
+		switch info.TxType {
+		case stake.TxTypeSStx:
+			ticketFees = append(ticketFees, info)
+		case stake.TxTypeSSGen:
+			voteFees = append(voteFees, info)
+		}
+		mtx.Unlock()
+	})
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if info != nil {
-		if !info.IsDir() {
-			t.Fatalf("logdir (%s) is not a dir", logDir)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(ticketFees) == 0 || len(voteFees) == 0 {
+		t.Fatalf("no fees reported (tickets %d, votes %d)", len(ticketFees),
+			len(voteFees))
+	}
+
+	// The ticket fee is calculated using the largest possible signature
+	// script size, so the actual fee rate may be slightly higher than the
+	// configured one.
+	maxFeeRate := feeRate + feeRate/50
+	for _, info := range ticketFees {
+		if info.FeePerKB < feeRate || info.FeePerKB > maxFeeRate {
+			t.Fatalf("ticket %s has unexpected fee rate %d (fee %d, size "+
+				"%d); want between %d and %d", info.Hash, info.FeePerKB,
+				info.Fee, info.Size, feeRate, maxFeeRate)
 		}
-		err = os.RemoveAll(logDir)
+	}
+	for _, info := range voteFees {
+		if info.Fee != 0 {
+			t.Fatalf("vote %s has unexpected fee %d", info.Hash, info.Fee)
+		}
+	}
+}
+
+// testTicketPurchasedCallback tests that every ticket purchased by the wallet
+// is reported along with its price and the height it was purchased at.
+func testTicketPurchasedCallback(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	type purchase struct {
+		price  int64
+		height int64
+	}
+	var mtx sync.Mutex
+	purchases := make(map[chainhash.Hash]purchase)
+	vw.SetTicketPurchasedCallback(func(hash *chainhash.Hash, price int64, height int64) {
+		// Calling back into the wallet must not deadlock.
+		vw.LiveTicketCount()
+
+		mtx.Lock()
+		purchases[*hash] = purchase{price: price, height: height}
+		mtx.Unlock()
+	})
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	stats, err := vw.GenerateBlocksToHeightWithStats(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	var nbTickets int
+	for _, block := range stats.Blocks {
+		for _, ticketHash := range block.Tickets {
+			nbTickets++
+			p, ok := purchases[*ticketHash]
+			if !ok {
+				t.Fatalf("ticket %s was not reported", ticketHash)
+			}
+			if p.height >= block.Height {
+				t.Fatalf("ticket %s reported purchased at height %d, "+
+					"mined at height %d", ticketHash, p.height,
+					block.Height)
+			}
+			ticket, err := vw.hn.Node.GetRawTransaction(ctx, ticketHash)
+			if err != nil {
+				t.Fatalf("unable to get ticket %s: %v", ticketHash, err)
+			}
+			if price := ticket.MsgTx().TxOut[0].Value; p.price != price {
+				t.Fatalf("ticket %s reported with price %d, want %d",
+					ticketHash, p.price, price)
+			}
+		}
+	}
+	if nbTickets == 0 {
+		t.Fatalf("no tickets were mined")
+	}
+}
+
+// testVoteCastCallback tests that every vote cast by the wallet is reported
+// along with its ticket and the height of the block it votes on.
+func testVoteCastCallback(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	var mtx sync.Mutex
+	votesByHeight := make(map[int64]map[chainhash.Hash]chainhash.Hash)
+	vw.SetVoteCastCallback(func(voteHash, ticketHash *chainhash.Hash, blockHeight int64) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if votesByHeight[blockHeight] == nil {
+			votesByHeight[blockHeight] = make(map[chainhash.Hash]chainhash.Hash)
+		}
+		votesByHeight[blockHeight][*voteHash] = *ticketHash
+	})
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The votes for the current tip have already been cast, so the limit
+	// is only observed in the second generated block.
+	const nbVotes = 3
+	if err := vw.LimitNbVotes(nbVotes); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := vw.GenerateBlocksWithStats(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	for _, block := range stats.Blocks {
+		// The votes of a block vote on its parent.
+		reported := votesByHeight[block.Height-1]
+		if len(reported) != len(block.Votes) {
+			t.Fatalf("block at height %d includes %d votes, %d reported",
+				block.Height, len(block.Votes), len(reported))
+		}
+		for _, voteHash := range block.Votes {
+			ticketHash, ok := reported[*voteHash]
+			if !ok {
+				t.Fatalf("vote %s was not reported", voteHash)
+			}
+			_, _, recVote, ok := vw.VoteRecord(&ticketHash)
+			if !ok || *recVote != *voteHash {
+				t.Fatalf("vote %s reported for ticket %s which did not "+
+					"cast it", voteHash, ticketHash)
+			}
+		}
+	}
+	lastBlock := &stats.Blocks[len(stats.Blocks)-1]
+	if got := len(votesByHeight[lastBlock.Height-1]); got != nbVotes {
+		t.Fatalf("unexpected number of votes cast; got %d, want %d", got,
+			nbVotes)
+	}
+}
+
+// testEvents tests that the event stream of the wallet describes its activity
+// and drops events instead of blocking when it is not consumed.
+func testEvents(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	done := make(chan struct{})
+	counted := make(chan map[WalletEventType]int)
+	go func() {
+		counts := make(map[WalletEventType]int)
+		for {
+			select {
+			case event := <-vw.Events():
+				counts[event.Type]++
+			case <-done:
+				// Drain the events still buffered.
+				for len(vw.Events()) > 0 {
+					event := <-vw.Events()
+					counts[event.Type]++
+				}
+				counted <- counts
+				return
+			}
+		}
+	}()
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Limit the votes such that tickets are missed and revoked.
+	if err := vw.LimitNbVotes(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vw.GenerateBlocks(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	close(done)
+	counts := <-counted
+
+	for _, typ := range []WalletEventType{WalletEventTicketPurchased,
+		WalletEventVoteCast, WalletEventRevocation} {
+
+		if counts[typ] == 0 {
+			t.Fatalf("no %s events received (%v)", typ, counts)
+		}
+	}
+	if dropped := vw.DroppedEvents(); dropped != 0 {
+		t.Fatalf("%d events dropped while consumed", dropped)
+	}
+
+	// Events are dropped once the stream is full.
+	for i := 0; i < eventsBufferLen+2; i++ {
+		vw.emitEvent(WalletEvent{Type: WalletEventError})
+	}
+	if dropped := vw.DroppedEvents(); dropped < 2 {
+		t.Fatalf("unexpected number of dropped events %d", dropped)
+	}
+}
+
+// testCreateTSpend tests that treasury spends created by the wallet are
+// validated, accepted by the network and mined once approved by the votes of
+// the wallet.
+func testCreateTSpend(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+	targetHeight := net.StakeValidationHeight
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tvi := net.TreasuryVoteInterval
+	mul := net.TreasuryVoteIntervalMultiplier
+	piKey, _ := hex.DecodeString("62deae1ab2b1ebd96a28c80e870aee325bed359e83d8db2464ef999e616a9eef")
+	expiry := standalone.CalcTSpendExpiry(targetHeight+1, tvi, mul)
+	payouts := []TSpendPayout{{Address: vw.address, Amount: 1e8}}
+
+	balance, err := vw.hn.Node.GetTreasuryBalance(ctx, nil, false)
+	if err != nil {
+		t.Fatalf("unable to get treasury balance: %v", err)
+	}
+	invalid := []struct {
+		name    string
+		payouts []TSpendPayout
+		expiry  uint32
+		piKey   []byte
+	}{{
+		name:    "no payouts",
+		payouts: nil,
+		expiry:  expiry,
+		piKey:   piKey,
+	}, {
+		name:    "zero payout",
+		payouts: []TSpendPayout{{Address: vw.address}},
+		expiry:  expiry,
+		piKey:   piKey,
+	}, {
+		name: "payout exceeds treasury balance",
+		payouts: []TSpendPayout{{
+			Address: vw.address,
+			Amount:  dcrutil.Amount(balance.Balance),
+		}},
+		expiry: expiry,
+		piKey:  piKey,
+	}, {
+		name:    "expiry not on a treasury vote interval",
+		payouts: payouts,
+		expiry:  expiry + 1,
+		piKey:   piKey,
+	}, {
+		name:    "voting window ended",
+		payouts: payouts,
+		expiry:  uint32(tvi*mul + 2),
+		piKey:   piKey,
+	}, {
+		name:    "not a pi key",
+		payouts: payouts,
+		expiry:  expiry,
+		piKey:   hardcodedPrivateKey,
+	}}
+	for _, test := range invalid {
+		_, err := vw.CreateTSpend(ctx, test.payouts, test.expiry, test.piKey)
+		if err == nil {
+			t.Fatalf("%s: treasury spend was created", test.name)
+		}
+	}
+
+	tspend, err := vw.CreateTSpend(ctx, payouts, expiry, piKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tspendHash, err := vw.hn.Node.SendRawTransaction(ctx, tspend, true)
+	if err != nil {
+		t.Fatalf("unable to publish treasury spend: %v", err)
+	}
+	err = vw.VoteForTSpends([]*stake.TreasuryVoteTuple{
+		{Hash: *tspendHash, Vote: stake.TreasuryVoteYes},
+	})
+	if err != nil {
+		t.Fatalf("unable to vote for treasury spend: %v", err)
+	}
+
+	// The treasury spend is approved after two treasury vote intervals of
+	// voting, so it is mined in the block that follows them.
+	voteStart, _, err := standalone.CalcTSpendWindow(expiry, tvi, mul)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minedHeight := int64(voteStart) + int64(tvi*2)
+	_, err = vw.GenerateBlocksToHeight(ctx, minedHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := vw.hn.Node.GetRawTransactionVerbose(ctx, tspendHash)
+	if err != nil {
+		t.Fatalf("unable to get treasury spend: %v", err)
+	}
+	if res.BlockHeight != minedHeight {
+		t.Fatalf("treasury spend mined at height %d, want %d",
+			res.BlockHeight, minedHeight)
+	}
+}
+
+// testAddToTreasury tests that the wallet adds funds to the treasury and
+// recovers the change of its treasury adds once it matures.
+func testAddToTreasury(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Fund an additional block worth of outputs for the treasury add.
+	vw = replaceWallet(ctx, t, vw, fundExtraOutputs(t,
+		int(vw.hn.ActiveNet.TicketsPerBlock)))
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := vw.AddToTreasury(ctx, 0); err == nil {
+		t.Fatalf("treasury add without amount was published")
+	}
+	// The utxos of the wallet are sized to purchase tickets at the minimum
+	// stake difficulty, so add a fraction of it.
+	amount := vw.hn.ActiveNet.MinimumStakeDiff / 2
+	taddHash, err := vw.AddToTreasury(ctx, dcrutil.Amount(amount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vw.GenerateBlocks(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The treasury add is accounted in the treasury balance of the block
+	// that includes it.
+	res, err := vw.hn.Node.GetRawTransactionVerbose(ctx, taddHash)
+	if err != nil {
+		t.Fatalf("unable to get treasury add: %v", err)
+	}
+	if res.BlockHeight == 0 {
+		t.Fatalf("treasury add %s was not mined", taddHash)
+	}
+	blockHash, err := chainhash.NewHashFromStr(res.BlockHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, err := vw.hn.Node.GetTreasuryBalance(ctx, blockHash, true)
+	if err != nil {
+		t.Fatalf("unable to get treasury balance: %v", err)
+	}
+	var found bool
+	for _, update := range balance.Updates {
+		if update == amount {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("treasury add of %d not found in treasury updates %v",
+			amount, balance.Updates)
+	}
+
+	// The change returns to the utxos of the wallet once it matures, where
+	// it may immediately fund a ticket.
+	changeOutpoint := wire.OutPoint{Hash: *taddHash, Index: 1,
+		Tree: wire.TxTreeStake}
+	hasChange := func() bool {
+		vw.mtx.Lock()
+		defer vw.mtx.Unlock()
+		for _, utxo := range vw.utxos {
+			if utxo.outpoint == changeOutpoint {
+				return utxo.taddChange
+			}
+		}
+		for _, ticket := range vw.tickets {
+			if ticket.utxo.outpoint == changeOutpoint {
+				return ticket.utxo.taddChange
+			}
+		}
+		return false
+	}
+	if hasChange() {
+		t.Fatalf("immature treasury add change is spendable")
+	}
+	maturityHeight := res.BlockHeight + int64(vw.hn.ActiveNet.CoinbaseMaturity)
+	_, err = vw.GenerateBlocksToHeight(ctx, maturityHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasChange() {
+		t.Fatalf("treasury add change is not spendable once mature")
+	}
+}
+
+// assertNextTreasuryVotes asserts the votes included in the second next block,
+// since the votes for the current tip have already been cast, are treasury
+// votes that vote on the given tspends.
+func assertNextTreasuryVotes(ctx context.Context, t *testing.T, vw *VotingWallet, want []stake.TreasuryVoteTuple) {
+	t.Helper()
+	if _, err := vw.GenerateBlocks(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, votes, _, err := vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(votes) == 0 {
+		t.Fatalf("no votes included in block")
+	}
+	for _, voteHash := range votes {
+		vote, err := vw.hn.Node.GetRawTransaction(ctx, voteHash)
 		if err != nil {
-			t.Fatalf("error removing logdir: %v", err)
+			t.Fatalf("unable to get vote %s: %v", voteHash, err)
+		}
+		tx := vote.MsgTx()
+		if tx.Version != wire.TxVersionTreasury {
+			t.Fatalf("vote %s has version %d, want %d", voteHash,
+				tx.Version, wire.TxVersionTreasury)
+		}
+		got, err := stake.CheckSSGenVotes(tx)
+		if err != nil {
+			t.Fatalf("vote %s is not a valid vote: %v", voteHash, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("vote %s has unexpected treasury votes; got "+
+				"%v, want %v", voteHash, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("vote %s has unexpected treasury votes; "+
+					"got %v, want %v", voteHash, got, want)
+			}
 		}
 	}
+}
 
-	hn, err := New(t, net, handlers, extraArgs)
+// testAbstainTSpendVotes tests that the treasury votes of the wallet omit the
+// tspends it abstains on.
+func testAbstainTSpendVotes(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = hn.SetUp(true, 8)
+	yesHash := chainhash.Hash{0x01}
+	abstainHash := chainhash.Hash{0x02}
+	err = vw.VoteForTSpends([]*stake.TreasuryVoteTuple{
+		{Hash: yesHash, Vote: stake.TreasuryVoteYes},
+		AbstainTSpendVote(&abstainHash),
+	})
+	if err != nil {
+		t.Fatalf("unable to vote for treasury spends: %v", err)
+	}
+	assertNextTreasuryVotes(ctx, t, vw, []stake.TreasuryVoteTuple{
+		{Hash: yesHash, Vote: stake.TreasuryVoteYes},
+	})
+
+	err = vw.VoteForTSpends([]*stake.TreasuryVoteTuple{
+		AbstainTSpendVote(&yesHash),
+		AbstainTSpendVote(&abstainHash),
+	})
+	if err != nil {
+		t.Fatalf("unable to vote for treasury spends: %v", err)
+	}
+	assertNextTreasuryVotes(ctx, t, vw, nil)
+}
+
+// testStakebaseValue tests that the votes of the wallet redeem the stakebase
+// value required by consensus, which includes the subsidy split on simnet.
+func testStakebaseValue(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer hn.TearDown()
+	blockHash, votes, _, err := vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := vw.hn.Node.GetBlockHeader(ctx, blockHash)
+	if err != nil {
+		t.Fatalf("unable to get block header: %v", err)
+	}
 
-	type testCase struct {
-		name string
-		f    func(ctx context.Context, t *testing.T, vw *VotingWallet)
+	// Votes are included in the block after the one they vote on.
+	const isSubsidySplitEnabled = true
+	want := vw.subsidyCache.CalcStakeVoteSubsidyV2(int64(header.Height)-1,
+		isSubsidySplitEnabled)
+	for _, voteHash := range votes {
+		vote, err := vw.hn.Node.GetRawTransaction(ctx, voteHash)
+		if err != nil {
+			t.Fatalf("unable to get vote %s: %v", voteHash, err)
+		}
+		if got := vote.MsgTx().TxIn[0].ValueIn; got != want {
+			t.Fatalf("vote %s has stakebase value %d, want %d", voteHash,
+				got, want)
+		}
 	}
+}
 
-	testCases := []testCase{
-		{
-			name: "can get past SVH",
-			f:    testCanPassSVH,
-		},
+// testTicketPriceTracksStakeDifficulty tests that the tickets of a wallet
+// using the exact ticket price strategy are purchased at exactly the stake
+// difficulty of the blocks that include them as the difficulty changes.
+func testTicketPriceTracksStakeDifficulty(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	vw.SetTicketPriceStrategy(TicketPriceExact)
+	net := vw.hn.ActiveNet
+	targetHeight := net.StakeValidationHeight + 6*net.StakeDiffWindowSize
+	stats, err := vw.GenerateBlocksToHeightWithStats(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	var increased, decreased bool
+	var prevSBits dcrutil.Amount
+	for _, block := range stats.Blocks {
+		sbits := block.StakeDifficulty
+		if prevSBits != 0 {
+			increased = increased || sbits > prevSBits
+			decreased = decreased || sbits < prevSBits
+		}
+		prevSBits = sbits
 
-	for _, tc := range testCases {
-		var vw *VotingWallet
-		success := t.Run(tc.name, func(t1 *testing.T) {
-			vw, err = NewVotingWallet(ctx, hn)
+		for _, ticketHash := range block.Tickets {
+			ticket, err := vw.hn.Node.GetRawTransaction(ctx, ticketHash)
 			if err != nil {
-				t1.Fatalf("unable to create voting wallet for test: %v", err)
+				t.Fatalf("unable to get ticket %s: %v", ticketHash, err)
 			}
+			price := dcrutil.Amount(ticket.MsgTx().TxOut[0].Value)
+			if price != sbits {
+				t.Fatalf("ticket %s in block %d has unexpected price; "+
+					"got %v, want %v", ticketHash, block.Height, price,
+					sbits)
+			}
+		}
+	}
+	if !increased || !decreased {
+		t.Fatalf("stake difficulty did not both increase and decrease "+
+			"(increased %v, decreased %v)", increased, decreased)
+	}
+}
 
-			err = vw.Start(ctx)
-			if err != nil {
-				t1.Fatalf("unable to setup voting wallet: %v", err)
+// testPendingTxHashes tests that the pending votes and tickets of the wallet
+// are in the mempool after a block is generated and are the ones included in
+// the next block.
+func testPendingTxHashes(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+	targetHeight := net.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sameHashes := func(a, b []*chainhash.Hash) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		set := make(map[chainhash.Hash]struct{}, len(a))
+		for _, hash := range a {
+			set[*hash] = struct{}{}
+		}
+		for _, hash := range b {
+			if _, ok := set[*hash]; !ok {
+				return false
 			}
+		}
+		return true
+	}
 
-			vw.SetErrorReporting(func(vwerr error) {
-				t.Fatalf("voting wallet errored: %v", vwerr)
-			})
+	nbTickets := int(net.TicketsPerBlock)
+	for i := 0; i < 4; i++ {
+		pendingVotes, pendingTickets := vw.PendingTxHashes()
+		if len(pendingVotes) != nbTickets || len(pendingTickets) != nbTickets {
+			t.Fatalf("unexpected number of pending votes and tickets; "+
+				"got %d and %d, want %d", len(pendingVotes),
+				len(pendingTickets), nbTickets)
+		}
+		mempool, err := vw.hn.Node.GetRawMempool(ctx, dcrdtypes.GRMAll)
+		if err != nil {
+			t.Fatalf("unable to get mempool: %v", err)
+		}
+		inMempool := make(map[chainhash.Hash]struct{}, len(mempool))
+		for _, hash := range mempool {
+			inMempool[*hash] = struct{}{}
+		}
+		for _, hash := range append(pendingVotes, pendingTickets...) {
+			if _, ok := inMempool[*hash]; !ok {
+				t.Fatalf("pending tx %s is not in the mempool", hash)
+			}
+		}
 
-			tc.f(ctx, t1, vw)
-		})
+		_, votes, tickets, err := vw.GenerateOneBlock(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameHashes(votes, pendingVotes) {
+			t.Fatalf("block votes %v do not match pending votes %v",
+				votes, pendingVotes)
+		}
+		if !sameHashes(tickets, pendingTickets) {
+			t.Fatalf("block tickets %v do not match pending tickets %v",
+				tickets, pendingTickets)
+		}
+	}
+}
 
-		if vw != nil {
-			vw.SetErrorReporting(nil)
-			cancel()
+// testTicketsPerBlock tests that the wallet purchases the configured number of
+// tickets on each block and rejects numbers that would deplete it.
+func testTicketsPerBlock(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+	if err := vw.SetTicketsPerBlock(-1); err == nil {
+		t.Fatal("unexpected success setting a negative number of tickets")
+	}
+	maxTickets := int(net.MaxFreshStakePerBlock)
+	if err := vw.SetTicketsPerBlock(maxTickets + 1); err == nil {
+		t.Fatal("unexpected success setting more tickets than allowed " +
+			"per block")
+	}
+
+	// Purchase more tickets than the default on every block.
+	oversupply := int(net.TicketsPerBlock) + 2
+	vw = replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		if err := w.SetTicketsPerBlock(oversupply); err != nil {
+			t.Fatalf("unable to set tickets per block: %v", err)
+		}
+	})
+	targetHeight := net.StakeValidationHeight + 4
+	stats, err := vw.GenerateBlocksToHeightWithStats(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	purchaseHeight := ticketPurchaseStartHeight(net)
+	for _, block := range stats.Blocks {
+		if block.Height <= purchaseHeight {
+			continue
 		}
+		if len(block.Tickets) != oversupply {
+			t.Fatalf("block %d includes %d tickets, want %d",
+				block.Height, len(block.Tickets), oversupply)
+		}
+	}
 
-		if !success {
-			break
+	// The wallet does not hold enough outputs to purchase the maximum
+	// number of tickets per block until its votes mature.
+	if err := vw.SetTicketsPerBlock(maxTickets); err == nil {
+		t.Fatal("unexpected success setting a number of tickets that " +
+			"depletes the wallet")
+	}
+
+	// Purchase fewer tickets than the default.
+	undersupply := int(net.TicketsPerBlock) - 2
+	if err := vw.SetTicketsPerBlock(undersupply); err != nil {
+		t.Fatalf("unable to set tickets per block: %v", err)
+	}
+	if _, _, _, err := vw.GenerateOneBlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		_, _, tickets, err := vw.GenerateOneBlock(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tickets) != undersupply {
+			t.Fatalf("block includes %d tickets, want %d", len(tickets),
+				undersupply)
 		}
 	}
+}
+
+// testAutoRefund tests that a wallet that purchases more tickets than its votes
+// return keeps purchasing them once its initial funds are exhausted when auto
+// refunding is enabled.
+func testAutoRefund(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+	nbTickets := int(net.TicketsPerBlock) * 2
+	vw = replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		if err := w.SetTicketsPerBlock(nbTickets); err != nil {
+			t.Fatalf("unable to set tickets per block: %v", err)
+		}
+		w.SetAutoRefund(true)
+	})
 
-	err = hn.TearDown()
+	// The initial funds only last until shortly after the outputs of the
+	// first votes mature, since the wallet purchases twice as many tickets
+	// as it votes with on every block.
+	targetHeight := net.StakeValidationHeight + int64(net.CoinbaseMaturity)*2
+	stats, err := vw.GenerateBlocksToHeightWithStats(ctx, targetHeight)
 	if err != nil {
-		t.Fatalf("errored while tearing down test harness: %v", err)
+		t.Fatal(err)
+	}
+	purchaseHeight := ticketPurchaseStartHeight(net)
+	for _, block := range stats.Blocks {
+		if block.Height <= purchaseHeight {
+			continue
+		}
+		if len(block.Tickets) != nbTickets {
+			t.Fatalf("block %d includes %d tickets, want %d",
+				block.Height, len(block.Tickets), nbTickets)
+		}
+	}
+}
+
+// testAddress tests that the address and script returned by the wallet are the
+// ones that fund it.
+func testAddress(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	addr := vw.Address()
+	decoded, err := stdaddr.DecodeAddress(addr.String(), vw.hn.ActiveNet)
+	if err != nil {
+		t.Fatalf("unable to decode wallet address: %v", err)
+	}
+	if decoded.String() != addr.String() {
+		t.Fatalf("unexpected decoded address; got %v, want %v", decoded,
+			addr)
+	}
+
+	scriptVer, script := vw.P2PKHScript()
+	wantVer, wantScript := addr.PaymentScript()
+	if scriptVer != wantVer || !bytes.Equal(script, wantScript) {
+		t.Fatalf("unexpected p2pkh script; got %d:%x, want %d:%x",
+			scriptVer, script, wantVer, wantScript)
+	}
+
+	// The returned script is a copy.
+	script[0] ^= 0xff
+	if _, got := vw.P2PKHScript(); !bytes.Equal(got, wantScript) {
+		t.Fatal("modifying the returned script modified the wallet")
+	}
+
+	// The outputs that fund the wallet pay to its script.
+	vw.mtx.Lock()
+	outpoint := vw.utxos[0].outpoint
+	vw.mtx.Unlock()
+	fundingTx, err := vw.hn.Node.GetRawTransaction(ctx, &outpoint.Hash)
+	if err != nil {
+		t.Fatalf("unable to get funding tx: %v", err)
+	}
+	txOut := fundingTx.MsgTx().TxOut[outpoint.Index]
+	if txOut.Version != wantVer || !bytes.Equal(txOut.PkScript, wantScript) {
+		t.Fatalf("funding output does not pay to the wallet; got %d:%x, "+
+			"want %d:%x", txOut.Version, txOut.PkScript, wantVer,
+			wantScript)
+	}
+}
+
+// testRecycleChange tests that the tickets of a wallet that recycles its change
+// pay it to the wallet, which then purchases new tickets with it.
+func testRecycleChange(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Fund the tickets with enough to purchase another ticket with their
+	// change.
+	vw = replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		if err := w.SetCommitAmountMultiplier(4); err != nil {
+			t.Fatalf("unable to set commit amount multiplier: %v", err)
+		}
+		w.SetRecycleChange(true)
+	})
+
+	net := vw.hn.ActiveNet
+	targetHeight := ticketPurchaseStartHeight(net) + 4
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	wantVer, wantScript := vw.Address().(stdaddr.StakeAddress).StakeChangeScript()
+	var nbTickets, nbRecycled int
+	for h := ticketPurchaseStartHeight(net) + 1; h <= targetHeight; h++ {
+		blockHash, err := vw.hn.Node.GetBlockHash(ctx, h)
+		if err != nil {
+			t.Fatalf("unable to get block hash: %v", err)
+		}
+		stats, _, _, err := vw.blockStats(ctx, blockHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ticketHash := range stats.Tickets {
+			ticket, err := vw.hn.Node.GetRawTransaction(ctx, ticketHash)
+			if err != nil {
+				t.Fatalf("unable to get ticket %s: %v", ticketHash, err)
+			}
+			tx := ticket.MsgTx()
+			change := tx.TxOut[2]
+			if change.Version != wantVer ||
+				!bytes.Equal(change.PkScript, wantScript) {
+				t.Fatalf("ticket %s does not pay its change to the "+
+					"wallet", ticketHash)
+			}
+			nbTickets++
+			prevOut := tx.TxIn[0].PreviousOutPoint
+			if prevOut.Tree == wire.TxTreeStake && prevOut.Index == 2 {
+				nbRecycled++
+			}
+		}
+	}
+	if nbTickets == 0 || nbRecycled == 0 {
+		t.Fatalf("no tickets purchased with recycled change (%d of %d "+
+			"tickets)", nbRecycled, nbTickets)
+	}
+	if burned := vw.TotalBurnedChange(); burned != 0 {
+		t.Fatalf("unexpected burned change %v", burned)
+	}
+}
+
+// testSetFeeLimits tests that the tickets of the wallet encode the configured
+// vote and revocation fee limits.
+func testSetFeeLimits(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	if err := vw.SetFeeLimits(0, 1<<20); err == nil {
+		t.Fatal("unexpected success setting fee limits after start")
+	}
+
+	const voteLimitExp, revokeLimitExp = 10, 12
+	vw = replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		if err := w.SetFeeLimits(-1, 0); err == nil {
+			t.Fatal("unexpected success setting a negative fee limit")
+		}
+		if err := w.SetFeeLimits(0, 1000); err == nil {
+			t.Fatal("unexpected success setting a fee limit that is " +
+				"not a power of two")
+		}
+		err := w.SetFeeLimits(1<<voteLimitExp, 1<<revokeLimitExp)
+		if err != nil {
+			t.Fatalf("unable to set fee limits: %v", err)
+		}
+	})
+
+	targetHeight := ticketPurchaseStartHeight(vw.hn.ActiveNet) + 1
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+	_, _, tickets, err := vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tickets) == 0 {
+		t.Fatal("block does not include any tickets of the wallet")
+	}
+	for _, ticketHash := range tickets {
+		ticket, err := vw.hn.Node.GetRawTransaction(ctx, ticketHash)
+		if err != nil {
+			t.Fatalf("unable to get ticket %s: %v", ticketHash, err)
+		}
+		_, _, _, _, rules, limits := stake.TxSStxStakeOutputInfo(ticket.MsgTx())
+		if !rules[0][0] || !rules[0][1] {
+			t.Fatalf("ticket %s does not apply its fee limits",
+				ticketHash)
+		}
+		if limits[0][0] != voteLimitExp || limits[0][1] != revokeLimitExp {
+			t.Fatalf("ticket %s has unexpected fee limits; got 2^%d "+
+				"and 2^%d, want 2^%d and 2^%d", ticketHash,
+				limits[0][0], limits[0][1], voteLimitExp,
+				revokeLimitExp)
+		}
+	}
+}
+
+// testSetVoteVersion tests that the votes of the wallet cast the selected vote
+// version as it changes between blocks.
+func testSetVoteVersion(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		version uint32
+		bits    uint16
+	}{
+		{version: 5, bits: voteBitsBlockValid},
+		{version: 7, bits: voteBitsBlockValid},
+		{version: 7, bits: voteBitsBlockValid | 0x0002},
+		{version: 0, bits: voteBitsBlockValid},
+	}
+	for _, test := range tests {
+		if err := vw.SetVoteVersion(test.version); err != nil {
+			t.Fatalf("unable to set vote version %d: %v",
+				test.version, err)
+		}
+		if err := vw.SetVoteBits(test.bits); err != nil {
+			t.Fatalf("unable to set vote bits: %v", err)
+		}
+
+		// The votes for the current tip were already cast, so the
+		// selected version is only cast by the votes of the next
+		// block.
+		if _, _, _, err := vw.GenerateOneBlock(ctx); err != nil {
+			t.Fatal(err)
+		}
+		_, votes, _, err := vw.GenerateOneBlock(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(votes) == 0 {
+			t.Fatalf("no votes from the wallet in block")
+		}
+		for _, voteHash := range votes {
+			vote, err := vw.hn.Node.GetRawTransaction(ctx, voteHash)
+			if err != nil {
+				t.Fatalf("unable to get vote %s: %v", voteHash, err)
+			}
+			if err := stake.CheckSSGen(vote.MsgTx()); err != nil {
+				t.Fatalf("vote %s is not a valid vote: %v",
+					voteHash, err)
+			}
+			gotVersion := stake.SSGenVersion(vote.MsgTx())
+			if gotVersion != test.version {
+				t.Fatalf("vote %s has unexpected vote version; "+
+					"got %d, want %d", voteHash, gotVersion,
+					test.version)
+			}
+			gotBits := stake.SSGenVoteBits(vote.MsgTx())
+			if gotBits != test.bits {
+				t.Fatalf("vote %s has unexpected vote bits; got "+
+					"%#04x, want %#04x", voteHash, gotBits,
+					test.bits)
+			}
+		}
+	}
+}
+
+// testGenerateBlocksUntil tests that the wallet generates blocks until the
+// predicate is satisfied and stops on predicate errors.
+func testGenerateBlocksUntil(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mine until the best block is past the target height while counting
+	// the calls to the predicate.
+	const nbBlocks = 5
+	var calls int
+	hashes, err := vw.GenerateBlocksUntil(ctx, func(ctx context.Context) (bool, error) {
+		calls++
+		_, height, err := vw.hn.Node.GetBestBlock(ctx)
+		if err != nil {
+			return false, err
+		}
+		return height >= targetHeight+nbBlocks, nil
+	})
+	if err != nil {
+		t.Fatalf("unable to generate blocks: %v", err)
+	}
+	if len(hashes) != nbBlocks || calls != nbBlocks {
+		t.Fatalf("unexpected number of generated blocks; got %d "+
+			"blocks and %d predicate calls, want %d", len(hashes),
+			calls, nbBlocks)
+	}
+	bestHash, _, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *hashes[len(hashes)-1] != *bestHash {
+		t.Fatalf("last generated block %s is not the best block %s",
+			hashes[len(hashes)-1], bestHash)
+	}
+
+	// A predicate error stops the generation after the current block.
+	errPredicate := errors.New("predicate error")
+	hashes, err = vw.GenerateBlocksUntil(ctx, func(ctx context.Context) (bool, error) {
+		return false, errPredicate
+	})
+	if err == nil {
+		t.Fatal("unexpected success with a failing predicate")
+	}
+	if len(hashes) != 1 {
+		t.Fatalf("unexpected number of generated blocks with a failing "+
+			"predicate; got %d, want 1", len(hashes))
+	}
+}
+
+// testNewTickets tests that the tickets created by the wallet are valid and
+// returned in the order of the utxos that fund them.
+func testNewTickets(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	const ticketPrice = 2e8
+	nbTickets := int(vw.hn.ActiveNet.MaxFreshStakePerBlock)
+	utxos := make([]utxoInfo, nbTickets)
+	for i := range utxos {
+		utxos[i] = utxoInfo{
+			outpoint: wire.OutPoint{Index: uint32(i)},
+			amount:   ticketPrice * 2,
+		}
+	}
+	tickets, err := vw.newTickets(utxos, ticketPrice)
+	if err != nil {
+		t.Fatalf("unable to create tickets: %v", err)
+	}
+	if len(tickets) != nbTickets {
+		t.Fatalf("unexpected number of tickets; got %d, want %d",
+			len(tickets), nbTickets)
+	}
+	for i, ticket := range tickets {
+		prevOut := &ticket.TxIn[0].PreviousOutPoint
+		if *prevOut != utxos[i].outpoint {
+			t.Fatalf("ticket %d spends %v instead of %v", i, prevOut,
+				&utxos[i].outpoint)
+		}
+		if err := stake.CheckSStx(ticket); err != nil {
+			t.Fatalf("ticket %d is not a valid ticket: %v", i, err)
+		}
+		vm, err := txscript.NewEngine(vw.p2pkh, ticket, 0, 0,
+			vw.p2pkhVer, nil)
+		if err != nil {
+			t.Fatalf("unable to create script engine: %v", err)
+		}
+		if err := vm.Execute(); err != nil {
+			t.Fatalf("ticket %d has an invalid signature: %v", i, err)
+		}
+	}
+
+	// An error creating any ticket fails the whole batch.
+	utxos[nbTickets/2].amount = ticketPrice / 2
+	if _, err := vw.newTickets(utxos, ticketPrice); !errors.Is(err,
+		ErrFundingFailed) {
+
+		t.Fatalf("unexpected error creating underfunded tickets: %v", err)
+	}
+}
+
+// testConnectedHeight tests that the wallet tracks the height of the best block
+// from its notifications, including blocks it did not generate.
+func testConnectedHeight(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	hashes, err := vw.GenerateBlocks(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bestHash, bestHeight, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *bestHash != *hashes[len(hashes)-1] {
+		t.Fatalf("last generated block %s is not the best block %s",
+			hashes[len(hashes)-1], bestHash)
+	}
+	height, ok := vw.ConnectedHeight()
+	if !ok || height != bestHeight {
+		t.Fatalf("unexpected connected height; got %d (known %v), want %d",
+			height, ok, bestHeight)
+	}
+
+	// Blocks generated without the wallet are tracked as well.
+	if _, err := vw.hn.Node.Generate(ctx, 1); err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	err = waitPredicate(func() bool {
+		height, _ := vw.ConnectedHeight()
+		return height == bestHeight+1
+	}, 5*time.Second)
+	if err != nil {
+		height, _ := vw.ConnectedHeight()
+		t.Fatalf("connected height %d did not reach %d", height,
+			bestHeight+1)
+	}
+
+	// The stats of subsequently generated blocks report their heights.
+	stats, err := vw.GenerateBlocksWithStats(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, block := range stats.Blocks {
+		wantHeight := bestHeight + 2 + int64(i)
+		if block.Height != wantHeight {
+			t.Fatalf("unexpected height of generated block %s; got "+
+				"%d, want %d", block.Hash, block.Height, wantHeight)
+		}
+	}
+}
+
+// testDryRun tests that a wallet in dry-run mode creates valid tickets and
+// votes without publishing them.
+func testDryRun(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mtx            sync.Mutex
+		tickets, votes []*wire.MsgTx
+	)
+	vw.SetDryRun(true, func(txs []*wire.MsgTx) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, tx := range txs {
+			switch {
+			case stake.IsSStx(tx):
+				tickets = append(tickets, tx)
+			case stake.IsSSGen(tx):
+				votes = append(votes, tx)
+			default:
+				t.Errorf("unexpected dry-run transaction %s",
+					tx.TxHash())
+			}
+		}
+	})
+
+	// Generate a block without waiting for the wallet, which does not
+	// publish any transactions, and wait for it to create its tickets and
+	// votes in response.
+	if _, err := vw.miner(ctx, 1); err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	wantTickets := int(vw.hn.ActiveNet.TicketsPerBlock)
+	wantVotes := int(vw.hn.ActiveNet.TicketsPerBlock)
+	err := waitPredicate(func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return len(tickets) >= wantTickets && len(votes) >= wantVotes
+	}, 5*time.Second)
+	mtx.Lock()
+	defer mtx.Unlock()
+	if err != nil {
+		t.Fatalf("wallet did not create the expected transactions; got "+
+			"%d tickets and %d votes, want %d and %d", len(tickets),
+			len(votes), wantTickets, wantVotes)
+	}
+	for _, ticket := range tickets {
+		if err := stake.CheckSStx(ticket); err != nil {
+			t.Fatalf("ticket %s is not a valid ticket: %v",
+				ticket.TxHash(), err)
+		}
+	}
+	for _, vote := range votes {
+		if err := stake.CheckSSGen(vote); err != nil {
+			t.Fatalf("vote %s is not a valid vote: %v", vote.TxHash(), err)
+		}
+	}
+
+	// None of the transactions were published.
+	mempool, err := vw.hn.Node.GetRawMempool(ctx, dcrdtypes.GRMAll)
+	if err != nil {
+		t.Fatalf("unable to get mempool: %v", err)
+	}
+	for _, tx := range append(tickets, votes...) {
+		txHash := tx.TxHash()
+		for _, h := range mempool {
+			if *h == txHash {
+				t.Fatalf("dry-run transaction %s was published", h)
+			}
+		}
+	}
+}
+
+// testInvalidTicket tests that malformed tickets are reported by the wallet
+// instead of being published.
+func testInvalidTicket(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := ticketPurchaseStartHeight(vw.hn.ActiveNet) - 1
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tickets that pay their change to a regular script are invalid.
+	errs := make(chan error, 1)
+	vw.SetErrorReporting(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	vw.changeScript = vw.p2pkh
+	if _, err := vw.miner(ctx, 1); err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrTxCreationFailed) {
+			t.Fatalf("unexpected wallet error: %v", err)
+		}
+		if !strings.Contains(err.Error(), "ticket 0 ") {
+			t.Fatalf("wallet error does not identify the ticket: %v",
+				err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("malformed ticket was not reported")
+	}
+	mempoolTickets, err := vw.hn.Node.GetRawMempool(ctx,
+		dcrdtypes.GRMTickets)
+	if err != nil {
+		t.Fatalf("unable to get mempool tickets: %v", err)
+	}
+	if len(mempoolTickets) != 0 {
+		t.Fatalf("unexpected tickets in mempool: %v", mempoolTickets)
+	}
+}
+
+// testVoteSummary tests that the wallet reports the votes it cast on each block
+// along with the winning tickets that did not vote due to the vote limit.
+func testVoteSummary(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	var (
+		mtx       sync.Mutex
+		summaries = make(map[chainhash.Hash]VoteSummary)
+	)
+	vw.SetVoteSummaryCallback(func(summary *VoteSummary) {
+		mtx.Lock()
+		summaries[summary.BlockHash] = *summary
+		mtx.Unlock()
+	})
+	summaryFor := func(blockHash *chainhash.Hash) VoteSummary {
+		t.Helper()
+		var summary VoteSummary
+		err := waitPredicate(func() bool {
+			var ok bool
+			mtx.Lock()
+			summary, ok = summaries[*blockHash]
+			mtx.Unlock()
+			return ok
+		}, 5*time.Second)
+		if err != nil {
+			t.Fatalf("no vote summary for block %s", blockHash)
+		}
+		return summary
+	}
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	hashes, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpb := int(vw.hn.ActiveNet.TicketsPerBlock)
+	summary := summaryFor(hashes[len(hashes)-1])
+	want := VoteSummary{
+		BlockHash:   *hashes[len(hashes)-1],
+		BlockHeight: targetHeight,
+		Winners:     tpb,
+		Cast:        tpb,
+	}
+	if summary != want {
+		t.Fatalf("unexpected vote summary; got %+v, want %+v", summary,
+			want)
+	}
+
+	// Limiting the votes drops the votes of the remaining winners.
+	const nbVotes = 3
+	if err := vw.LimitNbVotes(nbVotes); err != nil {
+		t.Fatal(err)
+	}
+	hashes, err = vw.GenerateBlocks(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary = summaryFor(hashes[0])
+	want = VoteSummary{
+		BlockHash:   *hashes[0],
+		BlockHeight: targetHeight + 1,
+		Winners:     tpb,
+		Cast:        nbVotes,
+		Limited:     tpb - nbVotes,
+	}
+	if summary != want {
+		t.Fatalf("unexpected vote summary; got %+v, want %+v", summary,
+			want)
+	}
+}
+
+// testRecentWins tests that the wallet records the blocks its tickets were
+// selected to vote on along with the tickets that voted on them.
+func testRecentWins(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	hashes, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const nbWins = 3
+	wins := vw.RecentWins(nbWins)
+	if len(wins) != nbWins {
+		t.Fatalf("unexpected number of recent wins; got %d, want %d",
+			len(wins), nbWins)
+	}
+	for i, win := range wins {
+		wantHash := hashes[len(hashes)-nbWins+i]
+		wantHeight := targetHeight - nbWins + 1 + int64(i)
+		if win.BlockHash != *wantHash || win.BlockHeight != wantHeight {
+			t.Fatalf("unexpected win block; got %s (height %d), want "+
+				"%s (height %d)", win.BlockHash, win.BlockHeight,
+				wantHash, wantHeight)
+		}
+		if len(win.Tickets) != int(vw.hn.ActiveNet.TicketsPerBlock) {
+			t.Fatalf("unexpected number of winning tickets on block "+
+				"%s; got %d, want %d", win.BlockHash, len(win.Tickets),
+				vw.hn.ActiveNet.TicketsPerBlock)
+		}
+		for _, ticket := range win.Tickets {
+			blockHash, _, _, ok := vw.VoteRecord(&ticket)
+			if !ok || *blockHash != win.BlockHash {
+				t.Fatalf("winning ticket %s did not vote on block %s",
+					ticket, win.BlockHash)
+			}
+		}
+	}
+
+	// Shrinking the history keeps the most recent wins.
+	vw.SetWinHistorySize(1)
+	wins = vw.RecentWins(0)
+	if len(wins) != 1 || wins[0].BlockHash != *hashes[len(hashes)-1] {
+		t.Fatalf("unexpected recent wins after shrinking history: %v",
+			wins)
+	}
+}
+
+// mockSigner is a TxSigner that counts the inputs it signs with a wrapped
+// signer and fails to sign once failing is set.
+type mockSigner struct {
+	signer  TxSigner
+	signed  int32
+	failing int32
+}
+
+func (s *mockSigner) Sign(tx *wire.MsgTx, idx int, pkScript []byte) ([]byte, error) {
+	if atomic.LoadInt32(&s.failing) != 0 {
+		return nil, errors.New("signer unavailable")
+	}
+	atomic.AddInt32(&s.signed, 1)
+	return s.signer.Sign(tx, idx, pkScript)
+}
+
+// testTxSigner tests that a wallet signs its transactions with a custom signer.
+func testTxSigner(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	if _, err := NewVotingWallet(ctx, vw.hn, WithTxSigner(nil)); err == nil {
+		t.Fatalf("unexpected success creating wallet with nil signer")
+	}
+
+	signer := &mockSigner{signer: NewPrivateKeySigner(hardcodedPrivateKey)}
+	vw = replaceWallet(ctx, t, vw, nil, WithTxSigner(signer))
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&signer.signed) == 0 {
+		t.Fatalf("custom signer was not used")
+	}
+
+	// Signing failures are reported by the wallet.
+	errs := make(chan error, 1)
+	vw.SetErrorReporting(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	atomic.StoreInt32(&signer.failing, 1)
+	if _, err := vw.miner(ctx, 1); err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrSignFailed) {
+			t.Fatalf("unexpected wallet error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("signing failure was not reported")
+	}
+}
+
+// testSignatureType tests that a wallet signing with Schnorr signatures
+// purchases tickets and votes with them.
+func testSignatureType(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	if err := vw.SetSignatureType(dcrec.STSchnorrSecp256k1); err == nil {
+		t.Fatalf("unexpected success setting signature type after start")
+	}
+
+	vw = replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		if err := w.SetSignatureType(dcrec.STEd25519); err == nil {
+			t.Fatalf("unexpected success setting unsupported " +
+				"signature type")
+		}
+		if err := w.SetSignatureType(dcrec.STSchnorrSecp256k1); err != nil {
+			t.Fatalf("unable to set signature type: %v", err)
+		}
+	})
+	if _, ok := vw.Address().(*stdaddr.AddressScriptHashV0); !ok {
+		t.Fatalf("unexpected address type %T", vw.Address())
+	}
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+	_, votes, tickets, err := vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(votes) == 0 || len(tickets) == 0 {
+		t.Fatalf("block does not include votes and tickets of the " +
+			"wallet")
+	}
+	for _, ticketHash := range tickets {
+		ticket, err := vw.hn.Node.GetRawTransaction(ctx, ticketHash)
+		if err != nil {
+			t.Fatalf("unable to get ticket %s: %v", ticketHash, err)
+		}
+		script := ticket.MsgTx().TxOut[0].PkScript
+		if !stdscript.IsStakeSubmissionScriptHashScriptV0(script) {
+			t.Fatalf("ticket %s does not pay voting rights to a "+
+				"script hash", ticketHash)
+		}
+	}
+	for _, voteHash := range votes {
+		vote, err := vw.hn.Node.GetRawTransaction(ctx, voteHash)
+		if err != nil {
+			t.Fatalf("unable to get vote %s: %v", voteHash, err)
+		}
+		script := vote.MsgTx().TxOut[2].PkScript
+		if !stdscript.IsStakeGenScriptHashScriptV0(script) {
+			t.Fatalf("vote %s does not pay its reward to a script "+
+				"hash", voteHash)
+		}
+	}
+}
+
+// testRevokeAllTickets tests that the wallet skips its outstanding tickets that
+// were already revoked and reports the ones that are not eligible for
+// revocation.
+func testRevokeAllTickets(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// None of the live tickets of the wallet are eligible for revocation.
+	revocations, err := vw.RevokeAllTickets(ctx)
+	if err == nil {
+		t.Fatal("expected an error revoking live tickets")
+	}
+	if len(revocations) != 0 {
+		t.Fatalf("unexpected revocations of live tickets: %v", revocations)
+	}
+
+	// Limit the votes such that tickets are missed in the block after the
+	// next one, which automatically revokes them.
+	const nbVotes = 3
+	if err := vw.LimitNbVotes(nbVotes); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vw.GenerateBlocks(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Clear the revoked flags of the missed tickets as if the wallet had
+	// not seen their revocations, which must still be skipped since the
+	// tickets are already spent.
+	vw.mtx.Lock()
+	missed := make([]chainhash.Hash, 0, len(vw.missedTickets))
+	for ticketHash := range vw.missedTickets {
+		ticket := vw.tickets[ticketHash]
+		ticket.revoked = false
+		vw.tickets[ticketHash] = ticket
+		missed = append(missed, ticketHash)
+	}
+	vw.mtx.Unlock()
+	wantMissed := int(vw.hn.ActiveNet.TicketsPerBlock) - nbVotes
+	if len(missed) != wantMissed {
+		t.Fatalf("unexpected number of missed tickets; got %d, want %d",
+			len(missed), wantMissed)
+	}
+
+	revocations, err = vw.RevokeAllTickets(ctx)
+	if err == nil {
+		t.Fatal("expected an error revoking live tickets")
+	}
+	if len(revocations) != 0 {
+		t.Fatalf("unexpected revocations of revoked tickets: %v",
+			revocations)
+	}
+	for i := range missed {
+		if strings.Contains(err.Error(), missed[i].String()) {
+			t.Fatalf("missed ticket %s reported as not eligible: %v",
+				&missed[i], err)
+		}
+	}
+
+	// The error lists every live ticket of the wallet.
+	vw.mtx.Lock()
+	for ticketHash, ticket := range vw.tickets {
+		_, voted := vw.votedTickets[ticketHash]
+		_, isMissed := vw.missedTickets[ticketHash]
+		if voted || isMissed || ticket.revoked {
+			continue
+		}
+		if !strings.Contains(err.Error(), ticketHash.String()) {
+			t.Errorf("live ticket %s not reported as not eligible",
+				ticketHash)
+		}
+	}
+	vw.mtx.Unlock()
+}
+
+// testTotalSubsidyEarned tests that the wallet accounts for the stakebase
+// subsidy of every vote it casts.
+func testTotalSubsidyEarned(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// The first votes are cast on the block before SVH.
+	preSVH := vw.hn.ActiveNet.StakeValidationHeight - 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, preSVH); err != nil {
+		t.Fatal(err)
+	}
+	if earned := vw.TotalSubsidyEarned(); earned != 0 {
+		t.Fatalf("unexpected subsidy earned before voting: %v", earned)
+	}
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 4
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every vote earns the stakebase subsidy of the block it votes on.
+	const isSubsidySplitEnabled = true
+	var want dcrutil.Amount
+	vw.mtx.Lock()
+	nbVotes := len(vw.votedTickets)
+	for _, record := range vw.votedTickets {
+		want += dcrutil.Amount(vw.subsidyCache.CalcStakeVoteSubsidyV2(
+			record.blockHeight, isSubsidySplitEnabled))
+	}
+	vw.mtx.Unlock()
+	if nbVotes == 0 {
+		t.Fatal("wallet did not cast any votes")
+	}
+	if got := vw.TotalSubsidyEarned(); got != want {
+		t.Fatalf("unexpected subsidy earned by %d votes; got %v, want %v",
+			nbVotes, got, want)
+	}
+}
+
+// testNotificationBufferLen tests that a wallet created with a custom
+// notification buffer length handles every notification of bursts of blocks
+// that overflow its buffers.
+func testNotificationBufferLen(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	_, err := NewVotingWallet(ctx, vw.hn, WithNotificationBufferLen(0))
+	if err == nil {
+		t.Fatal("unexpected success creating wallet with an empty " +
+			"notification buffer")
+	}
+
+	const bufferLen = 1
+	vw = replaceWallet(ctx, t, vw, nil, WithNotificationBufferLen(bufferLen))
+	if got := cap(vw.blockConnectedNtfnChan); got != bufferLen {
+		t.Fatalf("unexpected notification buffer length %d", got)
+	}
+
+	// Generate a burst of blocks without waiting for the wallet, which
+	// blocks the notifications until the wallet handles the queued ones.
+	_, height, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const burst = 5
+	if _, err := vw.hn.Node.Generate(ctx, burst); err != nil {
+		t.Fatalf("unable to generate blocks: %v", err)
+	}
+	err = waitPredicate(func() bool {
+		connected, _ := vw.ConnectedHeight()
+		return connected == height+burst
+	}, 10*time.Second)
+	if err != nil {
+		connected, _ := vw.ConnectedHeight()
+		t.Fatalf("wallet connected height %d instead of %d", connected,
+			height+burst)
+	}
+
+	// The wallet keeps the chain going past SVH.
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testNotificationBacklog tests that the wallet exposes the backlog of its
+// notifications and reports when it reaches the high-water mark.
+func testNotificationBacklog(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	vw = replaceWallet(ctx, t, vw, nil, WithNotificationBufferLen(8))
+	var reportsMtx sync.Mutex
+	var reports []error
+	vw.SetErrorReporting(func(err error) {
+		reportsMtx.Lock()
+		reports = append(reports, err)
+		reportsMtx.Unlock()
+	})
+	const highWater = 3
+	vw.SetNotificationBacklogWarning(highWater)
+
+	// Stall the notification handler by holding the wallet mutex, which it
+	// acquires when handling a block, while generating blocks.
+	const nbBlocks = highWater + 2
+	vw.mtx.Lock()
+	_, err := vw.hn.Node.Generate(ctx, nbBlocks)
+	if err == nil {
+		// The handler holds the first notification, so the notification
+		// of the last block is queued after highWater others.
+		err = waitPredicate(func() bool {
+			blockConnected, _ := vw.NotificationBacklog()
+			return blockConnected == nbBlocks-1
+		}, 5*time.Second)
+	}
+	// The backlog is reported by the stalled handler, so it is not reported
+	// until the handler resumes.
+	reportsMtx.Lock()
+	nbStalledReports := len(reports)
+	reportsMtx.Unlock()
+	vw.mtx.Unlock()
+	if err != nil {
+		t.Fatalf("notifications did not back up: %v", err)
+	}
+	if nbStalledReports != 0 {
+		t.Fatalf("backlog reported while the handler was stalled")
+	}
+
+	// The backlog clears and is reported once the handler resumes.
+	err = waitPredicate(func() bool {
+		blockConnected, winningTickets := vw.NotificationBacklog()
+		reportsMtx.Lock()
+		defer reportsMtx.Unlock()
+		return blockConnected == 0 && winningTickets == 0 &&
+			len(reports) != 0
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("notification backlog did not clear: %v", err)
+	}
+	reportsMtx.Lock()
+	defer reportsMtx.Unlock()
+	if len(reports) != 1 {
+		t.Fatalf("unexpected backlog reports: %v", reports)
+	}
+	if !errors.Is(reports[0], ErrNotificationBacklog) {
+		t.Fatalf("unexpected backlog report: %v", reports[0])
+	}
+}
+
+// testSnapshotRestore tests that a snapshot of the wallet state is unaffected
+// by the wallet and restores its utxos, tickets and maturing outputs.
+func testSnapshotRestore(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+	liveTickets := vw.LiveTicketCount()
+	balance, nbUtxos := vw.SpendableBalance()
+	snapshot := vw.Snapshot()
+
+	// The wallet keeps changing its state after the snapshot.
+	if _, err := vw.GenerateBlocks(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	vw.mtx.Lock()
+	changed := !reflect.DeepEqual(vw.utxos, snapshot.utxos) ||
+		!reflect.DeepEqual(vw.tickets, snapshot.tickets)
+	vw.mtx.Unlock()
+	if !changed {
+		t.Fatal("wallet state did not change after generating blocks")
+	}
+
+	vw.Restore(snapshot)
+	if got := vw.LiveTicketCount(); got != liveTickets {
+		t.Fatalf("unexpected live tickets after restore; got %d, want %d",
+			got, liveTickets)
+	}
+	gotBalance, gotUtxos := vw.SpendableBalance()
+	if gotBalance != balance || gotUtxos != nbUtxos {
+		t.Fatalf("unexpected balance after restore; got %v in %d utxos, "+
+			"want %v in %d utxos", gotBalance, gotUtxos, balance, nbUtxos)
+	}
+	vw.mtx.Lock()
+	restored := WalletState{
+		utxos:         vw.utxos,
+		tickets:       vw.tickets,
+		maturingVotes: vw.maturingVotes,
+	}
+	equal := reflect.DeepEqual(restored, snapshot)
+
+	// Changes to the restored state do not affect the snapshot.
+	vw.utxos = vw.utxos[:0]
+	for _, utxos := range vw.maturingVotes {
+		for i := range utxos {
+			utxos[i].amount = 0
+		}
+	}
+	vw.mtx.Unlock()
+	if !equal {
+		t.Fatal("restored state does not match the snapshot")
+	}
+	if len(snapshot.utxos) != nbUtxos {
+		t.Fatal("snapshot changed by the restored wallet")
+	}
+	for height, utxos := range snapshot.maturingVotes {
+		if len(utxos) != 0 && utxos[0].amount == 0 {
+			t.Fatalf("maturing outputs at height %d changed by the "+
+				"restored wallet", height)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent access.
+type syncBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+// testLogger tests that the wallet logs its actions with the logger it is set
+// with.
+func testLogger(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	var buf syncBuffer
+	logger := slog.NewBackend(&buf).Logger("VWLT")
+	logger.SetLevel(slog.LevelDebug)
+	vw = replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		w.SetLogger(logger)
+	})
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 1
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+	logs := buf.String()
+	for _, want := range []string{
+		"[DBG] VWLT: Generated block",
+		"[DBG] VWLT: Block",
+		"[INF] VWLT: Purchased",
+		fmt.Sprintf("[INF] VWLT: Cast %d votes",
+			vw.hn.ActiveNet.TicketsPerBlock),
+		fmt.Sprintf("at height %d", targetHeight),
+	} {
+		if !strings.Contains(logs, want) {
+			t.Fatalf("logs do not contain %q:\n%s", want, logs)
+		}
+	}
+}
+
+// testMaturingVoteSchedule tests that the wallet reports the outputs of its
+// votes that are maturing.
+func testMaturingVoteSchedule(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	// The outputs of the votes cast on each block since the one before SVH
+	// mature CoinbaseMaturity blocks later.
+	nbVotes := int(vw.hn.ActiveNet.TicketsPerBlock)
+	coinbaseMaturity := int64(vw.hn.ActiveNet.CoinbaseMaturity)
+	schedule := vw.MaturingVoteSchedule()
+	for height := vw.hn.ActiveNet.StakeValidationHeight - 1; height <= targetHeight; height++ {
+		maturingHeight := height + coinbaseMaturity
+		if got := schedule[maturingHeight]; got != nbVotes {
+			t.Fatalf("unexpected number of outputs maturing at height "+
+				"%d; got %d, want %d", maturingHeight, got, nbVotes)
+		}
+	}
+	for height, n := range schedule {
+		if got := vw.MaturingVotesAt(height); got != n {
+			t.Fatalf("unexpected number of outputs maturing at height "+
+				"%d; got %d, want %d", height, got, n)
+		}
+	}
+
+	// The schedule is a copy.
+	for height := range schedule {
+		schedule[height] = 0
+	}
+	for height, n := range vw.MaturingVoteSchedule() {
+		if n == 0 {
+			t.Fatalf("schedule at height %d changed by the caller",
+				height)
+		}
+	}
+	if got := vw.MaturingVotesAt(targetHeight); got != 0 {
+		t.Fatalf("unexpected outputs maturing at height %d: %d",
+			targetHeight, got)
+	}
+}
+
+// testWaitForSync tests that the wallet syncs to the best block and detects
+// when it was started too late to keep the chain going past SVH.
+func testWaitForSync(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	if err := vw.WaitForSync(ctx); err != nil {
+		t.Fatalf("unexpected error syncing wallet: %v", err)
+	}
+
+	// Blocks generated without the wallet are synced.
+	if _, err := vw.hn.Node.Generate(ctx, 2); err != nil {
+		t.Fatalf("unable to generate blocks: %v", err)
+	}
+	if err := vw.WaitForSync(ctx); err != nil {
+		t.Fatalf("unexpected error syncing wallet: %v", err)
+	}
+	_, bestHeight, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height, _ := vw.ConnectedHeight(); height != bestHeight {
+		t.Fatalf("wallet synced to height %d instead of %d", height,
+			bestHeight)
+	}
+
+	// A wallet started after the ticket purchase start height is reported.
+	purchaseStart := ticketPurchaseStartHeight(vw.hn.ActiveNet)
+	if _, err := vw.GenerateBlocksToHeight(ctx, purchaseStart+1); err != nil {
+		t.Fatal(err)
+	}
+	late := replaceWallet(ctx, t, vw, nil)
+	if err := late.WaitForSync(ctx); !errors.Is(err, ErrStartedLate) {
+		t.Fatalf("unexpected error syncing late wallet: %v", err)
+	}
+
+	// Unless it shares the ticket pool with other wallets.
+	shared := replaceWallet(ctx, t, late, func(w *VotingWallet) {
+		w.SetSharedTicketPool(true)
+	})
+	if err := shared.WaitForSync(ctx); err != nil {
+		t.Fatalf("unexpected error syncing shared wallet: %v", err)
+	}
+}
+
+// testStartFromScan tests that a wallet started from a scan of the chain picks
+// up the outputs and live tickets of a stopped wallet with the same key and
+// keeps the chain going.
+func testStartFromScan(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 4
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+	if err := vw.Stop(); err != nil {
+		t.Fatalf("unable to stop wallet: %v", err)
+	}
+
+	// The live tickets of the stopped wallet are the ones that did not vote
+	// yet or whose votes are still in the mempool.
+	vw.mtx.Lock()
+	wantUtxos := append([]utxoInfo(nil), vw.utxos...)
+	wantTickets := make(map[chainhash.Hash]ticketInfo)
+	for hash, ticket := range vw.tickets {
+		vote, voted := vw.votedTickets[hash]
+		if !voted || vote.blockHeight == targetHeight {
+			wantTickets[hash] = ticket
+		}
+	}
+	vw.mtx.Unlock()
+	wantSchedule := vw.MaturingVoteSchedule()
+
+	w, err := NewVotingWallet(ctx, vw.hn)
+	if err != nil {
+		t.Fatalf("unable to create wallet: %v", err)
+	}
+	t.Cleanup(func() { w.Stop() })
+	w.SetErrorReporting(func(err error) {
+		t.Errorf("voting wallet errored: %v", err)
+	})
+	w.SetMiner(vw.miner)
+	if err := w.StartFromScan(ctx); err != nil {
+		t.Fatalf("unable to start wallet from scan: %v", err)
+	}
+
+	w.mtx.Lock()
+	gotUtxos := append([]utxoInfo(nil), w.utxos...)
+	gotTickets := make(map[chainhash.Hash]ticketInfo, len(w.tickets))
+	for hash, ticket := range w.tickets {
+		gotTickets[hash] = ticket
+	}
+	w.mtx.Unlock()
+	sortUtxosByOutpoint(wantUtxos)
+	sortUtxosByOutpoint(gotUtxos)
+	if !reflect.DeepEqual(gotUtxos, wantUtxos) {
+		t.Fatalf("unexpected scanned utxos; got %v, want %v", gotUtxos,
+			wantUtxos)
+	}
+	if !reflect.DeepEqual(gotTickets, wantTickets) {
+		t.Fatalf("unexpected scanned tickets; got %d, want %d",
+			len(gotTickets), len(wantTickets))
+	}
+	if got := w.MaturingVoteSchedule(); !reflect.DeepEqual(got, wantSchedule) {
+		t.Fatalf("unexpected scanned maturing schedule; got %v, want %v",
+			got, wantSchedule)
+	}
+
+	// The scanned wallet keeps voting and purchasing tickets.
+	if _, err := w.GenerateBlocks(ctx, 4); err != nil {
+		t.Fatalf("unable to generate blocks with scanned wallet: %v", err)
+	}
+	if err := w.AssertFullParticipation(targetHeight+1, targetHeight+4); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testStakeBaseSigScript tests that the votes of the wallet use the stakebase
+// script of the network by default and that the network rejects votes with a
+// custom stakebase script.
+func testStakeBaseSigScript(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+	_, votes, _, err := vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(votes) == 0 {
+		t.Fatal("no votes cast")
+	}
+	vote, err := vw.hn.Node.GetRawTransaction(ctx, votes[0])
+	if err != nil {
+		t.Fatalf("unable to get vote %s: %v", votes[0], err)
+	}
+	sigScript := vote.MsgTx().TxIn[0].SignatureScript
+	if !bytes.Equal(sigScript, vw.hn.ActiveNet.StakeBaseSigScript) {
+		t.Fatalf("unexpected stakebase script of vote %s; got %x, want %x",
+			votes[0], sigScript, vw.hn.ActiveNet.StakeBaseSigScript)
+	}
+
+	// Votes with a different stakebase script are rejected.
+	errs := make(chan error, 1)
+	vw.SetErrorReporting(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	vw.SetStakeBaseSigScript([]byte{0xde, 0xad, 0xbe, 0xef})
+	if _, err := vw.miner(ctx, 1); err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrBroadcastFailed) {
+			t.Fatalf("wallet error %v is not of kind %v", err,
+				ErrBroadcastFailed)
+		}
+		if !strings.Contains(err.Error(), "stakebase") {
+			t.Fatalf("unexpected wallet error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("vote with invalid stakebase was not rejected")
+	}
+	mempoolVotes, err := vw.hn.Node.GetRawMempool(ctx, dcrdtypes.GRMVotes)
+	if err != nil {
+		t.Fatalf("unable to get mempool votes: %v", err)
+	}
+	if len(mempoolVotes) != 0 {
+		t.Fatalf("unexpected votes in mempool: %v", mempoolVotes)
+	}
+}
+
+// testReadyAtSVH tests that the ready channel of the wallet is closed once the
+// first block at SVH that includes its votes is connected, and not before.
+func testReadyAtSVH(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	svh := vw.hn.ActiveNet.StakeValidationHeight
+	if _, err := vw.GenerateBlocksToHeight(ctx, svh-1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-vw.ReadyAtSVH():
+		t.Fatalf("wallet ready before SVH")
+	default:
+	}
+
+	if _, err := vw.GenerateBlocks(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-vw.ReadyAtSVH():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wallet not ready at SVH")
+	}
+	vw.mtx.Lock()
+	votes := vw.participation[svh].votes
+	vw.mtx.Unlock()
+	if votes == 0 {
+		t.Fatalf("wallet ready without votes in block %d", svh)
+	}
+}
+
+// testGenerateBlocksCancel tests that generating blocks with a context that is
+// cancelled partway through returns the blocks generated so far along with the
+// error of the context.
+func testGenerateBlocksCancel(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	_, startHeight, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancel the context right after the third block is generated.
+	const nbBeforeCancel = 3
+	cancelCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	miner := vw.miner
+	if miner == nil {
+		miner = vw.hn.Node.Generate
+	}
+	var generated int
+	vw.SetMiner(func(ctx context.Context, nb uint32) ([]*chainhash.Hash, error) {
+		hashes, err := miner(ctx, nb)
+		generated += len(hashes)
+		if generated >= nbBeforeCancel {
+			cancel()
+		}
+		return hashes, err
+	})
+	hashes, err := vw.GenerateBlocks(cancelCtx, 20)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error generating blocks: %v", err)
+	}
+	if len(hashes) != nbBeforeCancel {
+		t.Fatalf("unexpected number of generated blocks; got %d, want %d",
+			len(hashes), nbBeforeCancel)
+	}
+	for i, hash := range hashes {
+		height := startHeight + int64(i) + 1
+		want, err := vw.hn.Node.GetBlockHash(ctx, height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *hash != *want {
+			t.Fatalf("unexpected block at height %d; got %s, want %s",
+				height, hash, want)
+		}
+	}
+}
+
+// testBlockValidity tests that the votes of the wallet disapprove the block
+// being voted on when requested, such that the network disregards its regular
+// transaction tree, and approve it again afterwards.
+func testBlockValidity(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	// checkBlock ensures the votes of the wallet in the given block and its
+	// header cast the given block validity.
+	checkBlock := func(hash *chainhash.Hash, votes []*chainhash.Hash, valid bool) {
+		t.Helper()
+		if len(votes) == 0 {
+			t.Fatalf("no votes of the wallet in block %s", hash)
+		}
+		for _, voteHash := range votes {
+			vote, err := vw.hn.Node.GetRawTransaction(ctx, voteHash)
+			if err != nil {
+				t.Fatalf("unable to get vote %s: %v", voteHash, err)
+			}
+			if err := stake.CheckSSGen(vote.MsgTx()); err != nil {
+				t.Fatalf("vote %s is not valid: %v", voteHash, err)
+			}
+			bits := stake.SSGenVoteBits(vote.MsgTx())
+			if got := bits&voteBitsBlockValid != 0; got != valid {
+				t.Fatalf("unexpected block validity of vote %s; got "+
+					"%v, want %v", voteHash, got, valid)
+			}
+		}
+		header, err := vw.hn.Node.GetBlockHeader(ctx, hash)
+		if err != nil {
+			t.Fatalf("unable to get header of block %s: %v", hash, err)
+		}
+		if got := header.VoteBits&voteBitsBlockValid != 0; got != valid {
+			t.Fatalf("unexpected block validity of block %s; got %v, "+
+				"want %v", hash, got, valid)
+		}
+	}
+
+	// The votes cast after changing the validity are included in the block
+	// after the next one.
+	if err := vw.SetBlockValidity(false); err != nil {
+		t.Fatalf("unable to disapprove blocks: %v", err)
+	}
+	if _, err := vw.GenerateBlocks(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	hash, votes, _, err := vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBlock(hash, votes, false)
+
+	if err := vw.SetBlockValidity(true); err != nil {
+		t.Fatalf("unable to approve blocks: %v", err)
+	}
+	if _, err := vw.GenerateBlocks(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	hash, votes, _, err = vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBlock(hash, votes, true)
+}
+
+// testInitialFundingOutputs tests that a wallet is funded with the configured
+// number of outputs when started and that invalid numbers are rejected.
+func testInitialFundingOutputs(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	required := requiredTicketCount(vw.hn.ActiveNet)
+	if err := vw.SetInitialFundingOutputs(required - 1); err == nil {
+		t.Fatalf("unexpected success funding fewer outputs than required")
+	}
+
+	// Starting fails when the harness cannot fund the outputs.
+	value := vw.hn.ActiveNet.MinimumStakeDiff * vw.commitAmountMultiplier
+	tooMany := int(int64(vw.hn.ConfirmedBalance())/value) + 1
+	poor, err := NewVotingWallet(ctx, vw.hn)
+	if err != nil {
+		t.Fatalf("unable to create wallet: %v", err)
+	}
+	defer poor.Stop()
+	if err := poor.SetInitialFundingOutputs(tooMany); err != nil {
+		t.Fatalf("unable to set initial funding outputs: %v", err)
+	}
+	if err := poor.Start(ctx); err == nil {
+		t.Fatalf("unexpected success funding %d outputs", tooMany)
+	}
+
+	nbOutputs := required + 2*int(vw.hn.ActiveNet.TicketsPerBlock)
+	w := replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		if err := w.SetInitialFundingOutputs(nbOutputs); err != nil {
+			t.Fatalf("unable to set initial funding outputs: %v", err)
+		}
+	})
+	if _, n := w.SpendableBalance(); n != nbOutputs {
+		t.Fatalf("unexpected number of funded outputs; got %d, want %d",
+			n, nbOutputs)
+	}
+	targetHeight := w.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := w.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testMetrics tests that the metrics of the wallet count its published stake
+// transactions and the ones rejected by the network.
+func testMetrics(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	if m := vw.Metrics(); m != (WalletMetrics{}) {
+		t.Fatalf("unexpected metrics before generating blocks: %+v", m)
+	}
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+	vw.mtx.Lock()
+	nbVotes := len(vw.votedTickets)
+	vw.mtx.Unlock()
+	want := WalletMetrics{
+		TicketsPurchased: uint64(vw.LiveTicketCount()),
+		VotesCast:        uint64(nbVotes),
+		SubsidyEarned:    vw.TotalSubsidyEarned(),
+	}
+	if m := vw.Metrics(); m != want {
+		t.Fatalf("unexpected metrics; got %+v, want %+v", m, want)
+	}
+	if want.SubsidyEarned == 0 {
+		t.Fatalf("no subsidy earned by %d votes", nbVotes)
+	}
+
+	// Votes rejected by the network are counted as broadcast errors.
+	vw.SetErrorReporting(func(err error) {})
+	vw.SetStakeBaseSigScript([]byte{0xde, 0xad, 0xbe, 0xef})
+	if _, err := vw.miner(ctx, 1); err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	deadline := time.After(5 * time.Second)
+	for vw.Metrics().BroadcastErrors == 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("rejected votes not counted")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if m := vw.Metrics(); m.VotesCast != want.VotesCast {
+		t.Fatalf("rejected votes counted as cast; got %d, want %d",
+			m.VotesCast, want.VotesCast)
+	}
+}
+
+// testTicketPriceStrategy tests that the wallet purchases tickets at the padded
+// price by default and that tickets rejected due to their price do not stop a
+// wallet that fails fast.
+func testTicketPriceStrategy(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	purchaseStart := ticketPurchaseStartHeight(vw.hn.ActiveNet)
+	if _, err := vw.GenerateBlocksToHeight(ctx, purchaseStart+2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := vw.GenerateBlocks(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	sdiff, err := vw.hn.Node.GetStakeDifficulty(ctx)
+	if err != nil {
+		t.Fatalf("unable to get stake difficulty: %v", err)
+	}
+	nextStakeDiff, err := dcrutil.NewAmount(sdiff.NextStakeDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPrice := TicketPricePadded(nil, int64(nextStakeDiff))
+	_, tickets := vw.PendingTxHashes()
+	if len(tickets) == 0 {
+		t.Fatal("no tickets purchased")
+	}
+	for _, hash := range tickets {
+		ticket, err := vw.hn.Node.GetRawTransaction(ctx, hash)
+		if err != nil {
+			t.Fatalf("unable to get ticket %s: %v", hash, err)
+		}
+		if price := ticket.MsgTx().TxOut[0].Value; price != wantPrice {
+			t.Fatalf("unexpected price of ticket %s; got %d, want %d",
+				hash, price, wantPrice)
+		}
+	}
+
+	// Tickets priced below the stake difficulty are reported without
+	// stopping the wallet.
+	w := replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		w.SetFailFast(true)
+		w.SetTicketPriceStrategy(func(header *wire.BlockHeader, nextStakeDiff int64) int64 {
+			return nextStakeDiff - 1
+		})
+	})
+	errs := make(chan error, 1)
+	w.SetErrorReporting(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	if _, err := w.miner(ctx, 1); err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrTicketPriceRejected) {
+			t.Fatalf("unexpected wallet error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("underpriced ticket was not rejected")
+	}
+	if err := w.failure(); err != nil {
+		t.Fatalf("wallet stopped by rejected ticket: %v", err)
+	}
+}
+
+// testLimitVotesByFraction tests that limiting the votes of the wallet by a
+// fraction of TicketsPerBlock limits the number of votes of the blocks.
+func testLimitVotesByFraction(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	for _, f := range []float64{-0.1, 1.5, math.NaN()} {
+		if err := vw.LimitVotesByFraction(f); err == nil {
+			t.Fatalf("unexpected success limiting votes by fraction %v", f)
+		}
+	}
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	// The votes for the current tip have already been cast, so the limit
+	// is only observed in the second generated block.
+	const fraction = 0.6
+	wantVotes := int(math.Round(fraction * float64(vw.hn.ActiveNet.TicketsPerBlock)))
+	if err := vw.LimitVotesByFraction(fraction); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := vw.GenerateBlocksWithStats(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(stats.Blocks[1].Votes); got != wantVotes {
+		t.Fatalf("unexpected number of votes in block %d; got %d, want %d",
+			stats.Blocks[1].Height, got, wantVotes)
+	}
+
+	// Participation may be increased again.
+	if err := vw.LimitVotesByFraction(fraction + 0.2); err != nil {
+		t.Fatalf("unable to increase the vote fraction: %v", err)
+	}
+}
+
+// testIncreaseVoteLimit tests that the wallet keeps purchasing a full block of
+// tickets after the number of votes is limited long enough to deplete it and
+// the limit is then increased again.
+func testIncreaseVoteLimit(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+	nbTickets := int(net.TicketsPerBlock)
+	if err := vw.LimitNbVotes(nbTickets + 1); err == nil {
+		t.Fatalf("unexpected success limiting votes above tickets per block")
+	}
+
+	targetHeight := net.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+	if err := vw.LimitNbVotes(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Generate blocks until the outputs of the wallet no longer cover
+	// purchasing tickets until the votes cast after increasing the limit
+	// mature.
+	for i := 0; ; i++ {
+		if i == 2*int(net.CoinbaseMaturity) {
+			t.Fatalf("wallet was not depleted by limiting votes")
+		}
+		if _, err := vw.GenerateBlocks(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		_, tipHeight, err := vw.hn.Node.GetBestBlock(ctx)
+		if err != nil {
+			t.Fatalf("unable to obtain best block: %v", err)
+		}
+		maxHeight, err := vw.MaxSustainableHeight(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if maxHeight < tipHeight+1+int64(net.CoinbaseMaturity) {
+			break
+		}
+	}
+
+	if err := vw.LimitNbVotes(nbTickets); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := vw.GenerateBlocksWithStats(ctx,
+		uint32(net.CoinbaseMaturity)+4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range stats.Blocks {
+		if len(block.Tickets) != nbTickets {
+			t.Fatalf("block %d includes %d tickets, want %d",
+				block.Height, len(block.Tickets), nbTickets)
+		}
+	}
+	last := stats.Blocks[len(stats.Blocks)-1]
+	if len(last.Votes) != nbTickets {
+		t.Fatalf("unexpected number of votes in block %d; got %d, want %d",
+			last.Height, len(last.Votes), nbTickets)
+	}
+}
+
+// testGenerateBlock tests that GenerateBlock returns the header and the stake
+// transactions of the wallet included in the generated block.
+func testGenerateBlock(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+	targetHeight := net.StakeValidationHeight
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := vw.GenerateBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bestHash, bestHeight, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatalf("unable to obtain best block: %v", err)
+	}
+	if *res.Hash != *bestHash || res.Height != bestHeight {
+		t.Fatalf("unexpected block; got %s (%d), want %s (%d)", res.Hash,
+			res.Height, bestHash, bestHeight)
+	}
+	if res.Header.BlockHash() != *res.Hash {
+		t.Fatalf("header hash %s does not match block hash %s",
+			res.Header.BlockHash(), res.Hash)
+	}
+	if int64(res.Header.Height) != res.Height {
+		t.Fatalf("unexpected header height; got %d, want %d",
+			res.Header.Height, res.Height)
+	}
+	if got := len(res.Votes); got != int(net.TicketsPerBlock) {
+		t.Fatalf("unexpected number of votes; got %d, want %d", got,
+			net.TicketsPerBlock)
+	}
+	if got := len(res.Tickets); got != int(net.TicketsPerBlock) {
+		t.Fatalf("unexpected number of tickets; got %d, want %d", got,
+			net.TicketsPerBlock)
+	}
+	if res.Header.Voters != uint16(len(res.Votes)) {
+		t.Fatalf("unexpected number of voters in header; got %d, want %d",
+			res.Header.Voters, len(res.Votes))
+	}
+}
+
+// testBatchMiner tests that the wallet generates blocks in batches with a batch
+// miner while still voting on every block.
+func testBatchMiner(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	const batchSize = 4
+	var calls int
+	miner := vw.miner
+	vw.SetBatchMiner(batchSize, func(ctx context.Context, nb uint32) ([]*chainhash.Hash, error) {
+		calls++
+		if nb > batchSize {
+			return nil, fmt.Errorf("unexpected batch of %d blocks", nb)
+		}
+		return miner(ctx, nb)
+	})
+
+	net := vw.hn.ActiveNet
+	_, startHeight, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatalf("unable to obtain best block: %v", err)
+	}
+	targetHeight := net.StakeValidationHeight + 10
+	nb := uint32(targetHeight - startHeight)
+	stats, err := vw.GenerateBlocksWithStats(ctx, nb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Blocks) != int(nb) {
+		t.Fatalf("unexpected number of generated blocks; got %d, want %d",
+			len(stats.Blocks), nb)
+	}
+	if wantCalls := int(nb+batchSize-1) / batchSize; calls != wantCalls {
+		t.Fatalf("unexpected number of miner calls; got %d, want %d", calls,
+			wantCalls)
+	}
+	for i, block := range stats.Blocks {
+		if want := startHeight + 1 + int64(i); block.Height != want {
+			t.Fatalf("unexpected height of block %d; got %d, want %d", i,
+				block.Height, want)
+		}
+		if block.Height < net.StakeValidationHeight {
+			continue
+		}
+		if len(block.Votes) != int(net.TicketsPerBlock) {
+			t.Fatalf("block %d includes %d votes, want %d", block.Height,
+				len(block.Votes), net.TicketsPerBlock)
+		}
+	}
+
+	// Mining one block at a time is restored with a batch size of one.
+	calls = 0
+	vw.SetBatchMiner(1, nil)
+	if _, err := vw.GenerateBlocks(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("unexpected number of miner calls; got %d, want 2", calls)
+	}
+}
+
+// testSpentFundingUtxo tests that the wallet drops the utxos spent outside of
+// it instead of failing to purchase tickets with them.
+func testSpentFundingUtxo(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+	targetHeight := ticketPurchaseStartHeight(net)
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	// Add an output unknown to the node as the most recent utxo, which is
+	// the first one selected to fund a ticket, as if another party spent
+	// it.
+	spent := wire.OutPoint{Hash: chainhash.Hash{0x01}, Index: 0}
+	vw.mtx.Lock()
+	vw.utxos = append(vw.utxos, utxoInfo{
+		outpoint: spent,
+		amount:   vw.utxos[len(vw.utxos)-1].amount,
+	})
+	vw.mtx.Unlock()
+
+	stats, err := vw.GenerateBlocksWithStats(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(stats.Blocks[0].Tickets); got != int(net.TicketsPerBlock) {
+		t.Fatalf("unexpected number of tickets; got %d, want %d", got,
+			net.TicketsPerBlock)
+	}
+	vw.mtx.Lock()
+	defer vw.mtx.Unlock()
+	for _, utxo := range vw.utxos {
+		if utxo.outpoint == spent {
+			t.Fatalf("spent output %v was not dropped", spent)
+		}
+	}
+}
+
+// testPrivateKey tests that the private key returned by the wallet is the one
+// that controls its address.
+func testPrivateKey(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	key := vw.PrivateKey()
+	if !bytes.Equal(key.Serialize(), hardcodedPrivateKey) {
+		t.Fatalf("unexpected private key; got %x, want %x",
+			key.Serialize(), hardcodedPrivateKey)
+	}
+	pkHash := stdaddr.Hash160(key.PubKey().SerializeCompressed())
+	addr, err := stdaddr.NewAddressPubKeyHashEcdsaSecp256k1V0(pkHash,
+		vw.hn.ActiveNet)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+	if addr.String() != vw.Address().String() {
+		t.Fatalf("private key does not control the wallet address; got "+
+			"%v, want %v", addr, vw.Address())
+	}
+
+	// The returned key is a copy.
+	key.Zero()
+	if got := vw.PrivateKey().Serialize(); !bytes.Equal(got, hardcodedPrivateKey) {
+		t.Fatal("modifying the returned key modified the wallet")
+	}
+}
+
+// testExtraVoteOutput tests that the votes of the wallet carry the extra output
+// when it is valid and that invalid votes are not published.
+func testExtraVoteOutput(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	if err := vw.SetExtraVoteOutput([]byte{txscript.OP_TRUE}); err == nil {
+		t.Fatal("unexpected success setting an extra output that is not " +
+			"null data")
+	}
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	// A treasury vote is the only valid extra output.
+	tspendHash := chainhash.Hash{0x01}
+	data := append([]byte{'T', 'V'}, tspendHash[:]...)
+	data = append(data, byte(stake.TreasuryVoteYes))
+	script, err := txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).
+		AddData(data).Script()
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	if err := vw.SetExtraVoteOutput(script); err != nil {
+		t.Fatal(err)
+	}
+
+	// The votes for the current tip have already been cast, so the extra
+	// output is only included in the votes of the second generated block.
+	stats, err := vw.GenerateBlocksWithStats(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	votes := stats.Blocks[1].Votes
+	if len(votes) == 0 {
+		t.Fatal("no votes cast")
+	}
+	vote, err := vw.hn.Node.GetRawTransaction(ctx, votes[0])
+	if err != nil {
+		t.Fatalf("unable to get vote %s: %v", votes[0], err)
+	}
+	txOuts := vote.MsgTx().TxOut
+	if got := txOuts[len(txOuts)-1].PkScript; !bytes.Equal(got, script) {
+		t.Fatalf("unexpected last output of vote %s; got %x, want %x",
+			votes[0], got, script)
+	}
+
+	// Votes with other data are invalid and not published.
+	errs := make(chan error, 1)
+	vw.SetErrorReporting(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	script, err = txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).
+		AddData([]byte("extra data")).Script()
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	if err := vw.SetExtraVoteOutput(script); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vw.miner(ctx, 1); err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrTxCreationFailed) {
+			t.Fatalf("wallet error %v is not of kind %v", err,
+				ErrTxCreationFailed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("invalid vote was not detected")
+	}
+}
+
+// testGenerateBlocksUntilMined tests that blocks are generated until a given
+// transaction is mined.
+func testGenerateBlocksUntilMined(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := ticketPurchaseStartHeight(vw.hn.ActiveNet) + 1
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	mempoolTickets, err := vw.hn.Node.GetRawMempool(ctx, dcrdtypes.GRMTickets)
+	if err != nil {
+		t.Fatalf("unable to get mempool tickets: %v", err)
+	}
+	if len(mempoolTickets) == 0 {
+		t.Fatalf("no tickets in the mempool")
+	}
+	if _, err := vw.GenerateBlocksUntilMined(ctx, mempoolTickets[0], 0); err == nil {
+		t.Fatal("unexpected success with no blocks allowed")
+	}
+	hashes, err := vw.GenerateBlocksUntilMined(ctx, mempoolTickets[0], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 1 {
+		t.Fatalf("unexpected number of generated blocks; got %d, want 1",
+			len(hashes))
+	}
+	res, err := vw.hn.Node.GetRawTransactionVerbose(ctx, mempoolTickets[0])
+	if err != nil {
+		t.Fatalf("unable to get ticket: %v", err)
+	}
+	if res.BlockHash != hashes[0].String() {
+		t.Fatalf("ticket mined in block %s, want %s", res.BlockHash,
+			hashes[0])
+	}
+
+	// Transactions unknown to the node are never mined.
+	hashes, err = vw.GenerateBlocksUntilMined(ctx, &chainhash.Hash{0x01}, 3)
+	if err == nil {
+		t.Fatal("unexpected success waiting for an unknown tx")
+	}
+	if len(hashes) != 1 {
+		t.Fatalf("unexpected number of generated blocks; got %d, want 1",
+			len(hashes))
+	}
+}
+
+// testVoteSelection tests that the wallet votes with random winning tickets
+// when the number of votes is limited and random selection is set.
+func testVoteSelection(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	if err := vw.SetVoteSelection(VoteSelection(-1)); err == nil {
+		t.Fatal("unexpected success setting an unknown vote selection")
+	}
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+	const nbVotes = 3
+	if err := vw.LimitNbVotes(nbVotes); err != nil {
+		t.Fatal(err)
+	}
+	if err := vw.SetVoteSelection(VoteSelectionRandom); err != nil {
+		t.Fatal(err)
+	}
+
+	// The votes for the current tip have already been cast, so the random
+	// selection is only observed from the second generated block.
+	const nbBlocks = 8
+	stats, err := vw.GenerateBlocksWithStats(ctx, nbBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wins := make(map[chainhash.Hash][]chainhash.Hash)
+	for _, rec := range vw.RecentWins(0) {
+		wins[rec.BlockHash] = rec.Tickets
+	}
+	var notFirst int
+	for i := 1; i < nbBlocks; i++ {
+		winners, ok := wins[*stats.Blocks[i-1].Hash]
+		if !ok || len(winners) <= nbVotes {
+			t.Fatalf("no tickets to select from for block %d",
+				stats.Blocks[i-1].Height)
+		}
+		first := make(map[chainhash.Hash]bool)
+		for _, ticket := range winners[:nbVotes] {
+			first[ticket] = true
+		}
+		for _, voteHash := range stats.Blocks[i].Votes {
+			vote, err := vw.hn.Node.GetRawTransaction(ctx, voteHash)
+			if err != nil {
+				t.Fatalf("unable to get vote %s: %v", voteHash, err)
+			}
+			if !first[vote.MsgTx().TxIn[1].PreviousOutPoint.Hash] {
+				notFirst++
+				break
+			}
+		}
+	}
+	if notFirst == 0 {
+		t.Fatal("wallet always voted with the first winning tickets")
+	}
+}
+
+// testPauseTicketBuying tests that the wallet keeps voting without purchasing
+// tickets while ticket buying is paused.
+func testPauseTicketBuying(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Purchase twice as many tickets as the ones that vote, such that the
+	// live ticket pool holds enough surplus tickets to keep voting while
+	// ticket buying is paused.
+	net := vw.hn.ActiveNet
+	nbTickets := int(net.TicketsPerBlock) * 2
+	vw = replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		if err := w.SetTicketsPerBlock(nbTickets); err != nil {
+			t.Fatalf("unable to set tickets per block: %v", err)
+		}
+		w.SetAutoRefund(true)
+	})
+	targetHeight := net.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tickets purchased for the current tip are included in the first
+	// generated block.
+	vw.PauseTicketBuying()
+	if !vw.IsTicketBuyingPaused() {
+		t.Fatal("ticket buying is not reported as paused")
+	}
+	stats, err := vw.GenerateBlocksWithStats(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range stats.Blocks[1:] {
+		if len(block.Tickets) != 0 {
+			t.Fatalf("block %d includes %d tickets while paused",
+				block.Height, len(block.Tickets))
+		}
+		if len(block.Votes) != int(net.TicketsPerBlock) {
+			t.Fatalf("block %d includes %d votes, want %d", block.Height,
+				len(block.Votes), net.TicketsPerBlock)
+		}
+	}
+
+	vw.ResumeTicketBuying()
+	if vw.IsTicketBuyingPaused() {
+		t.Fatal("ticket buying is reported as paused after resuming")
+	}
+	stats, err = vw.GenerateBlocksWithStats(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(stats.Blocks[1].Tickets); got != nbTickets {
+		t.Fatalf("unexpected number of tickets after resuming; got %d, "+
+			"want %d", got, nbTickets)
+	}
+}
+
+// testBlockVerifier tests that the block verifier is called with the votes and
+// tickets of every generated block and that its errors stop block generation.
+func testBlockVerifier(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+	_, startHeight, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatalf("unable to obtain best block: %v", err)
+	}
+	var verified []int64
+	vw.SetBlockVerifier(func(height int64, votesInBlock, ticketsInBlock int) error {
+		verified = append(verified, height)
+		if height >= net.StakeValidationHeight &&
+			votesInBlock != int(net.TicketsPerBlock) {
+			return fmt.Errorf("block %d includes %d votes", height,
+				votesInBlock)
+		}
+		if height > ticketPurchaseStartHeight(net) &&
+			ticketsInBlock != int(net.TicketsPerBlock) {
+			return fmt.Errorf("block %d includes %d tickets", height,
+				ticketsInBlock)
+		}
+		return nil
+	})
+	targetHeight := net.StakeValidationHeight + 2
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight); err != nil {
+		t.Fatal(err)
+	}
+	if want := int(targetHeight - startHeight); len(verified) != want {
+		t.Fatalf("unexpected number of verified blocks; got %d, want %d",
+			len(verified), want)
+	}
+	for i, height := range verified {
+		if want := startHeight + 1 + int64(i); height != want {
+			t.Fatalf("unexpected verified height; got %d, want %d",
+				height, want)
+		}
+	}
+
+	// Verification errors stop block generation.
+	errVerify := errors.New("verification failed")
+	var calls int
+	vw.SetBlockVerifier(func(height int64, votesInBlock, ticketsInBlock int) error {
+		calls++
+		return errVerify
+	})
+	if _, err := vw.GenerateBlocks(ctx, 3); !errors.Is(err, errVerify) {
+		t.Fatalf("unexpected error; got %v, want %v", err, errVerify)
+	}
+	if calls != 1 {
+		t.Fatalf("block generation continued after failed verification")
+	}
+
+	vw.SetBlockVerifier(nil)
+	if _, err := vw.GenerateBlocks(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testMultipleTSpendVotes tests that the treasury votes of the wallet vote on
+// the maximum number of tspends that fit in a vote with the individual vote set
+// for each of them.
+func testMultipleTSpendVotes(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make([]stake.TreasuryVoteTuple, 0, MaxTSpendVotes)
+	for i := 0; i < MaxTSpendVotes; i++ {
+		hash := chainhash.Hash{byte(i + 1)}
+		vote := stake.TreasuryVoteYes
+		if i%2 == 1 {
+			vote = stake.TreasuryVoteNo
+		}
+		if err := vw.SetTSpendVote(hash, vote); err != nil {
+			t.Fatalf("unable to vote for treasury spend %s: %v", hash, err)
+		}
+		want = append(want, stake.TreasuryVoteTuple{Hash: hash, Vote: vote})
+	}
+	extraHash := chainhash.Hash{0xff}
+	if err := vw.SetTSpendVote(extraHash, stake.TreasuryVoteYes); err == nil {
+		t.Fatalf("unexpected success voting on more than %d treasury "+
+			"spends", MaxTSpendVotes)
+	}
+	assertNextTreasuryVotes(ctx, t, vw, want)
+
+	// Change the vote on a single tspend and abstain on another one, which
+	// makes room for voting on an additional tspend.
+	want[0].Vote = stake.TreasuryVoteNo
+	if err := vw.SetTSpendVote(want[0].Hash, want[0].Vote); err != nil {
+		t.Fatalf("unable to change treasury spend vote: %v", err)
+	}
+	if err := vw.SetTSpendVote(want[1].Hash, TreasuryVoteAbstain); err != nil {
+		t.Fatalf("unable to abstain on treasury spend: %v", err)
+	}
+	if err := vw.SetTSpendVote(extraHash, stake.TreasuryVoteYes); err != nil {
+		t.Fatalf("unable to vote for treasury spend %s: %v", extraHash,
+			err)
+	}
+	want = append(want[:1], want[2:]...)
+	want = append(want, stake.TreasuryVoteTuple{
+		Hash: extraHash,
+		Vote: stake.TreasuryVoteYes,
+	})
+	assertNextTreasuryVotes(ctx, t, vw, want)
+}
+
+// testSendToAddress tests that the wallet pays amounts to other addresses with
+// regular transactions funded by its utxos and that their change returns to the
+// wallet once mined.
+func testSendToAddress(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Fund an additional block worth of outputs for the sends.
+	vw = replaceWallet(ctx, t, vw, fundExtraOutputs(t,
+		int(vw.hn.ActiveNet.TicketsPerBlock)))
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := vw.hn.NewAddress()
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+
+	if _, err := vw.SendToAddress(ctx, addr, 0); err == nil {
+		t.Fatalf("send without amount was published")
+	}
+	balance, _ := vw.SpendableBalance()
+	if _, err := vw.SendToAddress(ctx, addr, balance+1); err == nil {
+		t.Fatalf("send of more than the balance was published")
+	}
+
+	// Send the amount of the largest utxo of the wallet, which requires
+	// multiple inputs to also pay the fee.
+	var amount int64
+	vw.mtx.Lock()
+	for _, utxo := range vw.utxos {
+		if utxo.amount > amount {
+			amount = utxo.amount
+		}
+	}
+	vw.mtx.Unlock()
+	txHash, err := vw.SendToAddress(ctx, addr, dcrutil.Amount(amount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vw.GenerateBlocksUntilMined(ctx, txHash, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := vw.hn.Node.GetRawTransaction(ctx, txHash)
+	if err != nil {
+		t.Fatalf("unable to get transaction: %v", err)
+	}
+	msgTx := tx.MsgTx()
+	if len(msgTx.TxIn) < 2 {
+		t.Fatalf("transaction has %d inputs, want at least 2",
+			len(msgTx.TxIn))
+	}
+	_, payScript := addr.PaymentScript()
+	payment := msgTx.TxOut[0]
+	if payment.Value != amount || !bytes.Equal(payment.PkScript, payScript) {
+		t.Fatalf("transaction pays %d to script %x, want %d to %x",
+			payment.Value, payment.PkScript, amount, payScript)
+	}
+	if len(msgTx.TxOut) != 2 {
+		t.Fatalf("transaction has %d outputs, want 2", len(msgTx.TxOut))
+	}
+
+	// The change is available for purchasing tickets once mined, unless it
+	// already funded one.
+	changeOutpoint := wire.OutPoint{Hash: *txHash, Index: 1,
+		Tree: wire.TxTreeRegular}
+	vw.mtx.Lock()
+	var found bool
+	for _, utxo := range vw.utxos {
+		found = found || utxo.outpoint == changeOutpoint
+	}
+	for _, ticket := range vw.tickets {
+		found = found || ticket.utxo.outpoint == changeOutpoint
+	}
+	vw.mtx.Unlock()
+	if !found {
+		t.Fatalf("change %v is not available to the wallet", changeOutpoint)
+	}
+}
+
+// testObserverMode tests that a wallet in observer mode accurately tracks the
+// stake activity driven by another wallet, without participating in it.
+func testObserverMode(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	observer, err := NewVotingWallet(ctx, vw.hn)
+	if err != nil {
+		t.Fatalf("unable to create observer wallet: %v", err)
+	}
+	observer.SetObserverMode(true)
+	observer.SetErrorReporting(func(err error) {
+		t.Errorf("observer wallet errored: %v", err)
+	})
+	if err := observer.Start(ctx); err != nil {
+		t.Fatalf("unable to start observer wallet: %v", err)
+	}
+
+	_, startHeight, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatalf("unable to obtain best block: %v", err)
+	}
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 10
+	_, err = vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tally the stake activity of the generated blocks.
+	var want StakeActivity
+	for h := startHeight + 1; h <= targetHeight; h++ {
+		bh, err := vw.hn.Node.GetBlockHash(ctx, h)
+		if err != nil {
+			t.Fatalf("unable to get block hash: %v", err)
+		}
+		header, err := vw.hn.Node.GetBlockHeader(ctx, bh)
+		if err != nil {
+			t.Fatalf("unable to get block header: %v", err)
+		}
+		want.Height = h
+		want.VotesSeen += int64(header.Voters)
+		want.TicketsSeen += int64(header.FreshStake)
+		want.RevocationsSeen += int64(header.Revocations)
+		want.LiveTicketPoolSize = header.PoolSize
+	}
+
+	// Wait for the observer to process the notification of the last block.
+	var got StakeActivity
+	for i := 0; i < 100; i++ {
+		got = observer.StakeActivity()
+		if got.Height == targetHeight {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got != want {
+		t.Fatalf("unexpected stake activity: got %+v, want %+v", got, want)
+	}
+	if got.VotesSeen == 0 || got.TicketsSeen == 0 {
+		t.Fatalf("observer did not see any stake activity: %+v", got)
+	}
+	if len(observer.tickets) != 0 {
+		t.Fatalf("observer purchased %d tickets", len(observer.tickets))
+	}
+}
+
+// invalidateBlock invalidates the given block in the node, which disconnects it
+// (and any of its descendants) from the main chain.
+func invalidateBlock(ctx context.Context, c *rpcclient.Client, hash *chainhash.Hash) error {
+	param, err := json.Marshal(hash.String())
+	if err != nil {
+		return err
+	}
+	_, err = c.RawRequest(ctx, "invalidateblock", []json.RawMessage{param})
+	return err
+}
+
+// ticketsVotedOn returns the tickets of the wallet that voted on the given
+// block.
+func ticketsVotedOn(vw *VotingWallet, blockHash *chainhash.Hash) map[chainhash.Hash]voteRecord {
+	res := make(map[chainhash.Hash]voteRecord)
+	vw.mtx.Lock()
+	for ticket, rec := range vw.votedTickets {
+		if rec.blockHash == *blockHash {
+			res[ticket] = rec
+		}
+	}
+	vw.mtx.Unlock()
+	return res
+}
+
+// waitTicketsVotedOn waits until the wallet has voted on the given block with
+// the specified number of tickets.
+func waitTicketsVotedOn(t *testing.T, vw *VotingWallet, blockHash *chainhash.Hash, nb int) map[chainhash.Hash]voteRecord {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		voted := ticketsVotedOn(vw, blockHash)
+		if len(voted) >= nb {
+			return voted
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("wallet did not vote on block %s", blockHash)
+	return nil
+}
+
+// replaceTip invalidates the given tip block of the node and submits a variant
+// of it with a different timestamp, which causes the chain to reorg to the new
+// block. It returns the hash of the new tip block.
+func replaceTip(ctx context.Context, c *rpcclient.Client, tip *chainhash.Hash) (*chainhash.Hash, error) {
+	block, err := c.GetBlock(ctx, tip)
+	if err != nil {
+		return nil, err
+	}
+	if err := invalidateBlock(ctx, c, tip); err != nil {
+		return nil, err
+	}
+
+	block.Header.Timestamp = block.Header.Timestamp.Add(time.Second)
+	if !solveBlock(&block.Header) {
+		return nil, fmt.Errorf("unable to solve block")
+	}
+	err = c.SubmitBlock(ctx, dcrutil.NewBlock(block), nil)
+	if err != nil {
+		return nil, err
+	}
+	newTip := block.Header.BlockHash()
+	return &newTip, nil
+}
+
+// testReorgDuplicateWinner tests that a ticket that won on a block that is
+// then reorged out is able to vote again when it also wins on the new branch.
+func testReorgDuplicateWinner(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 4
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The live ticket pool is small, so it's very likely that a ticket
+	// selected on the old tip is also selected on the new one. Try a few
+	// times in case it isn't.
+	nbVotes := int(vw.hn.ActiveNet.TicketsPerBlock)
+	for attempt := 0; attempt < 10; attempt++ {
+		oldTip, _, err := vw.hn.Node.GetBestBlock(ctx)
+		if err != nil {
+			t.Fatalf("unable to obtain best block: %v", err)
+		}
+		oldVoted := waitTicketsVotedOn(t, vw, oldTip, nbVotes)
+		newTip, err := replaceTip(ctx, vw.hn.Node, oldTip)
+		if err != nil {
+			t.Fatalf("unable to replace tip block: %v", err)
+		}
+		newVoted := waitTicketsVotedOn(t, vw, newTip, nbVotes)
+
+		for ticket := range oldVoted {
+			rec, ok := newVoted[ticket]
+			if !ok {
+				continue
+			}
+
+			// The ticket won on both branches. Ensure the new vote
+			// made it to the mempool.
+			mempoolVotes, err := vw.hn.Node.GetRawMempool(ctx,
+				dcrdtypes.GRMVotes)
+			if err != nil {
+				t.Fatalf("unable to get mempool votes: %v", err)
+			}
+			for _, h := range mempoolVotes {
+				if *h == rec.voteHash {
+					return
+				}
+			}
+			t.Fatalf("vote %s of ticket %s is not in the mempool",
+				&rec.voteHash, &ticket)
+		}
+	}
+
+	t.Fatalf("no ticket won on both branches of a reorg")
+}
+
+// testReorgRecovery tests that the wallet reverses the effects of a block that
+// is disconnected and keeps the chain going on the new branch.
+func testReorgRecovery(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Disconnect a tip that includes votes and tickets of the wallet and
+	// that the wallet voted on.
+	tip, votes, tickets, err := vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(votes) == 0 || len(tickets) == 0 {
+		t.Fatalf("block %s does not include stake transactions of the "+
+			"wallet", tip)
+	}
+	nbTickets := int(vw.hn.ActiveNet.TicketsPerBlock)
+	staleVotes := waitTicketsVotedOn(t, vw, tip, nbTickets)
+	liveTickets := vw.LiveTicketCount()
+	if err := invalidateBlock(ctx, vw.hn.Node, tip); err != nil {
+		t.Fatalf("unable to invalidate block %s: %v", tip, err)
+	}
+
+	// The outputs of the votes cast on the disconnected block never mature.
+	isStale := func() bool {
+		vw.mtx.Lock()
+		defer vw.mtx.Unlock()
+		for _, utxos := range vw.maturingVotes {
+			for _, utxo := range utxos {
+				for _, rec := range staleVotes {
+					if utxo.outpoint.Hash == rec.voteHash {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+	for i := 0; isStale(); i++ {
+		if i == 100 {
+			t.Fatalf("outputs of stale votes are still maturing")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The node adds the tickets of the disconnected block back to the
+	// mempool, so the wallet keeps them.
+	if n := vw.LiveTicketCount(); n != liveTickets {
+		t.Fatalf("unexpected live ticket count after reorg; got %d, "+
+			"want %d", n, liveTickets)
+	}
+
+	// The chain continues on the new branch, which includes the tickets of
+	// the disconnected block, without any missed tickets.
+	hashes, err := vw.GenerateBlocks(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mined := make(map[chainhash.Hash]struct{})
+	for _, hash := range hashes {
+		block, err := vw.hn.Node.GetBlock(ctx, hash)
+		if err != nil {
+			t.Fatalf("unable to get block %s: %v", hash, err)
+		}
+		for _, tx := range block.STransactions {
+			mined[tx.TxHash()] = struct{}{}
+		}
+	}
+	for _, ticket := range tickets {
+		if _, ok := mined[*ticket]; !ok {
+			t.Fatalf("ticket %s of the disconnected block was not mined "+
+				"again", ticket)
+		}
+	}
+	if missed := vw.MissedTickets(); len(missed) != 0 {
+		t.Fatalf("unexpected missed tickets after reorg: %v", missed)
+	}
+}
+
+// testBuyTicketFromStakeOutput tests that the wallet can purchase a ticket
+// funded by a matured vote output.
+func testBuyTicketFromStakeOutput(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Fund an additional block worth of outputs, such that the ticket
+	// purchased from a stake output does not starve the regular purchases.
+	vw = replaceWallet(ctx, t, vw, fundExtraOutputs(t,
+		int(vw.hn.ActiveNet.TicketsPerBlock)))
+
+	// No vote outputs are available before the first votes mature.
+	_, err := vw.BuyTicketFromStakeOutput(ctx)
+	if err == nil {
+		t.Fatalf("unexpected ticket purchase without matured vote outputs")
+	}
+
+	net := vw.hn.ActiveNet
+	targetHeight := net.StakeValidationHeight + int64(net.CoinbaseMaturity) + 2
+	_, err = vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticketHash, err := vw.BuyTicketFromStakeOutput(ctx)
+	if err != nil {
+		t.Fatalf("unable to buy ticket from stake output: %v", err)
+	}
+
+	// Mine the ticket to ensure it's valid.
+	if _, err := vw.GenerateBlocks(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ticket, err := vw.hn.Node.GetRawTransaction(ctx, ticketHash)
+	if err != nil {
+		t.Fatalf("unable to get ticket %s: %v", ticketHash, err)
+	}
+	if !stake.IsSStx(ticket.MsgTx()) {
+		t.Fatalf("tx %s is not a ticket", ticketHash)
+	}
+	prevOut := ticket.MsgTx().TxIn[0].PreviousOutPoint
+	if prevOut.Tree != wire.TxTreeStake {
+		t.Fatalf("ticket %s spends output %s from tree %d; want stake tree",
+			ticketHash, &prevOut, prevOut.Tree)
+	}
+	prevTx, err := vw.hn.Node.GetRawTransaction(ctx, &prevOut.Hash)
+	if err != nil {
+		t.Fatalf("unable to get tx %s: %v", &prevOut.Hash, err)
+	}
+	if !stake.IsSSGen(prevTx.MsgTx()) {
+		t.Fatalf("ticket %s does not spend a vote output", ticketHash)
+	}
+}
+
+// testStop tests that stopping the wallet shuts down its notification handler
+// and connection to the node, and that it is safe to stop it multiple times.
+func testStop(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	if _, err := vw.GenerateBlocks(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := vw.Stop(); err != nil {
+			t.Fatalf("unable to stop wallet (call %d): %v", i+1, err)
+		}
+	}
+
+	if _, _, err := vw.c.GetBestBlock(ctx); err == nil {
+		t.Fatalf("wallet rpc client still usable after stopping")
+	}
+
+	// Blocks connected after stopping are not tracked by the wallet.
+	wantHeight := vw.StakeActivity().Height
+	if _, err := vw.hn.Node.Generate(ctx, 1); err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	time.Sleep(time.Millisecond * 500)
+	if gotHeight := vw.StakeActivity().Height; gotHeight != wantHeight {
+		t.Fatalf("wallet tracked block at height %d after stopping",
+			gotHeight)
+	}
+}
+
+// testMissedTickets tests that winning tickets that do not vote are considered
+// missed once the grace period elapses.
+func testMissedTickets(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	const gracePeriod = 1
+	vw.SetMissedGracePeriod(gracePeriod)
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missed := vw.MissedTickets(); len(missed) != 0 {
+		t.Fatalf("unexpected missed tickets %v", missed)
+	}
+
+	// The votes for the current tip have already been cast, so the limit
+	// only applies to the tickets selected in the next block, which vote on
+	// the block after it.
+	const nbVotes = 3
+	if err := vw.LimitNbVotes(nbVotes); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vw.GenerateBlocks(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if missed := vw.MissedTickets(); len(missed) != 0 {
+		t.Fatalf("tickets %v missed before the grace period elapsed",
+			missed)
+	}
+
+	if _, err := vw.GenerateBlocks(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	wantMissed := int(vw.hn.ActiveNet.TicketsPerBlock) - nbVotes
+	if missed := vw.MissedTickets(); len(missed) != wantMissed {
+		t.Fatalf("unexpected number of missed tickets; got %d, want %d",
+			len(missed), wantMissed)
+	}
+}
+
+// testLimitedVotesFullTickets tests that limiting the number of votes below the
+// number of tickets per block does not prevent the wallet from having a full
+// block worth of tickets in the mempool before each block is generated.
+func testLimitedVotesFullTickets(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const nbVotes = 3
+	if err := vw.LimitNbVotes(nbVotes); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := vw.GenerateBlocksWithStats(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTickets := int(vw.hn.ActiveNet.TicketsPerBlock)
+	for _, block := range stats.Blocks {
+		if len(block.Tickets) != wantTickets {
+			t.Fatalf("block at height %d includes %d tickets, want %d",
+				block.Height, len(block.Tickets), wantTickets)
+		}
+	}
+}
+
+// testMissedVoteRate tests that the wallet deliberately skips voting with some
+// of its winning tickets, which are then considered missed and revoked.
+func testMissedVoteRate(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	for _, rate := range []float64{-0.1, 1.1} {
+		if err := vw.SetMissedVoteRate(rate); err == nil {
+			t.Fatalf("missed vote rate %v was not rejected", rate)
+		}
+	}
+
+	net := vw.hn.ActiveNet
+	targetHeight := net.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The votes for the current tip have already been cast, so the votes
+	// skipped on each generated block are only observed in the following
+	// one.
+	if err := vw.SetMissedVoteRate(0.3); err != nil {
+		t.Fatal(err)
+	}
+	const nbBlocks = 10
+	stats, err := vw.GenerateBlocksWithStats(ctx, nbBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nbTickets := int(net.TicketsPerBlock)
+	majority := nbTickets/2 + 1
+	var nbSkipped int
+	for _, block := range stats.Blocks[1:] {
+		if len(block.Votes) < majority {
+			t.Fatalf("block %s includes %d votes of the wallet, less than "+
+				"the majority of %d", block.Hash, len(block.Votes), majority)
+		}
+		nbSkipped += nbTickets - len(block.Votes)
+	}
+	if nbSkipped == 0 {
+		t.Fatalf("no votes were skipped")
+	}
+
+	// The tickets that skipped voting are missed and revoked.
+	missed := vw.MissedTickets()
+	if len(missed) != nbSkipped {
+		t.Fatalf("unexpected number of missed tickets; got %d, want %d",
+			len(missed), nbSkipped)
+	}
+	vw.mtx.Lock()
+	defer vw.mtx.Unlock()
+	for _, ticketHash := range missed {
+		if !vw.tickets[ticketHash].revoked {
+			t.Fatalf("missed ticket %s was not revoked", ticketHash)
+		}
+	}
+}
+
+// otherWalletKey is the private key of the wallets that run alongside the
+// wallet of a test case.
+var otherWalletKey = []byte{
+	0x2b, 0x1c, 0x8e, 0x3f, 0x52, 0x7a, 0x90, 0x14,
+	0xd6, 0x45, 0xee, 0x07, 0x39, 0xa1, 0x6c, 0xf2,
+	0x81, 0x5d, 0x23, 0xb8, 0x4e, 0x97, 0x0a, 0xc3,
+	0x66, 0xf0, 0x1b, 0x74, 0xd9, 0x28, 0x5e, 0x03,
+}
+
+// testNewVotingWalletWithKey tests that wallets created with a custom private
+// key reject invalid keys and purchase tickets paying to their own address.
+func testNewVotingWalletWithKey(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	overflowKey := bytes.Repeat([]byte{0xff}, secp256k1.PrivKeyBytesLen)
+	invalidKeys := map[string][]byte{
+		"short":    otherWalletKey[:31],
+		"long":     append(append([]byte{}, otherWalletKey...), 0x01),
+		"zero":     make([]byte, secp256k1.PrivKeyBytesLen),
+		"overflow": overflowKey,
+	}
+	for name, key := range invalidKeys {
+		if _, err := NewVotingWalletWithKey(ctx, vw.hn, key); err == nil {
+			t.Fatalf("%s private key was not rejected", name)
+		}
+	}
+
+	other, err := NewVotingWalletWithKey(ctx, vw.hn, otherWalletKey)
+	if err != nil {
+		t.Fatalf("unable to create wallet with key: %v", err)
+	}
+	t.Cleanup(func() { other.Stop() })
+	if bytes.Equal(other.p2sstx, vw.p2sstx) {
+		t.Fatalf("wallet with custom key uses the default voting script")
+	}
+	other.SetSharedTicketPool(true)
+	vw.SetSharedTicketPool(true)
+	if err := other.Start(ctx); err != nil {
+		t.Fatalf("unable to start wallet with key: %v", err)
+	}
+	other.SetErrorReporting(func(err error) {
+		t.Errorf("wallet with key errored: %v", err)
+	})
+
+	// The tickets of each wallet pay to its own voting rights script.
+	targetHeight := ticketPurchaseStartHeight(vw.hn.ActiveNet) + 2
+	stats, err := vw.GenerateBlocksToHeightWithStats(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nbTickets, nbOtherTickets int
+	for _, block := range stats.Blocks {
+		otherStats, _, _, err := other.blockStats(ctx, block.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range []*VotingWallet{vw, other} {
+			tickets := block.Tickets
+			if w == other {
+				tickets = otherStats.Tickets
+			}
+			for _, ticketHash := range tickets {
+				ticket, err := vw.hn.Node.GetRawTransaction(ctx, ticketHash)
+				if err != nil {
+					t.Fatalf("unable to get ticket %s: %v", ticketHash, err)
+				}
+				script := ticket.MsgTx().TxOut[0].PkScript
+				if !bytes.Equal(script, w.p2sstx) {
+					t.Fatalf("ticket %s does not pay to the voting "+
+						"rights script of its wallet", ticketHash)
+				}
+			}
+		}
+		nbTickets += len(block.Tickets)
+		nbOtherTickets += len(otherStats.Tickets)
+	}
+	if nbTickets == 0 || nbOtherTickets == 0 {
+		t.Fatalf("wallets did not both purchase tickets; got %d and %d "+
+			"tickets", nbTickets, nbOtherTickets)
+	}
+}
+
+// testSharedTicketPool tests that two wallets sharing the live ticket pool keep
+// the chain going, each voting only with its own tickets, once their initial
+// funds are exhausted.
+func testSharedTicketPool(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	other, err := NewVotingWalletWithKey(ctx, vw.hn, otherWalletKey)
+	if err != nil {
+		t.Fatalf("unable to create second wallet: %v", err)
+	}
+	t.Cleanup(func() { other.Stop() })
+	other.SetSharedTicketPool(true)
+	vw.SetSharedTicketPool(true)
+	if err := other.Start(ctx); err != nil {
+		t.Fatalf("unable to start second wallet: %v", err)
+	}
+	other.SetErrorReporting(func(err error) {
+		t.Errorf("second voting wallet errored: %v", err)
+	})
+
+	// Both wallets purchase TicketsPerBlock tickets on every block until
+	// their initial funds are exhausted, which happens once the outputs of
+	// their votes mature.
+	net := vw.hn.ActiveNet
+	nbTickets := int(net.TicketsPerBlock)
+	targetHeight := net.StakeValidationHeight +
+		int64(net.CoinbaseMaturity+net.TicketMaturity)*3
+	stats, err := vw.GenerateBlocksToHeightWithStats(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nbVotes, nbOtherVotes, nbReducedPurchases int
+	for _, block := range stats.Blocks {
+		otherStats, _, _, err := other.blockStats(ctx, block.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nbVotes += len(block.Votes)
+		nbOtherVotes += len(otherStats.Votes)
+		if block.Height < net.StakeValidationHeight {
+			continue
+		}
+
+		// Every block includes a full block of votes from the wallets and
+		// at least as many tickets as votes.
+		if n := len(block.Votes) + len(otherStats.Votes); n != nbTickets {
+			t.Fatalf("block %s includes %d votes, want %d", block.Hash,
+				n, nbTickets)
+		}
+		n := len(block.Tickets) + len(otherStats.Tickets)
+		if n < nbTickets {
+			t.Fatalf("block %s includes %d tickets, want at least %d",
+				block.Hash, n, nbTickets)
+		}
+		if len(block.Tickets) < nbTickets || len(otherStats.Tickets) < nbTickets {
+			nbReducedPurchases++
+		}
+	}
+	if nbVotes == 0 || nbOtherVotes == 0 {
+		t.Fatalf("wallets did not both vote; got %d and %d votes", nbVotes,
+			nbOtherVotes)
+	}
+	if nbReducedPurchases == 0 {
+		t.Fatalf("wallets did not exhaust their initial funds")
+	}
+
+	// Each wallet only voted with its own tickets.
+	for _, w := range []*VotingWallet{vw, other} {
+		w.mtx.Lock()
+		for ticketHash := range w.votedTickets {
+			if _, ok := w.tickets[ticketHash]; !ok {
+				w.mtx.Unlock()
+				t.Fatalf("wallet voted with ticket %s it does not own",
+					ticketHash)
+			}
+		}
+		w.mtx.Unlock()
+	}
+}
+
+// testAssertFullParticipation tests that full participation is asserted over a
+// healthy run and fails at the first block that includes fewer votes.
+func testAssertFullParticipation(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	svh := vw.hn.ActiveNet.StakeValidationHeight
+	targetHeight := svh + 5
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vw.AssertFullParticipation(svh, targetHeight); err != nil {
+		t.Fatalf("unexpected participation failure: %v", err)
+	}
+
+	// Blocks before SVH do not include votes.
+	if err := vw.AssertFullParticipation(svh-1, targetHeight); err == nil {
+		t.Fatalf("participation asserted for block before SVH")
+	}
+
+	// Skip votes and find the first block that includes fewer votes than
+	// the limit.
+	if err := vw.SetMissedVoteRate(0.5); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := vw.GenerateBlocksWithStats(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shortHeight := int64(-1)
+	for _, block := range stats.Blocks {
+		if len(block.Votes) < int(vw.hn.ActiveNet.TicketsPerBlock) {
+			shortHeight = block.Height
+			break
+		}
+	}
+	if shortHeight == -1 {
+		t.Fatalf("no votes were skipped")
+	}
+	tipHeight := stats.Blocks[len(stats.Blocks)-1].Height
+	err = vw.AssertFullParticipation(svh, tipHeight)
+	wantErr := fmt.Sprintf("block at height %d ", shortHeight)
+	if err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Fatalf("unexpected participation error; got %v, want block at "+
+			"height %d", err, shortHeight)
+	}
+	if err := vw.AssertFullParticipation(svh, shortHeight-1); err != nil {
+		t.Fatalf("unexpected participation failure before height %d: %v",
+			shortHeight, err)
+	}
+}
+
+// testRevokeMissedTickets tests that the wallet recovers the funds of its
+// missed tickets through their revocations.
+func testRevokeMissedTickets(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Fund an additional block worth of outputs for the tickets purchased
+	// while the votes are limited, which return fewer outputs to the wallet.
+	vw = replaceWallet(ctx, t, vw, fundExtraOutputs(t,
+		int(vw.hn.ActiveNet.TicketsPerBlock)))
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Limit the votes such that tickets are missed in the blocks after the
+	// next one, which then include their revocations.
+	const nbVotes = 3
+	if err := vw.LimitNbVotes(nbVotes); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := vw.GenerateBlocksWithStats(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocationBlock := &stats.Blocks[1]
+	block, err := vw.hn.Node.GetBlock(ctx, revocationBlock.Hash)
+	if err != nil {
+		t.Fatalf("unable to get block %s: %v", revocationBlock.Hash, err)
+	}
+	revocations := make(map[wire.OutPoint]*chainhash.Hash)
+	for _, tx := range block.STransactions {
+		if !stake.IsSSRtx(tx) {
+			continue
+		}
+		ticketHash := tx.TxIn[0].PreviousOutPoint.Hash
+		vw.mtx.Lock()
+		ticket, ok := vw.tickets[ticketHash]
+		vw.mtx.Unlock()
+		if !ok {
+			continue
+		}
+		if !ticket.revoked {
+			t.Fatalf("ticket %s not recorded as revoked", ticketHash)
+		}
+		txHash := tx.TxHash()
+		revocations[wire.OutPoint{Hash: txHash, Tree: wire.TxTreeStake}] =
+			&ticketHash
+	}
+	wantRevocations := int(vw.hn.ActiveNet.TicketsPerBlock) - nbVotes
+	if len(revocations) != wantRevocations {
+		t.Fatalf("unexpected number of revocations in block %d; got %d, "+
+			"want %d", revocationBlock.Height, len(revocations),
+			wantRevocations)
+	}
+
+	// The outputs of the revocations mature in the same manner as the ones
+	// of votes and are then returned to the spendable utxos of the wallet.
+	nb := uint32(vw.hn.ActiveNet.CoinbaseMaturity) + 1
+	if _, err := vw.GenerateBlocks(ctx, nb); err != nil {
+		t.Fatal(err)
+	}
+	vw.mtx.Lock()
+	for _, utxo := range vw.utxos {
+		if utxo.revocation {
+			delete(revocations, utxo.outpoint)
+		}
+	}
+	vw.mtx.Unlock()
+	for outpoint, ticketHash := range revocations {
+		t.Fatalf("output %v of the revocation of ticket %s was not "+
+			"returned to the spendable utxos", outpoint, ticketHash)
+	}
+}
+
+// testFailFast tests that the first error encountered by a wallet in fail-fast
+// mode stops it and is returned when generating blocks.
+func testFailFast(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	vw = replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		w.SetFailFast(true)
+	})
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fail to build the block reference of the votes, which stops the
+	// wallet once the winning tickets of the next block are handled.
+	errInjected := errors.New("injected failure")
+	vw.SetErrorReporting(nil)
+	vw.SetBlockRefScriptFunc(func(hash chainhash.Hash, height uint32) ([]byte, error) {
+		return nil, errInjected
+	})
+	_, err = vw.GenerateBlocks(ctx, 2)
+	if err == nil || !strings.Contains(err.Error(), errInjected.Error()) {
+		t.Fatalf("unexpected error generating blocks; got %v, want %q",
+			err, errInjected)
+	}
+
+	// The notification handler must have stopped.
+	done := make(chan struct{})
+	go func() {
+		vw.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("notification handler did not stop")
+	}
+
+	// Further attempts to generate blocks return the same error.
+	_, err = vw.GenerateBlocks(ctx, 1)
+	if err == nil || !strings.Contains(err.Error(), errInjected.Error()) {
+		t.Fatalf("unexpected error generating blocks after failure; got "+
+			"%v, want %q", err, errInjected)
+	}
+}
+
+// testConcurrentAccess tests that the state of the wallet may be inspected and
+// configured while blocks are generated. It is most useful when run with the
+// race detector.
+func testConcurrentAccess(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight - 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The wallet abstains on the tspend, so the votes remain valid even
+	// though it does not exist.
+	tspendHash := chainhash.Hash{0x01}
+	done := make(chan struct{})
+	inspected := make(chan error, 1)
+	go func() {
+		defer close(inspected)
+		limit := int(vw.hn.ActiveNet.TicketsPerBlock)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			vw.LiveTicketCount()
+			vw.SpendableBalance()
+			vw.MissedTickets()
+			if _, err := vw.MaxSustainableHeight(ctx); err != nil {
+				inspected <- err
+				return
+			}
+			if err := vw.LimitNbVotes(limit); err != nil {
+				inspected <- err
+				return
+			}
+			err := vw.SetTSpendVote(tspendHash, TreasuryVoteAbstain)
+			if err != nil {
+				inspected <- err
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	_, err = vw.GenerateBlocks(ctx, 8)
+	close(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-inspected; err != nil {
+		t.Fatalf("unable to inspect wallet: %v", err)
+	}
+	if err := vw.VoteForTSpends(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testBlockGenTimeout tests that generating blocks honors the configured
+// timeout and poll interval when waiting for the transactions of the wallet.
+func testBlockGenTimeout(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Never poll the mempool, so generating a block that requires tickets
+	// always times out.
+	const timeout = 250 * time.Millisecond
+	vw = replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		w.SetBlockGenTimeout(timeout)
+		w.SetBlockGenPollInterval(time.Hour)
+	})
+
+	targetHeight := ticketPurchaseStartHeight(vw.hn.ActiveNet) - 1
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = vw.GenerateBlocks(ctx, 1)
+	if err == nil {
+		t.Fatalf("generating blocks did not time out")
+	}
+	if !strings.Contains(err.Error(), timeout.String()) {
+		t.Fatalf("timeout error %q does not include the timeout %v", err,
+			timeout)
+	}
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Fatalf("generating blocks timed out after %v, before the "+
+			"timeout %v", elapsed, timeout)
+	}
+}
+
+// testGenerateBlocksToHeight tests that generating blocks to a target height
+// stops exactly at it and fails when the chain is already past it.
+func testGenerateBlocksToHeight(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight - 1
+	hashes, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, height, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatalf("unable to obtain best block: %v", err)
+	}
+	if height != targetHeight {
+		t.Fatalf("unexpected best block height; got %d, want %d", height,
+			targetHeight)
+	}
+	if len(hashes) == 0 {
+		t.Fatalf("no blocks were generated")
+	}
+
+	// No blocks are generated when the chain is at the target height.
+	hashes, err = vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 0 {
+		t.Fatalf("unexpected number of generated blocks; got %d, want 0",
+			len(hashes))
+	}
+
+	// The chain is past lower target heights.
+	if _, err := vw.GenerateBlocksToHeight(ctx, targetHeight-1); err == nil {
+		t.Fatalf("generating blocks to a past height did not fail")
+	}
+}
+
+// testGenerateOneBlock tests that generating a single block reports the votes
+// and tickets of the wallet included in it.
+func testGenerateOneBlock(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, votes, tickets, err := vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNb := int(vw.hn.ActiveNet.TicketsPerBlock)
+	if len(votes) != wantNb {
+		t.Fatalf("unexpected number of votes in block %s; got %d, want %d",
+			hash, len(votes), wantNb)
+	}
+	if len(tickets) != wantNb {
+		t.Fatalf("unexpected number of tickets in block %s; got %d, want %d",
+			hash, len(tickets), wantNb)
+	}
+
+	block, err := vw.hn.Node.GetBlock(ctx, hash)
+	if err != nil {
+		t.Fatalf("unable to get block %s: %v", hash, err)
+	}
+	if int(block.Header.Voters) != len(votes) {
+		t.Fatalf("block %s has %d voters, but %d votes were reported",
+			hash, block.Header.Voters, len(votes))
+	}
+}
+
+// testGenerateBlocksWithStats tests that the stats of the generated blocks
+// reflect the votes and tickets of the wallet included in them.
+func testGenerateBlocksWithStats(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+	_, startHeight, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatalf("unable to obtain best block: %v", err)
+	}
+	targetHeight := net.StakeValidationHeight + 2
+	nb := uint32(targetHeight - startHeight)
+	stats, err := vw.GenerateBlocksWithStats(ctx, nb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Blocks) != int(nb) {
+		t.Fatalf("unexpected number of block stats; got %d, want %d",
+			len(stats.Blocks), nb)
+	}
+
+	nbTickets := int(net.TicketsPerBlock)
+	purchaseHeight := ticketPurchaseStartHeight(net)
+	for i, block := range stats.Blocks {
+		wantHeight := startHeight + int64(i) + 1
+		if block.Height != wantHeight {
+			t.Fatalf("unexpected height of block %s; got %d, want %d",
+				block.Hash, block.Height, wantHeight)
+		}
+		header, err := vw.hn.Node.GetBlockHeader(ctx, block.Hash)
+		if err != nil {
+			t.Fatalf("unable to get block header %s: %v", block.Hash, err)
+		}
+		if block.StakeDifficulty != dcrutil.Amount(header.SBits) {
+			t.Fatalf("unexpected stake difficulty of block %d; got %v, "+
+				"want %v", block.Height, block.StakeDifficulty,
+				dcrutil.Amount(header.SBits))
+		}
+
+		// Tickets are purchased as the blocks are connected and mined in
+		// the next one, while votes are only included from SVH on.
+		var wantVotes, wantTickets int
+		if block.Height > purchaseHeight {
+			wantTickets = nbTickets
+		}
+		if block.Height >= net.StakeValidationHeight {
+			wantVotes = nbTickets
+		}
+		if len(block.Tickets) != wantTickets {
+			t.Fatalf("unexpected number of tickets in block %d; got %d, "+
+				"want %d", block.Height, len(block.Tickets), wantTickets)
+		}
+		if len(block.Votes) != wantVotes {
+			t.Fatalf("unexpected number of votes in block %d; got %d, "+
+				"want %d", block.Height, len(block.Votes), wantVotes)
+		}
+	}
+}
+
+// testBlockRefScriptFunc tests that the votes of the wallet use the block
+// reference scripts built by a custom function and that the network rejects
+// malformed ones.
+func testBlockRefScriptFunc(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Build the block reference manually, tracking the number of calls.
+	var (
+		mtx   sync.Mutex
+		calls int
+	)
+	vw.SetBlockRefScriptFunc(func(hash chainhash.Hash, height uint32) ([]byte, error) {
+		mtx.Lock()
+		calls++
+		mtx.Unlock()
+		var data [chainhash.HashSize + 4]byte
+		copy(data[:], hash[:])
+		binary.LittleEndian.PutUint32(data[chainhash.HashSize:], height)
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).
+			AddData(data[:]).Script()
+	})
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, votes, _, err := vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(votes) != int(vw.hn.ActiveNet.TicketsPerBlock) {
+		t.Fatalf("unexpected number of votes in block %s; got %d, want %d",
+			hash, len(votes), vw.hn.ActiveNet.TicketsPerBlock)
+	}
+	mtx.Lock()
+	gotCalls := calls
+	mtx.Unlock()
+	if gotCalls == 0 {
+		t.Fatalf("custom block reference script function was not used")
+	}
+
+	// Omit the height from the block reference and ensure the resulting
+	// votes are rejected by the network.
+	errs := make(chan error, 1)
+	vw.SetErrorReporting(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	vw.SetBlockRefScriptFunc(func(hash chainhash.Hash, height uint32) ([]byte, error) {
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).
+			AddData(hash[:]).Script()
+	})
+	if _, err := vw.miner(ctx, 1); err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "unable to send vote tx") {
+			t.Fatalf("unexpected wallet error: %v", err)
+		}
+		if !errors.Is(err, ErrBroadcastFailed) {
+			t.Fatalf("wallet error %v is not of kind %v", err,
+				ErrBroadcastFailed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("malformed vote was not rejected")
+	}
+	mempoolVotes, err := vw.hn.Node.GetRawMempool(ctx, dcrdtypes.GRMVotes)
+	if err != nil {
+		t.Fatalf("unable to get mempool votes: %v", err)
+	}
+	if len(mempoolVotes) != 0 {
+		t.Fatalf("unexpected votes in mempool: %v", mempoolVotes)
+	}
+}
+
+// testLiveTicketCount tests that the live ticket count of the wallet accounts
+// for both the mined tickets and the ones still in the mempool.
+func testLiveTicketCount(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	if n := vw.LiveTicketCount(); n != 0 {
+		t.Fatalf("unexpected live ticket count before purchases; got %d, "+
+			"want 0", n)
+	}
+
+	// Generating blocks waits for the purchased tickets to be in the
+	// mempool, so they must be reflected in the count right away.
+	targetHeight := ticketPurchaseStartHeight(vw.hn.ActiveNet)
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mempoolTickets, err := vw.hn.Node.GetRawMempool(ctx, dcrdtypes.GRMTickets)
+	if err != nil {
+		t.Fatalf("unable to get mempool tickets: %v", err)
+	}
+	if len(mempoolTickets) == 0 {
+		t.Fatalf("no tickets purchased at height %d", targetHeight)
+	}
+	if n := vw.LiveTicketCount(); n != len(mempoolTickets) {
+		t.Fatalf("unexpected live ticket count with unmined tickets; got "+
+			"%d, want %d", n, len(mempoolTickets))
+	}
+
+	// Mine the tickets and ensure the new purchases are added to the count.
+	minedTickets := len(mempoolTickets)
+	if _, err := vw.GenerateBlocks(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	mempoolTickets, err = vw.hn.Node.GetRawMempool(ctx, dcrdtypes.GRMTickets)
+	if err != nil {
+		t.Fatalf("unable to get mempool tickets: %v", err)
+	}
+	want := minedTickets + len(mempoolTickets)
+	if n := vw.LiveTicketCount(); n != want {
+		t.Fatalf("unexpected live ticket count; got %d, want %d", n, want)
+	}
+}
+
+// testSpendableBalance tests that the spendable balance of the wallet accounts
+// for the outputs consumed by ticket purchases.
+func testSpendableBalance(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+	wantCount := requiredTicketCount(net)
+	outputValue := dcrutil.Amount(net.MinimumStakeDiff * vw.commitAmountMultiplier)
+	balance, count := vw.SpendableBalance()
+	if count != wantCount || balance != outputValue*dcrutil.Amount(wantCount) {
+		t.Fatalf("unexpected initial spendable balance; got %v in %d "+
+			"outputs, want %v in %d outputs", balance, count,
+			outputValue*dcrutil.Amount(wantCount), wantCount)
+	}
+
+	// Purchasing tickets consumes one output per ticket.
+	targetHeight := ticketPurchaseStartHeight(net)
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCount -= vw.LiveTicketCount()
+	balance, count = vw.SpendableBalance()
+	if count != wantCount || balance != outputValue*dcrutil.Amount(wantCount) {
+		t.Fatalf("unexpected spendable balance after purchases; got %v "+
+			"in %d outputs, want %v in %d outputs", balance, count,
+			outputValue*dcrutil.Amount(wantCount), wantCount)
+	}
+}
+
+// testWaitForMatureTickets tests that waiting for mature tickets blocks until
+// the tickets of the wallet are mature when blocks are mined by other means.
+func testWaitForMatureTickets(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+
+	// Generate up to the block that includes the first tickets of the
+	// wallet.
+	targetHeight := ticketPurchaseStartHeight(net) + 1
+	stats, err := vw.GenerateBlocksToHeightWithStats(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nbTickets := len(stats.Blocks[len(stats.Blocks)-1].Tickets)
+	if nbTickets == 0 {
+		t.Fatalf("block at height %d does not include tickets",
+			targetHeight)
+	}
+
+	waitMature := func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return vw.WaitForMatureTickets(ctx, nbTickets)
+	}
+
+	// Mine the blocks without the wallet until the tickets are one block
+	// away from maturity.
+	nb := uint32(net.TicketMaturity) - 1
+	if _, err := vw.miner(ctx, nb); err != nil {
+		t.Fatalf("unable to generate blocks: %v", err)
+	}
+	if err := waitMature(500 * time.Millisecond); err == nil {
+		t.Fatalf("tickets reported mature one block before maturity")
+	}
+
+	if _, err := vw.miner(ctx, 1); err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	if err := waitMature(5 * time.Second); err != nil {
+		t.Fatalf("tickets not reported mature: %v", err)
+	}
+}
+
+// testWaitForMempoolRemoval tests that waiting for a transaction to be removed
+// from the mempool distinguishes between mined and dropped transactions.
+func testWaitForMempoolRemoval(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+	targetHeight := ticketPurchaseStartHeight(net) + 1
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tickets purchased by the wallet are mined.
+	mempoolTickets, err := vw.hn.Node.GetRawMempool(ctx, dcrdtypes.GRMTickets)
+	if err != nil {
+		t.Fatalf("unable to get mempool tickets: %v", err)
+	}
+	if len(mempoolTickets) == 0 {
+		t.Fatalf("no tickets in the mempool")
+	}
+	mined, err := vw.WaitForMempoolRemoval(ctx, mempoolTickets[0], time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mined {
+		t.Fatalf("ticket %s was not reported as mined", mempoolTickets[0])
+	}
+
+	// Fill the next block with tickets paying a higher fee than a ticket that
+	// expires once the next block is mined, such that it is dropped.
+	_, height, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatalf("unable to obtain best block: %v", err)
+	}
+	ticketPrice, _, err := vw.ticketPrice(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nbFiller := int(net.MaxFreshStakePerBlock)
+	vw.mtx.Lock()
+	utxos := make([]utxoInfo, nbFiller+1)
+	copy(utxos, vw.utxos[len(vw.utxos)-len(utxos):])
+	vw.utxos = vw.utxos[:len(vw.utxos)-len(utxos)]
+	vw.mtx.Unlock()
+
+	vw.SetFeeRate(feeRate * 5)
+	for i := 0; i < nbFiller; i++ {
+		ticket, err := vw.newTicket(&utxos[i], ticketPrice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ticketHash := ticket.TxHash()
+		vw.addTicket(&ticketHash, ticketPrice, &utxos[i])
+		_, err = vw.hn.Node.SendRawTransaction(ctx, ticket, true)
+		if err != nil {
+			t.Fatalf("unable to send filler ticket: %v", err)
+		}
+	}
+	vw.SetFeeRate(0)
+
+	expiring, err := vw.newTicket(&utxos[nbFiller], ticketPrice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiring.Expiry = uint32(height) + 2
+	sig, err := sign.SignatureScript(expiring, 0, vw.p2pkh,
+		txscript.SigHashAll, vw.privateKey, dcrec.STEcdsaSecp256k1, true)
+	if err != nil {
+		t.Fatalf("unable to sign expiring ticket: %v", err)
+	}
+	expiring.TxIn[0].SignatureScript = sig
+	expiringHash, err := vw.hn.Node.SendRawTransaction(ctx, expiring, true)
+	if err != nil {
+		t.Fatalf("unable to send expiring ticket: %v", err)
+	}
+
+	mined, err = vw.WaitForMempoolRemoval(ctx, expiringHash, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mined {
+		t.Fatalf("expired ticket %s was reported as mined", expiringHash)
+	}
+}
+
+// testDeterministicTimestamps tests that the blocks generated by the wallet
+// follow the deterministic timestamp schedule.
+func testDeterministicTimestamps(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	tipHash, tipHeight, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatalf("unable to obtain best block: %v", err)
+	}
+	tip, err := vw.hn.Node.GetBlockHeader(ctx, tipHash)
+	if err != nil {
+		t.Fatalf("unable to get best block header: %v", err)
+	}
+
+	// Schedule the next block one second after the current tip, which keeps
+	// the difficulty unchanged.
+	const interval = time.Second
+	base := tip.Timestamp.Add(-time.Duration(tipHeight) * interval)
+	vw.SetDeterministicTimestamps(base, interval)
+
+	stats, err := vw.GenerateBlocksWithStats(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range stats.Blocks {
+		header, err := vw.hn.Node.GetBlockHeader(ctx, block.Hash)
+		if err != nil {
+			t.Fatalf("unable to get block header %s: %v", block.Hash, err)
+		}
+		want := base.Add(time.Duration(block.Height) * interval)
+		if !header.Timestamp.Equal(want) {
+			t.Fatalf("unexpected timestamp of block %s at height %d; got "+
+				"%v, want %v", block.Hash, block.Height, header.Timestamp,
+				want)
+		}
+	}
+}
+
+// testTotalBurnedChange tests that the change burned by the tickets of the
+// wallet accounts for the funding value not spent on the price and fee of each
+// ticket.
+func testTotalBurnedChange(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	net := vw.hn.ActiveNet
+	if burned := vw.TotalBurnedChange(); burned != 0 {
+		t.Fatalf("unexpected initial burned change; got %v, want 0", burned)
+	}
+
+	// Purchase a few blocks worth of tickets, all funded by the initial
+	// outputs of the wallet.
+	targetHeight := ticketPurchaseStartHeight(net) + 3
+	stats, err := vw.GenerateBlocksToHeightWithStats(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticketHashes, err := vw.hn.Node.GetRawMempool(ctx, dcrdtypes.GRMTickets)
+	if err != nil {
+		t.Fatalf("unable to get mempool tickets: %v", err)
+	}
+	for _, block := range stats.Blocks {
+		ticketHashes = append(ticketHashes, block.Tickets...)
+	}
+	if len(ticketHashes) == 0 {
+		t.Fatalf("no tickets were purchased")
+	}
+
+	fundingValue := net.MinimumStakeDiff * vw.commitAmountMultiplier
+	var wantBurned dcrutil.Amount
+	for _, ticketHash := range ticketHashes {
+		ticket, err := vw.hn.Node.GetRawTransaction(ctx, ticketHash)
+		if err != nil {
+			t.Fatalf("unable to get ticket %s: %v", ticketHash, err)
+		}
+		tx := ticket.MsgTx()
+		fee := tx.TxIn[0].ValueIn
+		for _, out := range tx.TxOut {
+			fee -= out.Value
+		}
+		wantBurned += dcrutil.Amount(fundingValue - tx.TxOut[0].Value - fee)
+	}
+
+	// The wallet accounts for the tickets in the mempool once their
+	// submission completes, so allow it some time to do so.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		burned := vw.TotalBurnedChange()
+		if burned == wantBurned {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected burned change; got %v, want %v", burned,
+				wantBurned)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testMaxSustainableHeight tests that a wallet remains healthy up to its
+// maximum sustainable height and is depleted past it.
+func testMaxSustainableHeight(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Limit the funds of the wallet to two blocks worth of tickets, such that
+	// it is depleted before any of its votes mature.
+	net := vw.hn.ActiveNet
+	nbTickets := int(net.TicketsPerBlock)
+	vw.mtx.Lock()
+	vw.utxos = vw.utxos[:2*nbTickets]
+	vw.mtx.Unlock()
+
+	maxHeight, err := vw.MaxSustainableHeight(ctx)
+	if err != nil {
+		t.Fatalf("unable to obtain max sustainable height: %v", err)
+	}
+	wantHeight := ticketPurchaseStartHeight(net) + 1
+	if maxHeight != wantHeight {
+		t.Fatalf("unexpected max sustainable height; got %d, want %d",
+			maxHeight, wantHeight)
+	}
+
+	// Mining up to the limit does not report any errors.
+	_, err = vw.GenerateBlocksToHeight(ctx, maxHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, count := vw.SpendableBalance(); count != 0 {
+		t.Fatalf("unexpected number of spendable outputs at the limit; "+
+			"got %d, want 0", count)
+	}
+
+	// The wallet is unable to purchase tickets past the limit.
+	errs := make(chan error, 1)
+	vw.SetErrorReporting(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	if _, err := vw.miner(ctx, 1); err != nil {
+		t.Fatalf("unable to generate block at height %d: %v", maxHeight+1,
+			err)
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "number of available utxos") {
+			t.Fatalf("unexpected wallet error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wallet was not depleted past the max sustainable height")
+	}
+}
+
+// testEarlyMaturity tests that the network rejects a ticket of a wallet that
+// considers the outputs of its votes spendable before they mature.
+func testEarlyMaturity(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Fund an additional block worth of outputs, such that the ticket
+	// purchased from a stake output does not starve the regular purchases.
+	vw = replaceWallet(ctx, t, vw, fundExtraOutputs(t,
+		int(vw.hn.ActiveNet.TicketsPerBlock)))
+	vw.SetEarlyMaturityBlocks(1)
+
+	// The first votes are cast for the block before SVH, so their outputs
+	// are considered spendable one block early once the block coinbase
+	// maturity blocks later, minus one, is connected.
+	net := vw.hn.ActiveNet
+	earlyHeight := net.StakeValidationHeight - 1 +
+		int64(net.CoinbaseMaturity) - 1
+	_, err := vw.GenerateBlocksToHeight(ctx, earlyHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The outputs are made available after the tickets for the block are
+	// purchased, so retry until they are.
+	timeout := time.After(5 * time.Second)
+	for {
+		_, err := vw.BuyTicketFromStakeOutput(ctx)
+		switch {
+		case err == nil:
+			t.Fatalf("premature spend was not rejected")
+		case strings.Contains(err.Error(), "before required maturity"):
+			return
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("premature spend was not attempted: %v", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+// testVoteRecord tests that the vote records of the tickets of the wallet point
+// to the blocks they voted on.
+func testVoteRecord(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, votes, _, err := vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(votes) == 0 {
+		t.Fatalf("no votes from the wallet in block %s", hash)
+	}
+	block, err := vw.hn.Node.GetBlock(ctx, hash)
+	if err != nil {
+		t.Fatalf("unable to get block %s: %v", hash, err)
+	}
+	wantHeight := int64(block.Header.Height) - 1
+	for _, voteHash := range votes {
+		vote, err := vw.hn.Node.GetRawTransaction(ctx, voteHash)
+		if err != nil {
+			t.Fatalf("unable to get vote %s: %v", voteHash, err)
+		}
+		ticket := &vote.MsgTx().TxIn[1].PreviousOutPoint.Hash
+		blockHash, height, voteTx, ok := vw.VoteRecord(ticket)
+		if !ok {
+			t.Fatalf("no vote record for ticket %s", ticket)
+		}
+		if *blockHash != block.Header.PrevBlock || height != wantHeight {
+			t.Fatalf("unexpected block voted on by ticket %s; got %s "+
+				"(height %d), want %s (height %d)", ticket, blockHash,
+				height, block.Header.PrevBlock, wantHeight)
+		}
+		if *voteTx != *voteHash {
+			t.Fatalf("unexpected vote of ticket %s; got %s, want %s",
+				ticket, voteTx, voteHash)
+		}
+	}
+
+	if _, _, _, ok := vw.VoteRecord(&chainhash.Hash{}); ok {
+		t.Fatalf("unexpected vote record for unknown ticket")
+	}
+}
+
+// testSetVoteBits tests that votes cast after changing the vote bits of the
+// wallet use the new bits.
+func testSetVoteBits(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	if err := vw.SetVoteBits(0x0004); err == nil {
+		t.Fatalf("unexpected success setting vote bits that do not " +
+			"approve the previous block")
+	}
+
+	const voteBits = 0x0005
+	if err := vw.SetVoteBits(voteBits); err != nil {
+		t.Fatalf("unable to set vote bits: %v", err)
+	}
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, votes, _, err := vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(votes) == 0 {
+		t.Fatalf("no votes from the wallet in block")
+	}
+	for _, voteHash := range votes {
+		vote, err := vw.hn.Node.GetRawTransaction(ctx, voteHash)
+		if err != nil {
+			t.Fatalf("unable to get vote %s: %v", voteHash, err)
+		}
+		gotBits := stake.SSGenVoteBits(vote.MsgTx())
+		if gotBits != voteBits {
+			t.Fatalf("vote %s has unexpected vote bits; got %#04x, "+
+				"want %#04x", voteHash, gotBits, voteBits)
+		}
+	}
+}
+
+// replaceWallet stops the wallet of a test case and returns a new started
+// wallet for the same harness, created with the given options. The setup
+// function, if provided, is called before starting the new wallet. The new
+// wallet is stopped once the test finishes.
+func replaceWallet(ctx context.Context, t *testing.T, vw *VotingWallet, setup func(w *VotingWallet), opts ...VotingWalletOption) *VotingWallet {
+	t.Helper()
+
+	if err := vw.Stop(); err != nil {
+		t.Fatalf("unable to stop wallet: %v", err)
+	}
+	w, err := NewVotingWallet(ctx, vw.hn, opts...)
+	if err != nil {
+		t.Fatalf("unable to create wallet: %v", err)
+	}
+	t.Cleanup(func() { w.Stop() })
+	if setup != nil {
+		setup(w)
+	}
+	if err := w.Start(ctx); err != nil {
+		t.Fatalf("unable to start wallet: %v", err)
+	}
+	w.SetErrorReporting(func(err error) {
+		t.Errorf("voting wallet errored: %v", err)
+	})
+	w.SetMiner(vw.miner)
+	return w
+}
+
+// fundExtraOutputs returns a wallet setup for replaceWallet that funds the
+// wallet with the given number of outputs in addition to the ones required to
+// reach SVH.
+func fundExtraOutputs(t *testing.T, extra int) func(w *VotingWallet) {
+	t.Helper()
+
+	return func(w *VotingWallet) {
+		n := requiredTicketCount(w.hn.ActiveNet) + extra
+		if err := w.SetInitialFundingOutputs(n); err != nil {
+			t.Fatalf("unable to set initial funding outputs: %v", err)
+		}
+	}
+}
+
+// testDefaultChangeScript tests that a wallet created with a custom default
+// change script uses it on the tickets it purchases.
+func testDefaultChangeScript(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Invalid change scripts are rejected.
+	_, err := NewVotingWallet(ctx, vw.hn, WithDefaultChangeScript([]byte{0x6a}))
+	if err == nil {
+		t.Fatalf("unexpected success creating wallet with invalid change " +
+			"script")
+	}
+
+	burnHash := bytes.Repeat([]byte{0xde}, 20)
+	burnAddr, err := stdaddr.NewAddressPubKeyHashEcdsaSecp256k1V0(burnHash,
+		vw.hn.ActiveNet)
+	if err != nil {
+		t.Fatalf("unable to create burn address: %v", err)
+	}
+	_, changeScript := burnAddr.StakeChangeScript()
+	w := replaceWallet(ctx, t, vw, nil, WithDefaultChangeScript(changeScript))
+
+	targetHeight := ticketPurchaseStartHeight(w.hn.ActiveNet) + 1
+	_, err = w.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.mtx.Lock()
+	tickets := make([]chainhash.Hash, 0, len(w.tickets))
+	for ticketHash := range w.tickets {
+		tickets = append(tickets, ticketHash)
+	}
+	w.mtx.Unlock()
+	if len(tickets) == 0 {
+		t.Fatalf("no tickets purchased")
+	}
+	for i := range tickets {
+		ticket, err := w.hn.Node.GetRawTransaction(ctx, &tickets[i])
+		if err != nil {
+			t.Fatalf("unable to get ticket %s: %v", &tickets[i], err)
+		}
+		gotScript := ticket.MsgTx().TxOut[2].PkScript
+		if !bytes.Equal(gotScript, changeScript) {
+			t.Fatalf("ticket %s has unexpected change script %x; want %x",
+				&tickets[i], gotScript, changeScript)
+		}
+	}
+}
+
+// testSetAgendaChoices tests that votes cast after setting agenda choices use
+// the corresponding vote bits and vote version.
+func testSetAgendaChoices(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Simnet does not define any deployments, so use a copy of its params
+	// with a test agenda.
+	const voteVersion = 10
+	agenda := &chaincfg.Vote{
+		Id:   "testagenda",
+		Mask: 0x0006,
+		Choices: []chaincfg.Choice{
+			{Id: "abstain", Bits: 0x0000, IsAbstain: true},
+			{Id: "no", Bits: 0x0002, IsNo: true},
+			{Id: "yes", Bits: 0x0004},
+		},
+	}
+	yesChoice := &agenda.Choices[2]
+	params := *vw.hn.ActiveNet
+	params.Deployments = map[uint32][]chaincfg.ConsensusDeployment{
+		voteVersion: {{Vote: *agenda}},
+	}
+	vw.hn.ActiveNet = &params
+
+	err := vw.SetAgendaChoices(map[string]string{"unknown": "yes"})
+	if err == nil {
+		t.Fatalf("unexpected success setting choice of unknown agenda")
+	}
+	err = vw.SetAgendaChoices(map[string]string{agenda.Id: "unknown"})
+	if err == nil {
+		t.Fatalf("unexpected success setting unknown choice")
+	}
+	err = vw.SetAgendaChoices(map[string]string{agenda.Id: yesChoice.Id})
+	if err != nil {
+		t.Fatalf("unable to set agenda choices: %v", err)
+	}
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err = vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, votes, _, err := vw.GenerateOneBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(votes) == 0 {
+		t.Fatalf("no votes from the wallet in block")
+	}
+	wantBits := voteBitsBlockValid | yesChoice.Bits
+	for _, voteHash := range votes {
+		vote, err := vw.hn.Node.GetRawTransaction(ctx, voteHash)
+		if err != nil {
+			t.Fatalf("unable to get vote %s: %v", voteHash, err)
+		}
+		gotBits := stake.SSGenVoteBits(vote.MsgTx())
+		if gotBits != wantBits {
+			t.Fatalf("vote %s has unexpected vote bits; got %#04x, "+
+				"want %#04x", voteHash, gotBits, wantBits)
+		}
+		gotVersion := stake.SSGenVersion(vote.MsgTx())
+		if gotVersion != voteVersion {
+			t.Fatalf("vote %s has unexpected vote version; got %d, "+
+				"want %d", voteHash, gotVersion, voteVersion)
+		}
+	}
+}
+
+// testVoteChoicesSelector tests that the tickets of a wallet cast the agenda
+// choices selected per ticket.
+func testVoteChoicesSelector(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Simnet does not define any deployments, so use a copy of its params
+	// with a test agenda.
+	const voteVersion = 10
+	agenda := &chaincfg.Vote{
+		Id:   "testagenda",
+		Mask: 0x0006,
+		Choices: []chaincfg.Choice{
+			{Id: "abstain", Bits: 0x0000, IsAbstain: true},
+			{Id: "no", Bits: 0x0002, IsNo: true},
+			{Id: "yes", Bits: 0x0004},
+		},
+	}
+	params := *vw.hn.ActiveNet
+	params.Deployments = map[uint32][]chaincfg.ConsensusDeployment{
+		voteVersion: {{Vote: *agenda}},
+	}
+	vw.hn.ActiveNet = &params
+
+	// Split the tickets of the wallet between yes and no votes.
+	choiceOf := func(ticket *chainhash.Hash) string {
+		if ticket[0]%2 == 0 {
+			return "yes"
+		}
+		return "no"
+	}
+	vw.SetVoteChoicesSelector(func(ticket *chainhash.Hash) map[string]string {
+		return map[string]string{agenda.Id: choiceOf(ticket)}
+	})
+
+	targetHeight := vw.hn.ActiveNet.StakeValidationHeight + 2
+	_, err := vw.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tally the choices of the votes included in the next blocks.
+	const nbBlocks = 4
+	wantTally := make(map[string]int)
+	gotTally := make(map[string]int)
+	for i := 0; i < nbBlocks; i++ {
+		_, votes, _, err := vw.GenerateOneBlock(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, voteHash := range votes {
+			vote, err := vw.hn.Node.GetRawTransaction(ctx, voteHash)
+			if err != nil {
+				t.Fatalf("unable to get vote %s: %v", voteHash, err)
+			}
+			tx := vote.MsgTx()
+			gotVersion := stake.SSGenVersion(tx)
+			if gotVersion != voteVersion {
+				t.Fatalf("vote %s has unexpected vote version; got %d, "+
+					"want %d", voteHash, gotVersion, voteVersion)
+			}
+			gotBits := stake.SSGenVoteBits(tx)
+			if gotBits&voteBitsBlockValid == 0 {
+				t.Fatalf("vote %s does not approve the previous block",
+					voteHash)
+			}
+			for _, choice := range agenda.Choices {
+				if gotBits&agenda.Mask == choice.Bits {
+					gotTally[choice.Id]++
+				}
+			}
+			wantTally[choiceOf(&tx.TxIn[1].PreviousOutPoint.Hash)]++
+		}
+	}
+
+	if wantTally["yes"] == 0 || wantTally["no"] == 0 {
+		t.Fatalf("votes were not split between choices: %v", wantTally)
+	}
+	for _, choice := range agenda.Choices {
+		if gotTally[choice.Id] != wantTally[choice.Id] {
+			t.Fatalf("unexpected number of %q votes; got %d, want %d",
+				choice.Id, gotTally[choice.Id], wantTally[choice.Id])
+		}
+	}
+}
+
+// testInsufficientLiveTickets tests that generating blocks fails with a
+// descriptive error when the live ticket pool cannot sustain voting.
+func testInsufficientLiveTickets(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	// Stop the wallet before it purchases any tickets and advance the chain
+	// up to the last block that may be generated without ticket purchases.
+	if err := vw.Stop(); err != nil {
+		t.Fatalf("unable to stop wallet: %v", err)
+	}
+	_, startHeight, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatalf("unable to obtain best block: %v", err)
+	}
+	targetHeight := ticketPurchaseStartHeight(vw.hn.ActiveNet)
+	if startHeight >= targetHeight {
+		t.Fatalf("wallet may have purchased tickets at height %d",
+			startHeight)
+	}
+	for h := startHeight + 1; h <= targetHeight; h++ {
+		if _, err := vw.miner(ctx, 1); err != nil {
+			t.Fatalf("unable to generate block at height %d: %v", h, err)
+		}
+	}
+
+	// Use an observer wallet to attempt to generate the next block.
+	observer, err := NewVotingWallet(ctx, vw.hn)
+	if err != nil {
+		t.Fatalf("unable to create observer wallet: %v", err)
+	}
+	defer observer.Stop()
+	observer.SetObserverMode(true)
+	if err := observer.Start(ctx); err != nil {
+		t.Fatalf("unable to start observer wallet: %v", err)
+	}
+	observer.SetMiner(vw.miner)
+
+	_, err = observer.GenerateBlocks(ctx, 1)
+	if !errors.Is(err, ErrInsufficientLiveTickets) {
+		t.Fatalf("unexpected error; got %v, want %v", err,
+			ErrInsufficientLiveTickets)
+	}
+	_, height, err := vw.hn.Node.GetBestBlock(ctx)
+	if err != nil {
+		t.Fatalf("unable to obtain best block: %v", err)
+	}
+	if height != targetHeight {
+		t.Fatalf("unexpected block generated at height %d", height)
+	}
+}
+
+// testSetFeeRate tests that the tickets of a wallet pay the configured fee
+// rate.
+func testSetFeeRate(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	const rate = dcrutil.Amount(3e4)
+	var mtx sync.Mutex
+	var ticketFees []*TxFeeInfo
+	w := replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		w.SetFeeRate(rate)
+		w.SetFeeReporting(func(info *TxFeeInfo) {
+			if info.TxType != stake.TxTypeSStx {
+				return
+			}
+			mtx.Lock()
+			ticketFees = append(ticketFees, info)
+			mtx.Unlock()
+		})
+	})
+
+	targetHeight := ticketPurchaseStartHeight(w.hn.ActiveNet) + 2
+	_, err := w.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(ticketFees) == 0 {
+		t.Fatalf("no ticket fees reported")
+	}
+	maxFeeRate := rate + rate/50
+	for _, info := range ticketFees {
+		if info.FeePerKB < rate || info.FeePerKB > maxFeeRate {
+			t.Fatalf("ticket %s has unexpected fee rate %d; want between "+
+				"%d and %d", info.Hash, info.FeePerKB, rate, maxFeeRate)
+		}
+	}
+}
+
+// testSetCommitAmountMultiplier tests that the inputs of tickets are funded
+// according to the configured commit amount multiplier.
+func testSetCommitAmountMultiplier(ctx context.Context, t *testing.T, vw *VotingWallet) {
+	if err := vw.SetCommitAmountMultiplier(3); err == nil {
+		t.Fatalf("unexpected success changing multiplier of started wallet")
+	}
+
+	const mult = 3
+	w := replaceWallet(ctx, t, vw, func(w *VotingWallet) {
+		if err := w.SetCommitAmountMultiplier(1); err == nil {
+			t.Fatalf("unexpected success setting multiplier that " +
+				"leaves dust")
+		}
+		if err := w.SetCommitAmountMultiplier(mult); err != nil {
+			t.Fatalf("unable to set commit amount multiplier: %v", err)
+		}
+	})
+
+	wantAmount := w.hn.ActiveNet.MinimumStakeDiff * mult
+	w.mtx.Lock()
+	for _, utxo := range w.utxos {
+		if utxo.amount != wantAmount {
+			w.mtx.Unlock()
+			t.Fatalf("utxo %s has unexpected amount %d; want %d",
+				&utxo.outpoint, utxo.amount, wantAmount)
+		}
+	}
+	w.mtx.Unlock()
+
+	// Ensure tickets can be purchased with the funded inputs.
+	targetHeight := ticketPurchaseStartHeight(w.hn.ActiveNet) + 2
+	_, err := w.GenerateBlocksToHeight(ctx, targetHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// votingWalletTestCase is a test case that exercises a voting wallet that has
+// already been started.
+type votingWalletTestCase struct {
+	name string
+	f    func(ctx context.Context, t *testing.T, vw *VotingWallet)
+}
+
+// newVotingWalletHarness creates and sets up a new harness for the voting
+// wallet tests that is torn down once the given test completes.
+func newVotingWalletHarness(t *testing.T) *Harness {
+	var handlers *rpcclient.NotificationHandlers
+	net := chaincfg.SimNetParams()
+
+	extraArgs := []string{
+		"--debuglevel=debug",
+	}
+
+	hn, err := New(t, net, handlers, extraArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := hn.TearDown(); err != nil {
+			t.Errorf("unable to tear down harness: %v", err)
+		}
+	})
+
+	err = hn.SetUp(true, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hn
+}
+
+// runVotingWalletTestCase creates and starts a new voting wallet for the given
+// harness, and then runs the given test case with it. The wallet mines blocks
+// with the given miner, or the default miner of the wallet when it is nil.
+func runVotingWalletTestCase(t *testing.T, hn *Harness, tc *votingWalletTestCase,
+	miner func(context.Context, uint32) ([]*chainhash.Hash, error)) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vw, err := NewVotingWallet(ctx, hn)
+	if err != nil {
+		t.Fatalf("unable to create voting wallet for test: %v", err)
+	}
+
+	err = vw.Start(ctx)
+	if err != nil {
+		t.Fatalf("unable to setup voting wallet: %v", err)
+	}
+
+	defer vw.Stop()
+
+	vw.SetErrorReporting(func(vwerr error) {
+		t.Errorf("voting wallet errored: %v", vwerr)
+	})
+	if miner != nil {
+		vw.SetMiner(miner)
+	}
+
+	tc.f(ctx, t, vw)
+
+	vw.SetErrorReporting(nil)
+}
+
+func TestMinimalVotingWallet(t *testing.T) {
+	// Skip tests when running with -short
+	if testing.Short() {
+		t.Skip("Skipping minimal voting wallet in short mode")
+	}
+
+	logDir := "./dcrdlogs"
+	info, err := os.Stat(logDir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("error stating log dir: %v", err)
+	}
+	if info != nil {
+		if !info.IsDir() {
+			t.Fatalf("logdir (%s) is not a dir", logDir)
+		}
+		err = os.RemoveAll(logDir)
+		if err != nil {
+			t.Fatalf("error removing logdir: %v", err)
+		}
+	}
+
+	// The shared test cases run in order against the same harness with the
+	// default miner of the wallet.
+	sharedTestCases := []votingWalletTestCase{
+		{
+			name: "can get past SVH",
+			f:    testCanPassSVH,
+		},
+	}
+
+	hn := newVotingWalletHarness(t)
+	for i := range sharedTestCases {
+		tc := &sharedTestCases[i]
+		success := t.Run(tc.name, func(t *testing.T) {
+			runVotingWalletTestCase(t, hn, tc, nil)
+		})
+		if !success {
+			return
+		}
+	}
+
+	// The remaining test cases each receive a fresh chain, so they may
+	// assume the wallet is responsible for every ticket purchase.
+	testCases := []votingWalletTestCase{
+		{
+			name: "steady state signal",
+			f:    testSteadyStateSignal,
+		},
+		{
+			name: "fee reporting",
+			f:    testFeeReporting,
+		},
+		{
+			name: "ticket purchased callback",
+			f:    testTicketPurchasedCallback,
+		},
+		{
+			name: "vote cast callback",
+			f:    testVoteCastCallback,
+		},
+		{
+			name: "events",
+			f:    testEvents,
+		},
+		{
+			name: "add to treasury",
+			f:    testAddToTreasury,
+		},
+		{
+			name: "abstain tspend votes",
+			f:    testAbstainTSpendVotes,
+		},
+		{
+			name: "stakebase value",
+			f:    testStakebaseValue,
+		},
+		{
+			name: "ticket price tracks stake difficulty",
+			f:    testTicketPriceTracksStakeDifficulty,
+		},
+		{
+			name: "pending tx hashes",
+			f:    testPendingTxHashes,
+		},
+		{
+			name: "tickets per block",
+			f:    testTicketsPerBlock,
+		},
+		{
+			name: "auto refund",
+			f:    testAutoRefund,
+		},
+		{
+			name: "address",
+			f:    testAddress,
+		},
+		{
+			name: "recycle change",
+			f:    testRecycleChange,
+		},
+		{
+			name: "set fee limits",
+			f:    testSetFeeLimits,
+		},
+		{
+			name: "set vote version",
+			f:    testSetVoteVersion,
+		},
+		{
+			name: "generate blocks until",
+			f:    testGenerateBlocksUntil,
+		},
+		{
+			name: "new tickets",
+			f:    testNewTickets,
+		},
+		{
+			name: "connected height",
+			f:    testConnectedHeight,
+		},
+		{
+			name: "dry run",
+			f:    testDryRun,
+		},
+		{
+			name: "invalid ticket",
+			f:    testInvalidTicket,
+		},
+		{
+			name: "vote summary",
+			f:    testVoteSummary,
+		},
+		{
+			name: "recent wins",
+			f:    testRecentWins,
+		},
+		{
+			name: "tx signer",
+			f:    testTxSigner,
+		},
+		{
+			name: "signature type",
+			f:    testSignatureType,
+		},
+		{
+			name: "revoke all tickets",
+			f:    testRevokeAllTickets,
+		},
+		{
+			name: "total subsidy earned",
+			f:    testTotalSubsidyEarned,
+		},
+		{
+			name: "notification buffer length",
+			f:    testNotificationBufferLen,
+		},
+		{
+			name: "notification backlog",
+			f:    testNotificationBacklog,
+		},
+		{
+			name: "snapshot restore",
+			f:    testSnapshotRestore,
+		},
+		{
+			name: "logger",
+			f:    testLogger,
+		},
+		{
+			name: "maturing vote schedule",
+			f:    testMaturingVoteSchedule,
+		},
+		{
+			name: "wait for sync",
+			f:    testWaitForSync,
+		},
+		{
+			name: "start from scan",
+			f:    testStartFromScan,
+		},
+		{
+			name: "stakebase sig script",
+			f:    testStakeBaseSigScript,
+		},
+		{
+			name: "ready at svh",
+			f:    testReadyAtSVH,
+		},
+		{
+			name: "generate blocks cancel",
+			f:    testGenerateBlocksCancel,
+		},
+		{
+			name: "block validity",
+			f:    testBlockValidity,
+		},
+		{
+			name: "initial funding outputs",
+			f:    testInitialFundingOutputs,
+		},
+		{
+			name: "metrics",
+			f:    testMetrics,
+		},
+		{
+			name: "ticket price strategy",
+			f:    testTicketPriceStrategy,
+		},
+		{
+			name: "limit votes by fraction",
+			f:    testLimitVotesByFraction,
+		},
+		{
+			name: "increase vote limit",
+			f:    testIncreaseVoteLimit,
+		},
+		{
+			name: "generate block",
+			f:    testGenerateBlock,
+		},
+		{
+			name: "batch miner",
+			f:    testBatchMiner,
+		},
+		{
+			name: "spent funding utxo",
+			f:    testSpentFundingUtxo,
+		},
+		{
+			name: "private key",
+			f:    testPrivateKey,
+		},
+		{
+			name: "extra vote output",
+			f:    testExtraVoteOutput,
+		},
+		{
+			name: "generate blocks until mined",
+			f:    testGenerateBlocksUntilMined,
+		},
+		{
+			name: "vote selection",
+			f:    testVoteSelection,
+		},
+		{
+			name: "pause ticket buying",
+			f:    testPauseTicketBuying,
+		},
+		{
+			name: "block verifier",
+			f:    testBlockVerifier,
+		},
+		{
+			name: "multiple tspend votes",
+			f:    testMultipleTSpendVotes,
+		},
+		{
+			name: "send to address",
+			f:    testSendToAddress,
+		},
+		{
+			name: "create tspend",
+			f:    testCreateTSpend,
+		},
+		{
+			name: "observer mode",
+			f:    testObserverMode,
+		},
+		{
+			name: "reorg duplicate winner",
+			f:    testReorgDuplicateWinner,
+		},
+		{
+			name: "reorg recovery",
+			f:    testReorgRecovery,
+		},
+		{
+			name: "buy ticket from stake output",
+			f:    testBuyTicketFromStakeOutput,
+		},
+		{
+			name: "stop",
+			f:    testStop,
+		},
+		{
+			name: "missed tickets",
+			f:    testMissedTickets,
+		},
+		{
+			name: "missed vote rate",
+			f:    testMissedVoteRate,
+		},
+		{
+			name: "new voting wallet with key",
+			f:    testNewVotingWalletWithKey,
+		},
+		{
+			name: "shared ticket pool",
+			f:    testSharedTicketPool,
+		},
+		{
+			name: "assert full participation",
+			f:    testAssertFullParticipation,
+		},
+		{
+			name: "revoke missed tickets",
+			f:    testRevokeMissedTickets,
+		},
+		{
+			name: "fail fast",
+			f:    testFailFast,
+		},
+		{
+			name: "generate blocks to height",
+			f:    testGenerateBlocksToHeight,
+		},
+		{
+			name: "generate one block",
+			f:    testGenerateOneBlock,
+		},
+		{
+			name: "generate blocks with stats",
+			f:    testGenerateBlocksWithStats,
+		},
+		{
+			name: "block ref script func",
+			f:    testBlockRefScriptFunc,
+		},
+		{
+			name: "live ticket count",
+			f:    testLiveTicketCount,
+		},
+		{
+			name: "spendable balance",
+			f:    testSpendableBalance,
+		},
+		{
+			name: "limited votes full tickets",
+			f:    testLimitedVotesFullTickets,
+		},
+		{
+			name: "concurrent access",
+			f:    testConcurrentAccess,
+		},
+		{
+			name: "block generation timeout",
+			f:    testBlockGenTimeout,
+		},
+		{
+			name: "wait for mature tickets",
+			f:    testWaitForMatureTickets,
+		},
+		{
+			name: "wait for mempool removal",
+			f:    testWaitForMempoolRemoval,
+		},
+		{
+			name: "deterministic timestamps",
+			f:    testDeterministicTimestamps,
+		},
+		{
+			name: "total burned change",
+			f:    testTotalBurnedChange,
+		},
+		{
+			name: "max sustainable height",
+			f:    testMaxSustainableHeight,
+		},
+		{
+			name: "early maturity",
+			f:    testEarlyMaturity,
+		},
+		{
+			name: "vote record",
+			f:    testVoteRecord,
+		},
+		{
+			name: "set vote bits",
+			f:    testSetVoteBits,
+		},
+		{
+			name: "default change script",
+			f:    testDefaultChangeScript,
+		},
+		{
+			name: "set agenda choices",
+			f:    testSetAgendaChoices,
+		},
+		{
+			name: "vote choices selector",
+			f:    testVoteChoicesSelector,
+		},
+		{
+			name: "insufficient live tickets",
+			f:    testInsufficientLiveTickets,
+		},
+		{
+			name: "set fee rate",
+			f:    testSetFeeRate,
+		},
+		{
+			name: "set commit amount multiplier",
+			f:    testSetCommitAmountMultiplier,
+		},
+	}
+
+	for i := range testCases {
+		tc := &testCases[i]
+		success := t.Run(tc.name, func(t *testing.T) {
+			hn := newVotingWalletHarness(t)
+			miner := func(ctx context.Context, nb uint32) ([]*chainhash.Hash, error) {
+				return AdjustedSimnetMiner(ctx, hn.Node, nb)
+			}
+			runVotingWalletTestCase(t, hn, tc, miner)
+		})
+		if !success {
+			break
+		}
 	}
 }